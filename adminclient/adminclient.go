@@ -0,0 +1,286 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package adminclient is a client for the etcd-mesos scheduler's admin HTTP
+// interface, so that other Go services need not hand-roll requests to it.
+package adminclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/etcd-mesos/config"
+	"github.com/mesosphere/etcd-mesos/scheduler"
+)
+
+// DefaultTimeout bounds each request made by a Client from New.
+const DefaultTimeout = 10 * time.Second
+
+// Client makes requests to the admin interface of a scheduler.
+type Client struct {
+	// BaseURL is the admin interface's URL, such as
+	// http://scheduler.example.com:23400.
+	BaseURL string
+	// HTTPClient makes the requests.
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the admin interface at baseURL.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// Error is returned for a response with an unexpected status code.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("adminclient: %d: %s", e.StatusCode, e.Message)
+}
+
+// Stats returns the scheduler's statistics.
+func (c *Client) Stats() (scheduler.Stats, error) {
+	var stats scheduler.Stats
+	err := c.getJSON("/stats", &stats)
+	return stats, err
+}
+
+// Members returns the running etcd members.
+func (c *Client) Members() ([]config.Node, error) {
+	members := []config.Node{}
+	err := c.getJSON("/members", &members)
+	return members, err
+}
+
+// Endpoints returns the client URLs of the running members, or of only
+// those that pass a health check if healthyOnly is set.
+func (c *Client) Endpoints(healthyOnly bool) ([]string, error) {
+	endpoints := []string{}
+	err := c.getJSON("/endpoints?healthy="+strconv.FormatBool(healthyOnly), &endpoints)
+	return endpoints, err
+}
+
+// Framework returns the scheduler's framework ID and current master.
+func (c *Client) Framework() (scheduler.FrameworkState, error) {
+	var state scheduler.FrameworkState
+	err := c.getJSON("/framework", &state)
+	return state, err
+}
+
+// LastContact returns when each running member last answered a request
+// from the scheduler.
+func (c *Client) LastContact() (map[string]scheduler.MemberContact, error) {
+	contacts := map[string]scheduler.MemberContact{}
+	err := c.getJSON("/last-contact", &contacts)
+	return contacts, err
+}
+
+// Operations returns the in-flight long-running operations.
+func (c *Client) Operations() ([]scheduler.Operation, error) {
+	operations := []scheduler.Operation{}
+	err := c.getJSON("/operations", &operations)
+	return operations, err
+}
+
+// CancelOperation requests that the operation with id stop at its next
+// checkpoint.
+func (c *Client) CancelOperation(id string) error {
+	_, err := c.do("DELETE", "/operations/"+url.PathEscape(id), nil)
+	return err
+}
+
+// Healthy returns whether the scheduler considers the cluster healthy.
+func (c *Client) Healthy() (bool, error) {
+	return c.check("/healthz")
+}
+
+// Ready returns whether the cluster is healthy and a majority of its
+// members are running, so that it can serve writes.
+func (c *Client) Ready() (bool, error) {
+	return c.check("/ready")
+}
+
+// Reseed triggers a cluster reseed.  Use extreme caution!
+func (c *Client) Reseed() error {
+	_, err := c.do("GET", "/reseed", nil)
+	return err
+}
+
+// Defrag starts defragmenting the members one at a time.
+func (c *Client) Defrag() error {
+	_, err := c.do("POST", "/defrag", nil)
+	return err
+}
+
+// Compact discards the keyspace history before rev, or all but the
+// scheduler's configured retention if rev is 0, returning the revision
+// compacted to.
+func (c *Client) Compact(rev int64) (int64, error) {
+	form := url.Values{}
+	if rev != 0 {
+		form.Set("rev", strconv.FormatInt(rev, 10))
+	}
+	body, err := c.do("POST", "/compact", form)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimPrefix(body, "compacted to revision "), 10, 64)
+}
+
+// Snapshot downloads a snapshot of a member's backend into w, returning
+// the number of bytes written.  Snapshots of large backends may take
+// longer than DefaultTimeout, needing a Client with a longer timeout.
+func (c *Client) Snapshot(w io.Writer) (int64, error) {
+	resp, err := c.HTTPClient.Get(c.BaseURL + "/snapshot")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// Kill kills the member named node without deconfiguring it first.
+func (c *Client) Kill(node string) error {
+	_, err := c.do("POST", "/kill", url.Values{"node": {node}})
+	return err
+}
+
+// ForceRemove deconfigures the member named node without regard for
+// quorum.  confirm must match the scheduler's -force-remove-token.
+func (c *Client) ForceRemove(node, confirm string) error {
+	_, err := c.do("POST", "/force-remove",
+		url.Values{"node": {node}, "confirm": {confirm}})
+	return err
+}
+
+// LeaveSafeMode allows a scheduler started with -safe-mode to launch and
+// remove members.
+func (c *Client) LeaveSafeMode() error {
+	_, err := c.do("POST", "/unsafe", nil)
+	return err
+}
+
+// SingleInstancePerSlave returns whether members are kept on separate
+// slaves.
+func (c *Client) SingleInstancePerSlave() (bool, error) {
+	body, err := c.do("GET", "/single-instance-per-slave", nil)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(body)
+}
+
+// SetSingleInstancePerSlave changes whether members are kept on separate
+// slaves until the scheduler restarts.
+func (c *Client) SetSingleInstancePerSlave(single bool) error {
+	_, err := c.do("POST", "/single-instance-per-slave",
+		url.Values{"enabled": {strconv.FormatBool(single)}})
+	return err
+}
+
+// RollingUpgrade starts replacing every member not already running
+// version, launching the replacements with the executor uris if any are
+// given.
+func (c *Client) RollingUpgrade(version string, uris ...string) error {
+	_, err := c.do("POST", "/rolling-upgrade",
+		url.Values{"version": {version}, "uri": uris})
+	return err
+}
+
+// check returns whether a health endpoint answers 200, treating the
+// statuses it answers an unhealthy cluster with as false.
+func (c *Client) check(path string) (bool, error) {
+	_, err := c.do("GET", path, nil)
+	if e, ok := err.(*Error); ok &&
+		(e.StatusCode == http.StatusInternalServerError ||
+			e.StatusCode == http.StatusServiceUnavailable) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// getJSON decodes the JSON response to a GET of path into out.
+func (c *Client) getJSON(path string, out interface{}) error {
+	req, err := http.NewRequest("GET", c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do makes a request to path, sending form as the body if it is not nil,
+// and returns the response body.
+func (c *Client) do(method, path string, form url.Values) (string, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return "", err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return "", err
+	}
+	text, err := ioutil.ReadAll(resp.Body)
+	return strings.TrimSpace(string(text)), err
+}
+
+// checkStatus returns an *Error for a response that is not a success.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	text, _ := ioutil.ReadAll(resp.Body)
+	return &Error{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(text)),
+	}
+}
@@ -0,0 +1,128 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package adminclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	mesosscheduler "github.com/mesos/mesos-go/scheduler"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/scheduler"
+)
+
+// fakeDriver signals when the scheduler aborts, and panics if any other
+// driver method is called.
+type fakeDriver struct {
+	mesosscheduler.SchedulerDriver
+	aborted chan struct{}
+}
+
+func (d *fakeDriver) Abort() (mesos.Status, error) {
+	d.aborted <- struct{}{}
+	return mesos.Status_DRIVER_ABORTED, nil
+}
+
+func newTestClient(t *testing.T) (*Client, *scheduler.EtcdScheduler, *fakeDriver, func()) {
+	s := scheduler.NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	s.FrameworkName = "etcd-test"
+	driver := &fakeDriver{aborted: make(chan struct{}, 1)}
+	server := httptest.NewServer(s.AdminHandler(driver))
+	return New(server.URL + "/"), s, driver, server.Close
+}
+
+func TestClientReadsState(t *testing.T) {
+	c, s, _, done := newTestClient(t)
+	defer done()
+	s.Stats.RunningServers = 2
+	s.Stats.ClusterReseeds = 1
+
+	stats, err := c.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), stats.RunningServers)
+	assert.Equal(t, uint32(1), stats.ClusterReseeds)
+
+	members, err := c.Members()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(members))
+
+	endpoints, err := c.Endpoints(false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(endpoints))
+
+	framework, err := c.Framework()
+	assert.NoError(t, err)
+	assert.Equal(t, "etcd-test", framework.FrameworkName)
+
+	contacts, err := c.LastContact()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(contacts))
+
+	operations, err := c.Operations()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(operations))
+
+	// Healthy, but with no members running the cluster can't serve writes.
+	healthy, err := c.Healthy()
+	assert.NoError(t, err)
+	assert.True(t, healthy)
+	ready, err := c.Ready()
+	assert.NoError(t, err)
+	assert.False(t, ready)
+}
+
+func TestClientChangesState(t *testing.T) {
+	c, s, driver, done := newTestClient(t)
+	defer done()
+
+	// With no running members to reseed from, the scheduler gives up.
+	assert.NoError(t, c.Reseed())
+	select {
+	case <-driver.aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reseed did not run")
+	}
+
+	single, err := c.SingleInstancePerSlave()
+	assert.NoError(t, err)
+	assert.True(t, single)
+	assert.NoError(t, c.SetSingleInstancePerSlave(false))
+	assert.False(t, s.SingleInstancePerSlave())
+
+	// Failures come back as an *Error carrying the status code.
+	err = c.Kill("etcd-1")
+	if e, ok := err.(*Error); assert.True(t, ok, "got %v", err) {
+		assert.Equal(t, http.StatusNotFound, e.StatusCode)
+	}
+	err = c.ForceRemove("etcd-1", "token")
+	if e, ok := err.(*Error); assert.True(t, ok, "got %v", err) {
+		assert.Equal(t, http.StatusForbidden, e.StatusCode)
+	}
+	err = c.CancelOperation("1")
+	if e, ok := err.(*Error); assert.True(t, ok, "got %v", err) {
+		assert.Equal(t, http.StatusNotFound, e.StatusCode)
+	}
+	err = c.RollingUpgrade("")
+	if e, ok := err.(*Error); assert.True(t, ok, "got %v", err) {
+		assert.Equal(t, http.StatusBadRequest, e.StatusCode)
+	}
+}
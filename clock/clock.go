@@ -0,0 +1,113 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package clock abstracts the passage of time so that timing behavior,
+// such as backoffs, livelock windows and reseed timeouts, can be tested
+// deterministically.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for it to pass.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// Real is a Clock backed by the time package.
+type Real struct{}
+
+func (Real) Now() time.Time                         { return time.Now() }
+func (Real) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (Real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (Real) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// Fake is a Clock whose time only moves when Advance is called.
+type Fake struct {
+	mut     sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewFake returns a Fake clock reading now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// After returns a channel that receives the time once the clock has been
+// advanced by at least d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, waiter{until: f.now.Add(d), ch: ch})
+	return ch
+}
+
+// Sleep blocks until the clock has been advanced by at least d.
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// Advance moves the clock forward by d, waking any waiters that are due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.now = f.now.Add(d)
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.until.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- f.now
+	}
+	f.waiters = pending
+}
+
+// Waiters returns the number of callers blocked in After or Sleep, so that
+// tests can wait for a goroutine to start waiting before advancing.
+func (f *Fake) Waiters() int {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return len(f.waiters)
+}
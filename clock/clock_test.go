@@ -0,0 +1,74 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeAdvance(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+	ch := f.After(time.Minute)
+	if got := f.Waiters(); got != 1 {
+		t.Errorf("got %d waiters, want 1", got)
+	}
+
+	f.Advance(59 * time.Second)
+	select {
+	case <-ch:
+		t.Error("After fired before its deadline")
+	default:
+	}
+
+	f.Advance(time.Second)
+	select {
+	case now := <-ch:
+		if want := start.Add(time.Minute); !now.Equal(want) {
+			t.Errorf("got %v, want %v", now, want)
+		}
+	default:
+		t.Error("After did not fire at its deadline")
+	}
+	if got := f.Since(start); got != time.Minute {
+		t.Errorf("got %v since start, want 1m", got)
+	}
+	if got := f.Waiters(); got != 0 {
+		t.Errorf("got %d waiters, want 0", got)
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(time.Second)
+		close(done)
+	}()
+	for f.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	f.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Sleep did not return after the clock advanced")
+	}
+}
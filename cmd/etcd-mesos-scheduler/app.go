@@ -19,12 +19,14 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	log "github.com/golang/glog"
@@ -36,6 +38,8 @@ import (
 	"github.com/samuel/go-zookeeper/zk"
 	"golang.org/x/net/context"
 
+	"github.com/mesosphere/etcd-mesos/config"
+	"github.com/mesosphere/etcd-mesos/offercache"
 	"github.com/mesosphere/etcd-mesos/rpc"
 	etcdscheduler "github.com/mesosphere/etcd-mesos/scheduler"
 )
@@ -54,10 +58,19 @@ func parseIP(address string) net.IP {
 func main() {
 	frameworkName :=
 		flag.String("framework-name", "etcd", "Unique name of this etcd cluster")
+	initialClusterToken :=
+		flag.String("initial-cluster-token", "", "etcd --initial-cluster-token for "+
+			"new members.  Defaults to one derived from -framework-name")
+	discoveryURL :=
+		flag.String("discovery-url", "", "etcd discovery URL, sized for -cluster-size, "+
+			"that the members of a new cluster bootstrap against")
 	master :=
 		flag.String("master", "127.0.0.1:5050", "Master address <ip:port>")
 	zkFrameworkPersist :=
 		flag.String("zk-framework-persist", "", "Zookeeper URI of the form zk://host1:port1,host2:port2/chroot/path")
+	zkNamespace :=
+		flag.String("zk-namespace", "", "Path, such as /etcd-mesos/prod, prefixed onto the "+
+			"chroot of -zk-framework-persist for all of the scheduler's znodes")
 	taskCount :=
 		flag.Int("cluster-size", 5, "Total task count to run")
 	adminPort :=
@@ -93,14 +106,270 @@ func main() {
 		flag.String("mesos-authentication-secret-file", "", "Mesos authentication secret file")
 	mesosOfferRefuseSeconds :=
 		flag.Float64("mesos-offer-refuse-seconds", 15, "Mesos offer refuse seconds")
+	clusterAttribute :=
+		flag.String("cluster-attribute", "", "Slave attribute listing the etcd-mesos frameworks "+
+			"with members on a slave.  Slaves listing other frameworks are used last")
+	minAgentCpus :=
+		flag.Float64("min-agent-cpus", 0, "Decline offers from slaves offering fewer cpus "+
+			"than this in total, even if a task would fit")
+	minAgentMem :=
+		flag.Float64("min-agent-mem", 0, "Decline offers from slaves offering less mem, "+
+			"in MB, than this in total, even if a task would fit")
+	minAgentDisk :=
+		flag.Float64("min-agent-disk", 0, "Decline offers from slaves offering less disk, "+
+			"in MB, than this in total, even if a task would fit")
+	avoidOtherClusters :=
+		flag.Bool("avoid-other-clusters", false, "Never place members on slaves whose "+
+			"-cluster-attribute lists another etcd-mesos framework")
+	maxReseeds :=
+		flag.Int("max-reseeds", 1, "Maximum number of automatic reseeds within "+
+			"-reseed-window, not counting those requested through /reseed.  "+
+			"0 removes the limit")
+	reseedWindow :=
+		flag.Duration("reseed-window", 10*time.Minute, "Window over which -max-reseeds applies")
+	snapshotInterval :=
+		flag.Duration("running-snapshot-interval", 30*time.Second, "How often to persist "+
+			"the running members to ZK, so that a restarted scheduler knows them before "+
+			"reconciliation completes; 0 disables")
+	lostGracePeriod :=
+		flag.Duration("lost-grace-period", 0, "Time to wait for a member reported "+
+			"TASK_LOST to recover before replacing it")
+	failureThreshold :=
+		flag.Int("failure-threshold", 1, "Number of times a member must be reported "+
+			"TASK_FAILED within -failure-window before it is replaced")
+	failureWindow :=
+		flag.Duration("failure-window", 10*time.Minute, "Window over which "+
+			"-failure-threshold applies")
+	leaderStableWindow :=
+		flag.Duration("leader-stable-window", 5*time.Second, "Time the etcd leader must "+
+			"remain unchanged before a new member is added, so that consecutive adds do "+
+			"not cause repeated elections")
+	chillPerMember :=
+		flag.Duration("chill-per-member", 0, "Time added, for each running member, to "+
+			"the 10s waited for the cluster to settle between launches.  0 keeps the wait flat")
+	killGracePeriod :=
+		flag.Duration("kill-grace-period", 10*time.Second, "Time etcd is given to shut "+
+			"down cleanly after SIGTERM when its task is killed, before it is sent SIGKILL")
+	reseedCooldown :=
+		flag.Duration("reseed-cooldown", 30*time.Second, "Time to wait after a reseed "+
+			"before adding members to the new seed")
+	reseedKillTimeout :=
+		flag.Duration("reseed-kill-timeout", time.Minute, "Time to wait for the members "+
+			"of the previous cluster to be confirmed killed after a reseed, before "+
+			"resuming launches")
+	reseedHealthTimeout :=
+		flag.Duration("reseed-health-timeout", 0, "Time to wait for a member restarted "+
+			"with --force-new-cluster to become healthy before trying the next "+
+			"candidate.  0 waits for -reseed-timeout")
+	reseedHealthBackoffCap :=
+		flag.Duration("reseed-health-backoff-cap", 8*time.Second, "Longest wait, "+
+			"doubling from a second, between health checks of a reseed candidate.  "+
+			"Must be at least 1s")
+	fullRefuseSeconds :=
+		flag.Float64("full-offer-refuse-seconds", 300, "Mesos offer refuse seconds while the "+
+			"cluster is already running --cluster-size members")
+	transientRefuseSeconds :=
+		flag.Float64("transient-offer-refuse-seconds", 5, "Mesos offer refuse seconds "+
+			"for offers short on ports or disk, which other tasks often free soon")
+	undersizedRefuseSeconds :=
+		flag.Float64("undersized-offer-refuse-seconds", 300, "Mesos offer refuse "+
+			"seconds for offers from slaves below the -min-agent-* capacity")
 	authProvider :=
 		flag.String("mesos-authentication-provider", sasl.ProviderName,
 			fmt.Sprintf("Authentication provider to use, default is SASL that supports mechanisms: %+v", mech.ListSupported()))
 	singleInstancePerSlave :=
 		flag.Bool("single-instance-per-slave", true, "Only allow one etcd instance to be started per slave")
+	rebalanceInterval :=
+		flag.Duration("rebalance-interval", 0, "How often to check for members sharing "+
+			"a slave and migrate one of them to a free slave; 0 disables")
+	zoneAttribute :=
+		flag.String("zone-attribute", "", "Slave attribute naming the zone of a slave, "+
+			"recorded on the members launched there")
+	preferredZone :=
+		flag.String("preferred-zone", "", "Zone, as named by -zone-attribute, that "+
+			"leadership is moved to when the leader is elsewhere")
+	leaderPlacementInterval :=
+		flag.Duration("leader-placement-interval", time.Minute, "How often to check "+
+			"that the leader is in -preferred-zone")
+	noMatchTimeout :=
+		flag.Duration("no-match-timeout", 0, "Report an error when every offer for this "+
+			"long was declined for the same lack of resources; 0 disables")
+	noMatchShutdown :=
+		flag.Bool("no-match-shutdown", false, "Shut down when -no-match-timeout "+
+			"is exceeded rather than only reporting it")
+	bootstrapDeadline :=
+		flag.Duration("bootstrap-deadline", 0, "Report a failed bootstrap when the "+
+			"cluster has not reached -cluster-size healthy members this long after "+
+			"the first launch of a new cluster; 0 disables")
+	bootstrapShutdown :=
+		flag.Bool("bootstrap-shutdown", false, "Shut down when -bootstrap-deadline "+
+			"is exceeded rather than only reporting it")
+	fetcherCache :=
+		flag.Bool("fetcher-cache", false, "Use the Mesos fetcher cache for the executor, "+
+			"etcd and etcdctl binaries, so that they are not downloaded for every launch")
+	dedupKey :=
+		flag.String("dedup-key", "slave-id", "What identifies a slave for "+
+			"-single-instance-per-slave: slave-id, or hostname to also keep instances "+
+			"off of slaves sharing a physical host")
 	failoverTimeoutSeconds :=
 		flag.Float64("failover-timeout-seconds", 60*60*24*7, "Mesos framework failover timeout in seconds")
+	dataHostPath :=
+		flag.String("data-host-path", "", "Absolute path on each slave under which "+
+			"each member's data directory is kept, as <path>/<framework-name>/<member>, "+
+			"so that it outlives the task")
+	user :=
+		flag.String("user", "", "User the framework and executors run as.  Defaults to "+
+			"the user running the scheduler")
+	executorSource :=
+		flag.String("executor-source", "", "Source reported for executors in Mesos, "+
+			"defaults to -framework-name")
 	weburi := flag.String("framework-weburi", "", "A URI that points to a web-based interface for interacting with the framework.")
+	removeRetries :=
+		flag.Int("remove-retries", rpc.RPC_RETRIES, "Number of attempts made to deconfigure a dead etcd member, at least 1")
+	persistRetries :=
+		flag.Int("persist-retries", rpc.RPC_RETRIES, "Number of attempts made to persist the "+
+			"framework ID to ZK on registration before exiting.  At least one is always made")
+	externalSeed :=
+		flag.String("external-seed", "", "Comma-separated existing etcd members outside of Mesos, "+
+			"as name=host:peerPort:clientPort, for the first instance to join instead of "+
+			"starting a new cluster")
+	healthCheckFormat :=
+		flag.String("health-check-format", "leader-stats", "Response format expected from "+
+			"members during health checks: leader-stats (/v2/stats/leader) or health (/health)")
+	healthCheckPath :=
+		flag.String("health-check-path", "", "Path queried on members during health checks, "+
+			"overriding the default for -health-check-format")
+	rpcRetryBudget :=
+		flag.Duration("rpc-retry-budget", 0, "Longest time a request to the etcd "+
+			"members spends retrying with backoff before giving up; 0 bounds only "+
+			"the number of retries")
+	healthCheckConcurrency :=
+		flag.Int("health-check-concurrency", 8, "Most members probed at once when "+
+			"checking the health of each member; 0 probes them all at once")
+	reregisterOnCompleted :=
+		flag.Bool("reregister-on-completed", false, "Register again as a new framework instead of exiting "+
+			"when the master reports that the persisted framework has completed")
+	removeQuorumGuard :=
+		flag.Bool("remove-quorum-guard", true, "Refuse to deconfigure a dead etcd member when the "+
+			"remaining healthy members could not form a quorum.  Disable for emergency removals")
+	forceRemoveToken :=
+		flag.String("force-remove-token", "", "Token that POSTs to the admin /force-remove "+
+			"endpoint must confirm with.  Empty disables the endpoint")
+	safeMode :=
+		flag.Bool("safe-mode", false, "Start without launching, pruning, reseeding "+
+			"or migrating members until a POST to the admin /unsafe endpoint")
+	pruneMinHealthy :=
+		flag.Int("prune-min-healthy", 0, "Minimum healthy running members needed to "+
+			"deconfigure members unknown to the scheduler, if more than a quorum")
+	launchTimeout :=
+		flag.Int("launch-timeout", 300, "Seconds to wait for a launched task to report "+
+			"its status before killing it and launching a replacement")
+	heartbeatTimeout :=
+		flag.Duration("heartbeat-timeout", 10*time.Minute, "How long the launcher "+
+			"may work on a launch, or the launch requestor go without running, "+
+			"before /healthz reports the scheduler degraded")
+	pendingAgeWarning :=
+		flag.Duration("pending-age-warning", 2*time.Minute, "Warn when a launched task "+
+			"has been pending for longer than this without reporting its status.  "+
+			"0 disables the warning")
+	executorLogDir :=
+		flag.String("executor-log-dir", "./", "Directory, relative to the task sandbox unless absolute, "+
+			"that the executor writes its logs to")
+	executorLogLevel :=
+		flag.Int("executor-log-level", 0, "glog verbosity (-v) passed to the executor")
+	quotaBackendBytes :=
+		flag.Int64("quota-backend-bytes", 0, "etcd backend size quota in bytes, "+
+			"up to 8GiB.  0 uses the etcd default")
+	autoCompactionRetention :=
+		flag.String("auto-compaction-retention", "", "etcd auto-compaction retention, in hours "+
+			"or as a duration such as 30m.  Empty disables auto-compaction")
+	defragInterval :=
+		flag.Duration("defrag-interval", 0, "Interval between rolling defragmentations "+
+			"of the etcd members, such as 24h.  0 disables periodic defragmentation")
+	compactInterval :=
+		flag.Duration("compact-interval", 0, "Interval between compactions of the etcd "+
+			"keyspace history, such as 1h.  0 disables periodic compaction")
+	compactRetention :=
+		flag.Int64("compact-retention", 10000, "Number of revisions of keyspace history "+
+			"kept by compaction")
+	leaderElection :=
+		flag.Bool("leader-election", false, "Elect a leader through zookeeper so that several "+
+			"schedulers may run, with standbys taking over when the leader is lost")
+	frameworkLock :=
+		flag.Bool("framework-lock", false, "Hold a zookeeper lock while driving the "+
+			"framework, exiting rather than registering when another scheduler holds it")
+	adminBindFailure :=
+		flag.String("admin-bind-failure", "exit", "What to do when the admin port cannot "+
+			"be bound: exit, retry with backoff, bind an ephemeral port, or continue "+
+			"without the admin interface")
+	reconcileAttempts :=
+		flag.Int("reconcile-attempts", 5, "Number of times task reconciliation with "+
+			"the master is attempted after registering before the scheduler exits")
+	reconcileBackoffCap :=
+		flag.Duration("reconcile-backoff-cap", 8*time.Second, "Longest wait, doubling "+
+			"from a second, between reconcile attempts")
+	syncWaitAttempts :=
+		flag.Int("sync-wait-attempts", 5, "Number of times each reconcile attempt "+
+			"checks whether every previously known task has been heard from")
+	syncWaitBackoffCap :=
+		flag.Duration("sync-wait-backoff-cap", 8*time.Second, "Longest wait, doubling "+
+			"from a second, between sync checks")
+	reconcileInterval :=
+		flag.Duration("reconcile-interval", 5*time.Minute, "How often to reconcile every "+
+			"tracked task with the master, to correct for lost status updates")
+	etcdCertFile :=
+		flag.String("etcd-cert-file", "", "Client certificate presented to etcd, "+
+			"which is then reached over https")
+	etcdKeyFile :=
+		flag.String("etcd-key-file", "", "Key of -etcd-cert-file")
+	etcdCAFile :=
+		flag.String("etcd-ca-file", "", "CA bundle used to verify etcd's certificates, "+
+			"instead of the host's roots")
+	etcdMemberCertFile :=
+		flag.String("etcd-member-cert-file", "", "Client certificate presented instead "+
+			"of -etcd-cert-file when adding or removing members, so that they are "+
+			"authorized as the etcd user named by its common name")
+	etcdMemberKeyFile :=
+		flag.String("etcd-member-key-file", "", "Key of -etcd-member-cert-file")
+	etcdServerCertFile :=
+		flag.String("etcd-server-cert-file", "", "Certificate etcd serves clients "+
+			"with over https, as a path in the executor sandbox, for instance one "+
+			"fetched with -uris")
+	etcdServerKeyFile :=
+		flag.String("etcd-server-key-file", "", "Key of -etcd-server-cert-file")
+	etcdServerCAFile :=
+		flag.String("etcd-server-ca-file", "", "CA bundle in the executor sandbox "+
+			"that etcd requires client certificates to be signed by")
+	etcdExecutorCertFile :=
+		flag.String("etcd-executor-cert-file", "", "Client certificate, as a path in "+
+			"the executor sandbox, that executors present to members served over "+
+			"https when joining or reseeding them")
+	etcdExecutorKeyFile :=
+		flag.String("etcd-executor-key-file", "", "Key of -etcd-executor-cert-file")
+	totalLoss :=
+		flag.String("total-loss-policy", "lock", "What to do when every member has been "+
+			"lost: lock the scheduler for manual recovery, seed-new to start a new, "+
+			"empty cluster, or restore-backup to start one and run -restore-command")
+	restoreCommand :=
+		flag.String("restore-command", "", "Shell command that restores a backup into "+
+			"the cluster at $ETCD_ENDPOINTS, run by -total-loss-policy=restore-backup")
+	maxClusterSize :=
+		flag.Int("max-cluster-size", 0, "Hard cap on the number of members, "+
+			"which -cluster-size may not exceed.  0 leaves it uncapped")
+	taskCapMultiple :=
+		flag.Int("task-cap-multiple", 2, "Refuse to adopt, and kill, running tasks "+
+			"beyond this multiple of -cluster-size, such as those of another cluster "+
+			"sharing the framework ID.  0 disables the cap")
+	statsdAddress :=
+		flag.String("statsd-address", "", "host:port of a StatsD server to send the "+
+			"/stats values to as gauges.  Empty disables StatsD reporting")
+	statsdPrefix :=
+		flag.String("statsd-prefix", "etcd_mesos", "Prefix of the metric names sent to StatsD")
+	statsdInterval :=
+		flag.Duration("statsd-interval", 10*time.Second, "Interval between reports to StatsD")
+	cleanStaleZK :=
+		flag.Bool("clean-stale-zk", false, "On startup, remove znodes of this framework "+
+			"that can no longer be parsed or are orphaned, keeping the framework ID")
 
 	flag.Parse()
 
@@ -108,6 +377,93 @@ func main() {
 		log.Fatal("No value provided for -zk-framework-persist !")
 	}
 
+	if err := rpc.SetZKNamespace(*zkNamespace); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.ValidateClusterToken(*initialClusterToken); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.ValidateQuotaBackendBytes(*quotaBackendBytes); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.ValidateAutoCompactionRetention(*autoCompactionRetention); err != nil {
+		log.Fatal(err)
+	}
+	externalSeedNodes, err := config.ParseExternalSeed(*externalSeed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *etcdCertFile != "" {
+		clientTLS, err := rpc.LoadClientTLS(*etcdCertFile, *etcdKeyFile, *etcdCAFile)
+		if err != nil {
+			log.Fatalf("Could not load etcd client certificate: %s", err)
+		}
+		membershipTLS := clientTLS
+		if *etcdMemberCertFile != "" {
+			membershipTLS, err = rpc.LoadClientTLS(
+				*etcdMemberCertFile,
+				*etcdMemberKeyFile,
+				*etcdCAFile,
+			)
+			if err != nil {
+				log.Fatalf("Could not load etcd membership certificate: %s", err)
+			}
+		}
+		rpc.SetClientTLS(clientTLS, membershipTLS)
+		user, _ := rpc.CertUser(clientTLS)
+		memberUser, _ := rpc.CertUser(membershipTLS)
+		log.Infof("Requests to etcd are made over https as user %q, "+
+			"and member changes as user %q.", user, memberUser)
+	} else if *etcdMemberCertFile != "" {
+		log.Fatal("-etcd-member-cert-file requires -etcd-cert-file")
+	}
+	if (*etcdServerCertFile == "") != (*etcdServerKeyFile == "") {
+		log.Fatal("-etcd-server-cert-file and -etcd-server-key-file must be given together")
+	}
+	if (*etcdExecutorCertFile == "") != (*etcdExecutorKeyFile == "") {
+		log.Fatal("-etcd-executor-cert-file and -etcd-executor-key-file must be given together")
+	}
+	if *etcdServerCertFile != "" && (*etcdCertFile == "" || *etcdExecutorCertFile == "") {
+		// The scheduler and executors reach https members over https, and
+		// must present a certificate should etcd require one.
+		log.Fatal("-etcd-server-cert-file requires -etcd-cert-file and " +
+			"-etcd-executor-cert-file")
+	}
+	if *etcdServerCAFile != "" && *etcdServerCertFile == "" {
+		log.Fatal("-etcd-server-ca-file requires -etcd-server-cert-file")
+	}
+	adminBindPolicy, err := etcdscheduler.ParseAdminBindPolicy(*adminBindFailure)
+	if err != nil {
+		log.Fatal(err)
+	}
+	totalLossPolicy, err := etcdscheduler.ParseTotalLossPolicy(*totalLoss)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if totalLossPolicy == etcdscheduler.TotalLossRestoreBackup && *restoreCommand == "" {
+		log.Fatal("-total-loss-policy=restore-backup requires -restore-command")
+	}
+	if *removeRetries < 1 {
+		// No member would ever be asked to deconfigure a dead one, and
+		// launches would wait on it forever.
+		log.Fatal("-remove-retries must be at least 1")
+	}
+	if *reseedHealthBackoffCap < time.Second {
+		// The backoff starts at a second, and a cap below it would leave
+		// reseeds polling candidates without pause.
+		log.Fatal("-reseed-health-backoff-cap must be at least 1s")
+	}
+	offerDedupKey, err := offercache.ParseDedupKey(*dedupKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	healthProbe, err := rpc.ParseHealthProbe(*healthCheckFormat, *healthCheckPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rpc.SetHealthProbe(healthProbe)
+	rpc.SetRetryBudget(*rpcRetryBudget)
+
 	if !*singleInstancePerSlave {
 		log.Warning("-single-instance-per-slave=false is dangerous because it may lead to " +
 			"multiple etcd instances in the same cluster on a single node, amplifying " +
@@ -118,37 +474,27 @@ func main() {
 	*address, *advertiseAddress, explicitAdvertise = defaultAddresses(*address, *advertiseAddress)
 
 	if *weburi == "" {
-		*weburi = fmt.Sprintf("http://%s:%d/", *address, *adminPort)
+		*weburi = config.JoinURL("http", *address, uint64(*adminPort)) + "/"
 	}
 
 	executorUris := []*mesos.CommandInfo_URI{}
-	execUri, err := etcdscheduler.ServeExecutorArtifact(*executorPath, *advertiseAddress, *artifactPort)
-	if err != nil {
-		log.Errorf("Could not stat executor binary: %v", err)
-		return
-	}
-	executorUris = append(executorUris, &mesos.CommandInfo_URI{
-		Value:      execUri,
-		Executable: proto.Bool(true),
-	})
-	etcdUri, err := etcdscheduler.ServeExecutorArtifact(*etcdPath, *advertiseAddress, *artifactPort)
-	if err != nil {
-		log.Errorf("Could not stat etcd binary: %v", err)
-		return
-	}
-	executorUris = append(executorUris, &mesos.CommandInfo_URI{
-		Value:      etcdUri,
-		Executable: proto.Bool(true),
-	})
-	etcdctlUri, err := etcdscheduler.ServeExecutorArtifact(*etcdctlPath, *advertiseAddress, *artifactPort)
-	if err != nil {
-		log.Errorf("Could not stat etcd binary: %v", err)
-		return
+	for _, artifact := range []struct{ name, path string }{
+		{"executor", *executorPath},
+		{"etcd", *etcdPath},
+		{"etcdctl", *etcdctlPath},
+	} {
+		served, err := etcdscheduler.ServeExecutorArtifact(artifact.path, *advertiseAddress, *artifactPort)
+		if err != nil {
+			log.Errorf("Could not stat %s binary: %v", artifact.name, err)
+			return
+		}
+		uri, err := etcdscheduler.NewExecutorURI(*served, *fetcherCache)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		executorUris = append(executorUris, uri)
 	}
-	executorUris = append(executorUris, &mesos.CommandInfo_URI{
-		Value:      etcdctlUri,
-		Executable: proto.Bool(true),
-	})
 
 	go http.ListenAndServe(fmt.Sprintf("%s:%d", *address, *artifactPort), nil)
 	log.V(2).Info("Serving executor artifacts...")
@@ -172,15 +518,92 @@ func main() {
 	etcdScheduler.ExecutorPath = *executorPath
 	etcdScheduler.Master = *master
 	etcdScheduler.FrameworkName = *frameworkName
+	etcdScheduler.ClusterToken = *initialClusterToken
+	etcdScheduler.DiscoveryURL = *discoveryURL
+	etcdScheduler.User = *user
+	etcdScheduler.DataHostPath = *dataHostPath
+	etcdScheduler.EtcdServerCertFile = *etcdServerCertFile
+	etcdScheduler.EtcdServerKeyFile = *etcdServerKeyFile
+	etcdScheduler.EtcdServerCAFile = *etcdServerCAFile
+	etcdScheduler.EtcdExecutorCertFile = *etcdExecutorCertFile
+	etcdScheduler.EtcdExecutorKeyFile = *etcdExecutorKeyFile
+	etcdScheduler.AdminBindFailure = adminBindPolicy
+	etcdScheduler.TotalLoss = totalLossPolicy
+	etcdScheduler.RestoreCommand = *restoreCommand
+	etcdScheduler.TaskCapMultiple = *taskCapMultiple
+	etcdScheduler.MaxInstanceCount = *maxClusterSize
+	if err := etcdScheduler.CheckInstanceCount(*taskCount); err != nil {
+		log.Fatalf("Invalid -cluster-size: %s", err)
+	}
+	etcdScheduler.StatsdAddress = *statsdAddress
+	etcdScheduler.StatsdPrefix = *statsdPrefix
+	etcdScheduler.StatsdInterval = *statsdInterval
+	etcdScheduler.ReconcileAttempts = *reconcileAttempts
+	etcdScheduler.ReconcileBackoffCap = *reconcileBackoffCap
+	etcdScheduler.SyncWaitAttempts = *syncWaitAttempts
+	etcdScheduler.SyncWaitBackoffCap = *syncWaitBackoffCap
+	etcdScheduler.ReconcileInterval = *reconcileInterval
+	etcdScheduler.ExecutorSource = *executorSource
 	etcdScheduler.ZkConnect = *zkFrameworkPersist
-
-	fwinfo := &mesos.FrameworkInfo{
-		User:            proto.String(""), // Mesos-go will fill in user.
-		Name:            proto.String(*frameworkName),
-		Checkpoint:      proto.Bool(true),
-		FailoverTimeout: proto.Float64(*failoverTimeoutSeconds),
-		WebuiUrl:        proto.String(*weburi),
+	etcdScheduler.ReregisterOnCompleted = *reregisterOnCompleted
+	etcdScheduler.RemoveRetries = *removeRetries
+	etcdScheduler.PruneMinHealthy = *pruneMinHealthy
+	etcdScheduler.HealthCheckConcurrency = *healthCheckConcurrency
+	etcdScheduler.PersistRetries = *persistRetries
+	etcdScheduler.ExternalSeed = externalSeedNodes
+	etcdScheduler.RemoveQuorumGuard = *removeQuorumGuard
+	etcdScheduler.ForceRemoveToken = *forceRemoveToken
+	if *safeMode {
+		etcdScheduler.EnterSafeMode()
 	}
+	etcdScheduler.LaunchTimeout = time.Duration(*launchTimeout) * time.Second
+	etcdScheduler.HeartbeatTimeout = *heartbeatTimeout
+	etcdScheduler.PendingAgeWarning = *pendingAgeWarning
+	etcdScheduler.ExecutorLogDir = *executorLogDir
+	etcdScheduler.ExecutorLogLevel = *executorLogLevel
+	etcdScheduler.QuotaBackendBytes = *quotaBackendBytes
+	etcdScheduler.AutoCompactionRetention = *autoCompactionRetention
+	etcdScheduler.DefragInterval = *defragInterval
+	etcdScheduler.CompactInterval = *compactInterval
+	etcdScheduler.CompactRetention = *compactRetention
+	etcdScheduler.FullRefuseSeconds = *fullRefuseSeconds
+	etcdScheduler.TransientRefuseSeconds = *transientRefuseSeconds
+	etcdScheduler.UndersizedRefuseSeconds = *undersizedRefuseSeconds
+	etcdScheduler.ReseedCooldown = *reseedCooldown
+	etcdScheduler.ReseedKillTimeout = *reseedKillTimeout
+	etcdScheduler.ReseedHealthTimeout = *reseedHealthTimeout
+	etcdScheduler.ReseedHealthBackoffCap = *reseedHealthBackoffCap
+	etcdScheduler.MaxReseeds = *maxReseeds
+	etcdScheduler.ReseedWindow = *reseedWindow
+	etcdScheduler.ClusterAttribute = *clusterAttribute
+	etcdScheduler.AvoidOtherClusters = *avoidOtherClusters
+	etcdScheduler.LeaderStableWindow = *leaderStableWindow
+	etcdScheduler.KillGracePeriod = *killGracePeriod
+	etcdScheduler.ChillPerMember = *chillPerMember
+	etcdScheduler.LostGracePeriod = *lostGracePeriod
+	etcdScheduler.FailureThreshold = *failureThreshold
+	etcdScheduler.FailureWindow = *failureWindow
+	etcdScheduler.SnapshotInterval = *snapshotInterval
+	etcdScheduler.FetcherCache = *fetcherCache
+	etcdScheduler.RebalanceInterval = *rebalanceInterval
+	etcdScheduler.ZoneAttribute = *zoneAttribute
+	etcdScheduler.PreferredZone = *preferredZone
+	etcdScheduler.LeaderPlacementInterval = *leaderPlacementInterval
+	etcdScheduler.NoMatchTimeout = *noMatchTimeout
+	etcdScheduler.NoMatchShutdown = *noMatchShutdown
+	etcdScheduler.BootstrapDeadline = *bootstrapDeadline
+	etcdScheduler.BootstrapShutdown = *bootstrapShutdown
+	etcdScheduler.SetDedupKey(offerDedupKey)
+	etcdScheduler.MinAgentCpus = *minAgentCpus
+	etcdScheduler.MinAgentMem = *minAgentMem
+	etcdScheduler.MinAgentDisk = *minAgentDisk
+
+	fwinfo := etcdscheduler.NewFrameworkInfo(
+		*user,
+		*frameworkName,
+		*failoverTimeoutSeconds,
+		*weburi,
+	)
 
 	cred := (*mesos.Credential)(nil)
 	if *mesosAuthPrincipal != "" {
@@ -207,6 +630,53 @@ func main() {
 	if err != nil && *zkFrameworkPersist != "" {
 		log.Fatalf("Error parsing zookeeper URI of %s: %s", *zkFrameworkPersist, err)
 	} else if *zkFrameworkPersist != "" {
+		if *leaderElection {
+			// Standbys block here, before reading the framework ID or
+			// registering a driver, until they win the election.
+			election, err := rpc.ElectLeader(
+				zkServers,
+				zkChroot,
+				etcdScheduler.FrameworkName,
+				config.JoinURL("http", *address, uint64(*adminPort)),
+			)
+			if err != nil {
+				log.Fatalf("Could not take part in leader election: %s", err)
+			}
+			go func() {
+				log.Fatalf("Exiting: %s", election.Wait())
+			}()
+		}
+		if *frameworkLock {
+			lock, err := rpc.LockFramework(
+				zkServers,
+				zkChroot,
+				etcdScheduler.FrameworkName,
+				config.JoinURL("http", *address, uint64(*adminPort)),
+			)
+			if errors.Is(err, rpc.ErrFrameworkLocked) {
+				log.Fatalf("Refusing to register, as another scheduler appears to "+
+					"be driving framework %s (%s).  If it has died, its lock "+
+					"expires with its zookeeper session.", etcdScheduler.FrameworkName, err)
+			} else if err != nil {
+				log.Fatalf("Could not take the framework lock: %s", err)
+			}
+			go func() {
+				log.Fatalf("Exiting: %s", lock.Wait())
+			}()
+		}
+		if *cleanStaleZK {
+			removed, err := rpc.CleanStaleZKState(
+				zkServers,
+				zkChroot,
+				etcdScheduler.FrameworkName,
+			)
+			if err != nil {
+				log.Fatalf("Could not clean stale zookeeper state: %s", err)
+			}
+			for _, path := range removed {
+				log.Infof("Removed stale znode %s", path)
+			}
+		}
 		previous, err := rpc.GetPreviousFrameworkID(
 			zkServers,
 			zkChroot,
@@ -225,7 +695,7 @@ func main() {
 		}
 	}
 
-	config := scheduler.DriverConfig{
+	driverConfig := scheduler.DriverConfig{
 		Scheduler:        etcdScheduler,
 		Framework:        fwinfo,
 		Master:           etcdScheduler.Master,
@@ -239,19 +709,39 @@ func main() {
 		},
 	}
 	if explicitAdvertise {
-		config.PublishedAddress = parseIP(*advertiseAddress)
+		driverConfig.PublishedAddress = parseIP(*advertiseAddress)
 	}
 
-	driver, err := scheduler.NewMesosSchedulerDriver(config)
-
+	driver, err := etcdscheduler.NewReplaceableDriver(
+		func() (scheduler.SchedulerDriver, error) {
+			return scheduler.NewMesosSchedulerDriver(driverConfig)
+		},
+	)
 	if err != nil {
-		log.Errorln("Unable to create a SchedulerDriver ", err.Error())
+		log.Fatalf("Unable to create a SchedulerDriver: %s", err)
 	}
+	etcdScheduler.SetReregister(func() error {
+		// The completed framework's ID has been cleared from ZK, so
+		// register without it.
+		framework := *fwinfo
+		framework.Id = nil
+		driverConfig.Framework = &framework
+		return driver.Replace()
+	})
 
 	go etcdScheduler.SerialLauncher(driver)
 	go etcdScheduler.PeriodicReconciler(driver)
 	go etcdScheduler.PeriodicHealthChecker()
 	go etcdScheduler.PeriodicLaunchRequestor()
+	if *statsdAddress != "" {
+		go etcdScheduler.PeriodicStatsdReporter()
+	}
+	go etcdScheduler.PeriodicDefragmenter()
+	go etcdScheduler.PeriodicCompactor()
+	go etcdScheduler.PeriodicSnapshotter()
+	go etcdScheduler.PeriodicRebalancer(driver)
+	go etcdScheduler.PeriodicLeaderPlacer()
+	go etcdScheduler.PeriodicOfferReaper(driver)
 	go etcdScheduler.AdminHTTP(*adminPort, driver)
 
 	if stat, err := driver.Run(); err != nil {
@@ -23,6 +23,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 // Node represents an etcd node's configuration.
@@ -34,6 +36,71 @@ type Node struct {
 	ReseedPort uint64 `json:"httpPort"`
 	Type       string `json:"type"`
 	SlaveID    string `json:"slaveID"`
+
+	QuotaBackendBytes       int64  `json:"quotaBackendBytes,omitempty"`
+	AutoCompactionRetention string `json:"autoCompactionRetention,omitempty"`
+	Version                 string `json:"version,omitempty"`
+	EtcdVersion             string `json:"etcdVersion,omitempty"`
+
+	// LeaderStableSeconds is how long the cluster leader must remain
+	// unchanged before this node is added to the cluster.
+	LeaderStableSeconds int `json:"leaderStableSeconds,omitempty"`
+
+	// KillGracePeriodSeconds is how long etcd is given to shut down
+	// cleanly after SIGTERM when the task is killed, before it is sent
+	// SIGKILL.  Zero kills it at once.
+	KillGracePeriodSeconds int `json:"killGracePeriodSeconds,omitempty"`
+
+	// ClusterToken is passed to etcd as --initial-cluster-token, so that
+	// members of different clusters can never join each other.
+	ClusterToken string `json:"clusterToken,omitempty"`
+
+	// DataDir is etcd's data directory, relative to the executor sandbox.
+	// Empty selects DefaultDataDir.
+	DataDir string `json:"dataDir,omitempty"`
+
+	// Scheme is the scheme of the Node's client and peer URLs, http or
+	// https, so that a cluster may be managed while TLS is rolled out to
+	// its members one at a time.  Empty leaves the choice to whoever
+	// builds the URL.
+	Scheme string `json:"scheme,omitempty"`
+
+	// ClientScheme, if set, overrides Scheme for the Node's client URL,
+	// for members serving clients over https but peers over http.
+	ClientScheme string `json:"clientScheme,omitempty"`
+
+	// CertFile and KeyFile are the certificate and key etcd serves its
+	// client URL with, relative to the executor sandbox.  TrustedCAFile,
+	// if also set, makes etcd require client certificates signed by it.
+	CertFile      string `json:"certFile,omitempty"`
+	KeyFile       string `json:"keyFile,omitempty"`
+	TrustedCAFile string `json:"trustedCAFile,omitempty"`
+
+	// ClientCertFile and ClientKeyFile, relative to the executor sandbox,
+	// are the client certificate the executor presents to members served
+	// over https, trusting TrustedCAFile or the host's roots.
+	ClientCertFile string `json:"clientCertFile,omitempty"`
+	ClientKeyFile  string `json:"clientKeyFile,omitempty"`
+
+	// Discovery is the etcd discovery URL a new Node bootstraps against,
+	// in place of an initial cluster listing its peers.
+	Discovery string `json:"discovery,omitempty"`
+
+	// Zone is the value of the slave attribute naming the zone the Node
+	// was launched in, if the scheduler was told which attribute that is.
+	Zone string `json:"zone,omitempty"`
+}
+
+// DefaultDataDir is the data directory etcd uses within the sandbox unless
+// a Node names another.
+const DefaultDataDir = "etcd_data"
+
+// DataDirectory returns the data directory the Node's etcd uses.
+func (n Node) DataDirectory() string {
+	if n.DataDir == "" {
+		return DefaultDataDir
+	}
+	return n.DataDir
 }
 
 // ErrUnmarshal is returned whenever config unmarshalling
@@ -56,9 +123,176 @@ func Parse(text string) (*Node, error) {
 		return nil, ErrUnmarshal
 	}
 
+	if err = n.Validate(); err != nil {
+		return nil, err
+	}
 	return n, nil
 }
 
+// ParseExternalSeed parses a comma-separated list of existing etcd members
+// outside of Mesos, each given as name=host:peerPort:clientPort, optionally
+// with the host prefixed by http:// or https://.
+func ParseExternalSeed(spec string) ([]*Node, error) {
+	nodes := []*Node{}
+	for _, member := range strings.Split(spec, ",") {
+		if member = strings.TrimSpace(member); member == "" {
+			continue
+		}
+		eq := strings.Index(member, "=")
+		if eq == -1 {
+			return nil, fmt.Errorf("config: external seed member %q is not "+
+				"of the form name=host:peerPort:clientPort", member)
+		}
+		addr, scheme := member[eq+1:], ""
+		if sep := strings.Index(addr, "://"); sep != -1 {
+			addr, scheme = addr[sep+3:], addr[:sep]
+		}
+		peerColon, clientColon := -1, strings.LastIndex(addr, ":")
+		if clientColon != -1 {
+			peerColon = strings.LastIndex(addr[:clientColon], ":")
+		}
+		if peerColon == -1 {
+			return nil, fmt.Errorf("config: external seed member %q is not "+
+				"of the form name=host:peerPort:clientPort", member)
+		}
+		n := &Node{
+			Name: member[:eq],
+			Host: addr[:peerColon],
+			Type: "existing",
+
+			Scheme: scheme,
+		}
+		var err error
+		if n.RPCPort, err = strconv.ParseUint(addr[peerColon+1:clientColon], 10, 64); err != nil {
+			return nil, fmt.Errorf("config: external seed member %q has an "+
+				"invalid peer port", member)
+		}
+		if n.ClientPort, err = strconv.ParseUint(addr[clientColon+1:], 10, 64); err != nil {
+			return nil, fmt.Errorf("config: external seed member %q has an "+
+				"invalid client port", member)
+		}
+		if err = n.Validate(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// maxPort is the highest valid TCP port.
+const maxPort = 65535
+
+// Validate checks that a Node's fields are usable, returning a descriptive
+// error for the first problem found.
+func (n Node) Validate() error {
+	if n.Name == "" {
+		return errors.New("config: node has an empty name")
+	}
+	if n.Host == "" {
+		return fmt.Errorf("config: node %s has an empty host", n.Name)
+	}
+	if strings.IndexFunc(n.Host, invalidHostRune) != -1 {
+		return fmt.Errorf("config: node %s has an invalid host %q", n.Name, n.Host)
+	}
+	if n.Scheme != "" && n.Scheme != "http" && n.Scheme != "https" {
+		return fmt.Errorf("config: node %s has an invalid scheme %q", n.Name, n.Scheme)
+	}
+	if n.ClientScheme != "" && n.ClientScheme != "http" && n.ClientScheme != "https" {
+		return fmt.Errorf("config: node %s has an invalid client scheme %q",
+			n.Name, n.ClientScheme)
+	}
+	if (n.CertFile == "") != (n.KeyFile == "") {
+		return fmt.Errorf("config: node %s must name both a cert and a key file", n.Name)
+	}
+	if (n.ClientCertFile == "") != (n.ClientKeyFile == "") {
+		return fmt.Errorf("config: node %s must name both a client cert and a "+
+			"client key file", n.Name)
+	}
+	if err := ValidateQuotaBackendBytes(n.QuotaBackendBytes); err != nil {
+		return err
+	}
+	if err := ValidateAutoCompactionRetention(n.AutoCompactionRetention); err != nil {
+		return err
+	}
+	for _, p := range []struct {
+		name string
+		port uint64
+	}{
+		{"rpc", n.RPCPort},
+		{"client", n.ClientPort},
+		{"reseed", n.ReseedPort},
+	} {
+		if p.port > maxPort {
+			return fmt.Errorf("config: node %s has out of range %s port %d",
+				n.Name, p.name, p.port)
+		}
+	}
+	return nil
+}
+
+// ClusterToken derives an etcd --initial-cluster-token from the name of an
+// etcd-mesos framework.  Whitespace, which etcd flags can't carry, becomes
+// dashes.
+func ClusterToken(frameworkName string) string {
+	return "etcd-mesos-" + strings.Join(strings.Fields(frameworkName), "-")
+}
+
+// ValidateClusterToken checks an explicitly configured etcd
+// --initial-cluster-token.  The empty string selects the token derived from
+// the framework name.
+func ValidateClusterToken(token string) error {
+	if strings.IndexFunc(token, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("config: initial-cluster-token %q may not contain "+
+			"whitespace", token)
+	}
+	return nil
+}
+
+const (
+	minQuotaBackendBytes = 1 << 20
+	maxQuotaBackendBytes = 8 << 30
+)
+
+// ValidateQuotaBackendBytes checks an etcd --quota-backend-bytes value.
+// Zero leaves etcd's default in place; otherwise the quota must lie
+// between 1MiB and etcd's recommended maximum of 8GiB.
+func ValidateQuotaBackendBytes(quota int64) error {
+	if quota != 0 && (quota < minQuotaBackendBytes || quota > maxQuotaBackendBytes) {
+		return fmt.Errorf("config: quota-backend-bytes %d must be 0 or between "+
+			"%d and %d", quota, int64(minQuotaBackendBytes), int64(maxQuotaBackendBytes))
+	}
+	return nil
+}
+
+// ValidateAutoCompactionRetention checks an etcd --auto-compaction-retention
+// value, which is either a whole number of hours or a positive duration
+// such as "30m".  The empty string disables auto-compaction.
+func ValidateAutoCompactionRetention(retention string) error {
+	if retention == "" {
+		return nil
+	}
+	if hours, err := strconv.ParseUint(retention, 10, 64); err == nil && hours > 0 {
+		return nil
+	}
+	if d, err := time.ParseDuration(retention); err == nil && d > 0 {
+		return nil
+	}
+	return fmt.Errorf("config: auto-compaction-retention %q must be a positive "+
+		"number of hours or a duration", retention)
+}
+
+// invalidHostRune reports whether r may not appear in a hostname or
+// IP address literal.
+func invalidHostRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	case strings.ContainsRune(".-_:[]%", r):
+		return false
+	}
+	return true
+}
+
 // String implements the fmt.Stringer interface, returning a space separated
 // string representation of a Node.
 func (n Node) String() string {
@@ -49,6 +49,47 @@ func TestNode_Parse(t *testing.T) {
 	}
 }
 
+func TestNode_ParseValidation(t *testing.T) {
+	for i, tt := range []struct {
+		text string
+		err  string
+	}{
+		{"a b 65536 2 3", "config: node a has out of range rpc port 65536"},
+		{"a b 1 99999 3", "config: node a has out of range client port 99999"},
+		{"a b 1 2 70000", "config: node a has out of range reseed port 70000"},
+		{"a b/c 1 2 3", `config: node a has an invalid host "b/c"`},
+		{"a b,c 1 2 3", `config: node a has an invalid host "b,c"`},
+	} {
+		n, err := Parse(tt.text)
+		if n != nil {
+			t.Errorf("test #%d: got node %v, want nil", i, n)
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("test #%d: got err: %v, want: %s", i, err, tt.err)
+		}
+	}
+
+	for i, text := range []string{
+		"etcd-1 localhost 0 0 0",
+		"etcd-1 10.0.0.1 31000 31001 65535",
+		"etcd-1 ::1 31000 31001 31002",
+		"etcd-1 host-a.example.com 31000 31001 31002",
+	} {
+		if _, err := Parse(text); err != nil {
+			t.Errorf("test #%d: got err: %v, want nil", i, err)
+		}
+	}
+}
+
+func TestNode_Validate(t *testing.T) {
+	if err := (Node{Host: "b"}).Validate(); err == nil {
+		t.Error("expected an error for an empty name")
+	}
+	if err := (Node{Name: "a"}).Validate(); err == nil {
+		t.Error("expected an error for an empty host")
+	}
+}
+
 func TestNode_String(t *testing.T) {
 	for i, tt := range []struct {
 		Node
@@ -67,3 +108,91 @@ func TestNode_String(t *testing.T) {
 		}
 	}
 }
+
+func TestJoinURL(t *testing.T) {
+	for i, tt := range []struct {
+		host string
+		port uint64
+		want string
+	}{
+		{"localhost", 1, "http://localhost:1"},
+		{"10.0.0.1", 2379, "http://10.0.0.1:2379"},
+		{"::1", 2379, "http://[::1]:2379"},
+		{"[::1]", 2379, "http://[::1]:2379"},
+		{"fe80::1:2", 80, "http://[fe80::1:2]:80"},
+	} {
+		if got := JoinURL("http", tt.host, tt.port); got != tt.want {
+			t.Errorf("test #%d: got : %s, want: %s", i, got, tt.want)
+		}
+	}
+}
+
+func TestNode_URLs(t *testing.T) {
+	n := Node{Host: "2001:db8::1", RPCPort: 1, ClientPort: 2, ReseedPort: 3}
+	if got, want := n.PeerURL(), "http://[2001:db8::1]:1"; got != want {
+		t.Errorf("PeerURL: got : %s, want: %s", got, want)
+	}
+	if got, want := n.ClientURL(), "http://[2001:db8::1]:2"; got != want {
+		t.Errorf("ClientURL: got : %s, want: %s", got, want)
+	}
+	if got, want := n.ReseedURL(), "http://[2001:db8::1]:3"; got != want {
+		t.Errorf("ReseedURL: got : %s, want: %s", got, want)
+	}
+}
+
+func TestValidateEtcdTuning(t *testing.T) {
+	for i, tt := range []struct {
+		quota int64
+		valid bool
+	}{
+		{0, true},
+		{1 << 20, true},
+		{4 << 30, true},
+		{8 << 30, true},
+		{-1, false},
+		{1024, false},
+		{9 << 30, false},
+	} {
+		if err := ValidateQuotaBackendBytes(tt.quota); (err == nil) != tt.valid {
+			t.Errorf("test #%d: quota %d got err: %v, want valid: %t", i, tt.quota, err, tt.valid)
+		}
+	}
+
+	for i, tt := range []struct {
+		retention string
+		valid     bool
+	}{
+		{"", true},
+		{"1", true},
+		{"30m", true},
+		{"0", false},
+		{"-1h", false},
+		{"1 h", false},
+		{"soon", false},
+	} {
+		if err := ValidateAutoCompactionRetention(tt.retention); (err == nil) != tt.valid {
+			t.Errorf("test #%d: retention %q got err: %v, want valid: %t", i, tt.retention, err, tt.valid)
+		}
+	}
+}
+
+func TestParseExternalSeed(t *testing.T) {
+	nodes, err := ParseExternalSeed("a=10.0.0.1:2380:2379, b=[::1]:2480:2479, c=https://c:2580:2579")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Node{
+		{Name: "a", Host: "10.0.0.1", RPCPort: 2380, ClientPort: 2379, Type: "existing"},
+		{Name: "b", Host: "[::1]", RPCPort: 2480, ClientPort: 2479, Type: "existing"},
+		{Name: "c", Host: "c", RPCPort: 2580, ClientPort: 2579, Type: "existing", Scheme: "https"},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("got: %+v, want: %+v", nodes, want)
+	}
+
+	for i, spec := range []string{"a", "a=host", "a=host:1", "a=host:x:2", "=host:1:2", "a=ftp://host:1:2"} {
+		if _, err := ParseExternalSeed(spec); err == nil {
+			t.Errorf("test #%d: expected an error parsing %q", i, spec)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// JoinURL returns a scheme://host:port URL, bracketing the host if it is an
+// IPv6 literal.
+func JoinURL(scheme, host string, port uint64) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return scheme + "://" + net.JoinHostPort(host, strconv.FormatUint(port, 10))
+}
+
+// ClientURL returns the URL that clients use to reach this Node.
+func (n Node) ClientURL() string {
+	scheme := n.scheme()
+	if n.ClientScheme != "" {
+		scheme = n.ClientScheme
+	}
+	return JoinURL(scheme, n.Host, n.ClientPort)
+}
+
+// PeerURL returns the URL that other etcd members use to reach this Node.
+func (n Node) PeerURL() string {
+	return JoinURL(n.scheme(), n.Host, n.RPCPort)
+}
+
+// scheme returns the scheme of the Node's client and peer URLs, which is
+// http unless the Node names another.
+func (n Node) scheme() string {
+	if n.Scheme == "" {
+		return "http"
+	}
+	return n.Scheme
+}
+
+// ReseedURL returns the URL of this Node's executor reseed listener.
+func (n Node) ReseedURL() string {
+	return JoinURL("http", n.Host, n.ReseedPort)
+}
@@ -26,4 +26,32 @@ var (
 	ErrEtcdEndpoint            = goerrors.New("Could not query cluster")
 	ErrEtcdRaftTermInstability = goerrors.New("Raft term (and leader) is unstable.")
 	ErrEtcdRaftStall           = goerrors.New("non-increasing raft commit index")
+
+	// ErrUnreachable classifies health check failures in which no member
+	// could be reached, as when every member is down.
+	ErrUnreachable = goerrors.New("etcd cluster unreachable")
+	// ErrUnhealthy classifies health check failures in which members were
+	// reached but the cluster is not working, as during a livelock.
+	ErrUnhealthy = goerrors.New("etcd cluster unhealthy")
 )
+
+// kindError is an error classified by kind, such that errors.Is matches
+// both kind and the underlying error.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string        { return e.kind.Error() + ": " + e.err.Error() }
+func (e *kindError) Unwrap() error        { return e.err }
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+// Unreachable classifies err as ErrUnreachable.
+func Unreachable(err error) error {
+	return &kindError{kind: ErrUnreachable, err: err}
+}
+
+// Unhealthy classifies err as ErrUnhealthy.
+func Unhealthy(err error) error {
+	return &kindError{kind: ErrUnhealthy, err: err}
+}
@@ -20,6 +20,7 @@ package executor
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +29,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -45,12 +47,17 @@ const (
 )
 
 var cmdTemplate = template.Must(template.New("etcd-cmd").Parse(
-	`./etcd --data-dir=etcd_data --name={{.Name}} ` +
-		`--listen-peer-urls=http://{{.Host}}:{{.RPCPort}} ` +
-		`--initial-advertise-peer-urls=http://{{.Host}}:{{.RPCPort}} ` +
-		`--listen-client-urls=http://{{.Host}}:{{.ClientPort}} ` +
-		`--advertise-client-urls=http://{{.Host}}:{{.ClientPort}} ` +
-		`--initial-cluster={{.Cluster}}`,
+	`./etcd --data-dir={{.DataDirectory}} --name={{.Name}} ` +
+		`--listen-peer-urls={{.PeerURL}} ` +
+		`--initial-advertise-peer-urls={{.PeerURL}} ` +
+		`--listen-client-urls={{.ClientURL}} ` +
+		`--advertise-client-urls={{.ClientURL}} ` +
+		`{{if .Discovery}}--discovery={{.Discovery}}{{else}}--initial-cluster={{.Cluster}}{{end}}` +
+		`{{if .QuotaBackendBytes}} --quota-backend-bytes={{.QuotaBackendBytes}}{{end}}` +
+		`{{if .AutoCompactionRetention}} --auto-compaction-retention={{.AutoCompactionRetention}}{{end}}` +
+		`{{if .ClusterToken}} --initial-cluster-token={{.ClusterToken}}{{end}}` +
+		`{{if .CertFile}} --cert-file={{.CertFile}} --key-file={{.KeyFile}}{{end}}` +
+		`{{if .TrustedCAFile}} --trusted-ca-file={{.TrustedCAFile}} --client-cert-auth{{end}}`,
 ))
 
 type Executor struct {
@@ -151,6 +158,15 @@ func (e *Executor) LaunchTask(
 
 }
 
+// clientTLS returns the TLS configuration presenting node's client
+// certificate, or nil if it has none.
+func clientTLS(node *config.Node) (*tls.Config, error) {
+	if node.ClientCertFile == "" {
+		return nil, nil
+	}
+	return rpc.LoadClientTLS(node.ClientCertFile, node.ClientKeyFile, node.TrustedCAFile)
+}
+
 func dumbExec(args string) error {
 	log.Infof("running command %s", args)
 	argv := strings.Fields(args)
@@ -176,6 +192,18 @@ func (e *Executor) etcdHarness(
 	}
 	cmd += " --initial-cluster-state=" + node.Type
 
+	tlsConfig, err := clientTLS(node)
+	if err != nil {
+		log.Errorf("Could not load etcd client certificate: %v", err)
+		handleFailure(driver, taskInfo)
+		return
+	}
+	if tlsConfig != nil {
+		// Members serving clients over https are reached over https, both
+		// to join the cluster and to fix the peers of a reseeded member.
+		rpc.SetClientTLS(tlsConfig, nil)
+	}
+
 	runningMap := map[string]*config.Node{}
 	for i, r := range running {
 		// Skip first element because we haven't started it yet.
@@ -183,12 +211,27 @@ func (e *Executor) etcdHarness(
 			runningMap[string(i)] = r
 		}
 	}
-	err = rpc.ConfigureInstance(runningMap, running[0])
+	// Members bootstrapping through discovery register themselves, and
+	// the other members are not serving yet.
+	if node.Discovery != "" {
+		runningMap = map[string]*config.Node{}
+	}
+	learnerID, err := rpc.ConfigureInstance(runningMap, running[0])
 	if err != nil {
 		log.Errorf("Could not configure etcd instance, cannot continue: %v", err)
 		handleFailure(driver, taskInfo)
 		return
 	}
+	if learnerID != "" {
+		// We joined as a learner, so once etcd is up and has caught up
+		// with the leader it needs to be promoted to a voting member.
+		go func() {
+			err := rpc.PromoteLearner(runningMap, node, learnerID)
+			if err != nil {
+				log.Errorf("Failed to promote learner, it will not vote: %v", err)
+			}
+		}()
+	}
 
 	reseedChan := make(chan struct{}, 1)
 	go e.reseedListener(node, reseedChan)
@@ -215,7 +258,7 @@ func (e *Executor) etcdHarness(
 		killChan := make(chan struct{})
 		exitChan := make(chan struct{})
 
-		go e.runUntilClosed(cmd, killChan, exitChan)
+		go e.runUntilClosed(cmd, node.KillGracePeriodSeconds, killChan, exitChan)
 
 		if reseeding {
 			// properly set advertised peer URL's
@@ -230,12 +273,14 @@ func (e *Executor) etcdHarness(
 			// We've received an http request to reseed
 			close(killChan)
 
-			err := stripPersistedMetadata(taskInfo, driver)
+			err := stripPersistedMetadata(node.DataDirectory())
 			if err != nil {
 				log.Errorf("Failed to reseed! %v", err)
 				handleFailure(driver, taskInfo)
 			}
 
+			// The member now seeds a cluster of its own.
+			node.Discovery = ""
 			cmd, err = command(node)
 			if err != nil {
 				log.Errorf("Failed to create configuration for etcd: %v", err)
@@ -269,11 +314,13 @@ func (e *Executor) etcdHarness(
 	}
 }
 
-func stripPersistedMetadata(taskInfo *mesos.TaskInfo, driver executor.ExecutorDriver) error {
-	// Strip out existing membership info
+func stripPersistedMetadata(dataDir string) error {
+	// Strip out existing membership info.  The backup is made next to the
+	// data directory, so that it may be renamed over it.
+	backupDir := dataDir + "_backup"
 	err := dumbExec("./etcdctl backup " +
-		"--data-dir=./etcd_data " +
-		"--backup-dir=./etcd_backup")
+		"--data-dir=./" + dataDir + " " +
+		"--backup-dir=./" + backupDir)
 	if err != nil {
 		log.Errorf("Failed to run etcdctl backup!  No recovery possible. "+
 			"etcdctl exit code: %v", err)
@@ -281,13 +328,13 @@ func stripPersistedMetadata(taskInfo *mesos.TaskInfo, driver executor.ExecutorDr
 	}
 
 	// Move backup dir over old data dir
-	err = os.RemoveAll("./etcd_data")
+	err = os.RemoveAll("./" + dataDir)
 	if err != nil {
 		log.Errorf("Failed to remove old data dir: %v", err)
 		return err
 	}
 
-	err = os.Rename("./etcd_backup", "./etcd_data")
+	err = os.Rename("./"+backupDir, "./"+dataDir)
 	if err != nil {
 		log.Errorf("Failed to mv restored data directory: %v", err)
 		return err
@@ -298,6 +345,7 @@ func stripPersistedMetadata(taskInfo *mesos.TaskInfo, driver executor.ExecutorDr
 
 func (e *Executor) runUntilClosed(
 	cmd string,
+	gracePeriodSeconds int,
 	killChan chan struct{},
 	exitChan chan struct{},
 ) {
@@ -309,9 +357,11 @@ func (e *Executor) runUntilClosed(
 	command.Stderr = os.Stdout
 	command.Start()
 
+	exited := make(chan struct{})
 	go func() {
 		command.Wait()
 		log.Warning("etcd process exited")
+		close(exited)
 		select {
 		case <-killChan:
 		default:
@@ -320,7 +370,7 @@ func (e *Executor) runUntilClosed(
 	}()
 
 	<-killChan
-	command.Process.Kill()
+	stop(command.Process, exited, time.Duration(gracePeriodSeconds)*time.Second)
 
 	// If we're shutting down, here's the place to exit.
 	select {
@@ -332,6 +382,26 @@ func (e *Executor) runUntilClosed(
 	}
 }
 
+// stop sends process SIGTERM, and SIGKILL if it has not exited within
+// gracePeriod, so that etcd may shut down cleanly.
+func stop(process *os.Process, exited chan struct{}, gracePeriod time.Duration) {
+	if process == nil {
+		return
+	}
+	if gracePeriod > 0 {
+		if err := process.Signal(syscall.SIGTERM); err == nil {
+			select {
+			case <-exited:
+				return
+			case <-time.After(gracePeriod):
+				log.Warningf("etcd did not exit within %s of SIGTERM, killing it.",
+					gracePeriod)
+			}
+		}
+	}
+	process.Kill()
+}
+
 func handleFailure(
 	driver executor.ExecutorDriver,
 	taskInfo *mesos.TaskInfo,
@@ -396,8 +466,7 @@ func command(nodes ...*config.Node) (string, error) {
 	cluster := make([]string, 0, len(nodes))
 	for _, n := range nodes {
 		log.Infof("formatting node: %+v", n)
-		cluster = append(cluster,
-			fmt.Sprintf("%s=http://%s:%d", n.Name, n.Host, n.RPCPort))
+		cluster = append(cluster, n.Name+"="+n.PeerURL())
 	}
 
 	var out bytes.Buffer
@@ -0,0 +1,125 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+func TestCommandEtcdTuning(t *testing.T) {
+	node := &config.Node{
+		Name:       "etcd-1",
+		Host:       "localhost",
+		RPCPort:    1,
+		ClientPort: 2,
+	}
+	cmd, err := command(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(cmd, "--quota-backend-bytes") ||
+		strings.Contains(cmd, "--auto-compaction-retention") ||
+		strings.Contains(cmd, "--initial-cluster-token") ||
+		strings.Contains(cmd, "--cert-file") ||
+		strings.Contains(cmd, "--client-cert-auth") {
+		t.Errorf("unconfigured tuning flags should be omitted: %s", cmd)
+	}
+	if !strings.Contains(cmd, " --initial-cluster=etcd-1=") ||
+		strings.Contains(cmd, "--discovery") {
+		t.Errorf("command %q should list the initial cluster", cmd)
+	}
+	if !strings.Contains(cmd, " --data-dir=etcd_data ") {
+		t.Errorf("command %q should use the default data directory", cmd)
+	}
+
+	node.QuotaBackendBytes = 4 << 30
+	node.AutoCompactionRetention = "30m"
+	node.ClusterToken = "etcd-mesos-etcd"
+	node.DataDir = "etcd_host_data"
+	node.ClientScheme = "https"
+	node.CertFile = "server.crt"
+	node.KeyFile = "server.key"
+	node.TrustedCAFile = "ca.crt"
+	node.Discovery = "https://discovery.example.com/abc"
+	cmd, err = command(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, flag := range []string{
+		"--quota-backend-bytes=4294967296",
+		"--auto-compaction-retention=30m",
+		"--initial-cluster-token=etcd-mesos-etcd",
+		"--data-dir=etcd_host_data",
+		"--listen-client-urls=https://localhost:2",
+		"--cert-file=server.crt --key-file=server.key",
+		"--trusted-ca-file=ca.crt",
+		"--client-cert-auth",
+		"--discovery=https://discovery.example.com/abc",
+	} {
+		if !strings.Contains(cmd, " "+flag) {
+			t.Errorf("command %q is missing %s", cmd, flag)
+		}
+	}
+	if strings.Contains(cmd, "--initial-cluster=") {
+		t.Errorf("command %q should bootstrap through discovery alone", cmd)
+	}
+}
+
+func TestClientTLSFromNode(t *testing.T) {
+	node := &config.Node{Name: "etcd-1", CertFile: "server.crt", KeyFile: "server.key"}
+	c, err := clientTLS(node)
+	if err != nil || c != nil {
+		t.Errorf("a node without a client certificate should use plain "+
+			"transport, got %v, %v", c, err)
+	}
+
+	node.ClientCertFile = "missing.crt"
+	node.ClientKeyFile = "missing.key"
+	if _, err := clientTLS(node); err == nil {
+		t.Error("a missing client certificate should fail the launch")
+	}
+}
+
+func TestStopAllowsGracePeriod(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "trap 'exit 0' TERM; while true; do sleep 0.1; done")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	var state *os.ProcessState
+	go func() {
+		cmd.Wait()
+		state = cmd.ProcessState
+		close(exited)
+	}()
+	// Give the shell time to install its trap.
+	time.Sleep(200 * time.Millisecond)
+
+	stop(cmd.Process, exited, 10*time.Second)
+	<-exited
+	if !state.Success() {
+		t.Errorf("etcd should exit cleanly on SIGTERM, got %v", state)
+	}
+}
@@ -19,26 +19,65 @@
 package offercache
 
 import (
+	"fmt"
 	"sync"
+	"time"
 
 	log "github.com/golang/glog"
 	mesos "github.com/mesos/mesos-go/mesosproto"
 )
 
+// DedupKey selects what identifies the machine an offer comes from, when
+// only a single instance may run per slave.
+type DedupKey int
+
+const (
+	// BySlaveID treats every slave as a separate machine.
+	BySlaveID DedupKey = iota
+	// ByHostname treats slaves sharing a hostname as one machine, for
+	// environments running several slaves per physical host.
+	ByHostname
+)
+
+// ParseDedupKey parses "slave-id" or "hostname".
+func ParseDedupKey(key string) (DedupKey, error) {
+	switch key {
+	case "slave-id":
+		return BySlaveID, nil
+	case "hostname":
+		return ByHostname, nil
+	}
+	return BySlaveID, fmt.Errorf("unknown dedup key %q, expected slave-id or hostname", key)
+}
+
+// Of returns the key identifying the machine offer comes from.
+func (k DedupKey) Of(offer *mesos.Offer) string {
+	if k == ByHostname {
+		return offer.GetHostname()
+	}
+	return offer.GetSlaveId().GetValue()
+}
+
 type OfferCache struct {
 	mut                    sync.RWMutex
 	offerSet               map[string]*mesos.Offer
+	pushedAt               map[string]time.Time
+	now                    func() time.Time
 	offerQueue             chan *mesos.Offer
 	maxOffers              int
 	singleInstancePerSlave bool
+	dedupKey               DedupKey
 }
 
-func New(maxOffers int, singleInstancePerSlave bool) *OfferCache {
+func New(maxOffers int, singleInstancePerSlave bool, dedupKey DedupKey) *OfferCache {
 	return &OfferCache{
 		offerSet:               map[string]*mesos.Offer{},
+		pushedAt:               map[string]time.Time{},
+		now:                    time.Now,
 		offerQueue:             make(chan *mesos.Offer, maxOffers),
 		maxOffers:              maxOffers,
 		singleInstancePerSlave: singleInstancePerSlave,
+		dedupKey:               dedupKey,
 	}
 }
 
@@ -47,14 +86,15 @@ func (oc *OfferCache) Push(newOffer *mesos.Offer) bool {
 	defer oc.mut.Unlock()
 	if len(oc.offerSet) < oc.maxOffers {
 		// Reject offers from existing slaves.
+		key := oc.dedupKey.Of(newOffer)
 		for _, offer := range oc.offerSet {
-			if offer.SlaveId.GetValue() == newOffer.SlaveId.GetValue() &&
-				oc.singleInstancePerSlave {
-				log.Info("Offer already exists for slave ", newOffer.SlaveId.GetValue())
+			if oc.dedupKey.Of(offer) == key && oc.singleInstancePerSlave {
+				log.Info("Offer already exists for slave ", key)
 				return false
 			}
 		}
 		oc.offerSet[newOffer.GetId().GetValue()] = newOffer
+		oc.pushedAt[newOffer.GetId().GetValue()] = oc.now()
 
 		// Try to add offer to the queue, clearing out invalid
 		// offers in order to make room if necessary.
@@ -75,15 +115,45 @@ func (oc *OfferCache) Rescind(offerId *mesos.OfferID) bool {
 	oc.mut.Lock()
 	defer oc.mut.Unlock()
 	_, present := oc.offerSet[offerId.GetValue()]
-	delete(oc.offerSet, offerId.GetValue())
+	oc.remove(offerId.GetValue())
 	return present
 }
 
+// Expire removes and returns the cached offers pushed before cutoff, so
+// that the caller may decline them.
+func (oc *OfferCache) Expire(cutoff time.Time) []*mesos.Offer {
+	oc.mut.Lock()
+	defer oc.mut.Unlock()
+	expired := []*mesos.Offer{}
+	for id, offer := range oc.offerSet {
+		if oc.pushedAt[id].Before(cutoff) {
+			oc.remove(id)
+			expired = append(expired, offer)
+		}
+	}
+	return expired
+}
+
+// SetTimeSource replaces the function used to timestamp pushed offers.
+func (oc *OfferCache) SetTimeSource(now func() time.Time) {
+	oc.mut.Lock()
+	defer oc.mut.Unlock()
+	oc.now = now
+}
+
+// remove drops an offer from the set.  It remains in offerQueue, where
+// BlockingPop and gc will skip it.  Not thread safe!  Callers must hold
+// oc.mut.
+func (oc *OfferCache) remove(id string) {
+	delete(oc.offerSet, id)
+	delete(oc.pushedAt, id)
+}
+
 func (oc *OfferCache) BlockingPop() *mesos.Offer {
 	for offer := range oc.offerQueue {
 		oc.mut.Lock()
 		if _, ok := oc.offerSet[offer.GetId().GetValue()]; ok {
-			delete(oc.offerSet, offer.GetId().GetValue())
+			oc.remove(offer.GetId().GetValue())
 			oc.mut.Unlock()
 			return offer
 		}
@@ -94,6 +164,61 @@ func (oc *OfferCache) BlockingPop() *mesos.Offer {
 	return nil
 }
 
+// PopMatching removes and returns a cached offer for which match returns
+// true, or nil if there is none.  Unlike BlockingPop it never waits.
+func (oc *OfferCache) PopMatching(match func(*mesos.Offer) bool) *mesos.Offer {
+	oc.mut.Lock()
+	defer oc.mut.Unlock()
+	for id, offer := range oc.offerSet {
+		if match(offer) {
+			oc.remove(id)
+			return offer
+		}
+	}
+	return nil
+}
+
+// SetSingleInstancePerSlave changes whether the cache accepts more than one
+// offer per slave.  When enabling it, all but one of any cached offers from
+// the same slave are removed and returned so that the caller may decline
+// them.
+func (oc *OfferCache) SetSingleInstancePerSlave(single bool) []*mesos.Offer {
+	oc.mut.Lock()
+	defer oc.mut.Unlock()
+	oc.singleInstancePerSlave = single
+	return oc.dedup()
+}
+
+// SetDedupKey changes what identifies the machine an offer comes from.
+// Cached offers that are now duplicates are removed and returned so that
+// the caller may decline them.
+func (oc *OfferCache) SetDedupKey(key DedupKey) []*mesos.Offer {
+	oc.mut.Lock()
+	defer oc.mut.Unlock()
+	oc.dedupKey = key
+	return oc.dedup()
+}
+
+// dedup removes all but one cached offer per machine when only a single
+// instance may run per slave.  Not thread safe!  Callers must hold oc.mut.
+func (oc *OfferCache) dedup() []*mesos.Offer {
+	removed := []*mesos.Offer{}
+	if !oc.singleInstancePerSlave {
+		return removed
+	}
+	slaves := map[string]struct{}{}
+	for id, offer := range oc.offerSet {
+		key := oc.dedupKey.Of(offer)
+		if _, seen := slaves[key]; seen {
+			oc.remove(id)
+			removed = append(removed, offer)
+			continue
+		}
+		slaves[key] = struct{}{}
+	}
+	return removed
+}
+
 func (oc *OfferCache) Len() int {
 	oc.mut.RLock()
 	defer oc.mut.RUnlock()
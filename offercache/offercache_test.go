@@ -23,6 +23,7 @@ import (
 	"time"
 	//"testing/quick"
 
+	"github.com/gogo/protobuf/proto"
 	mesos "github.com/mesos/mesos-go/mesosproto"
 	util "github.com/mesos/mesos-go/mesosutil"
 	"github.com/stretchr/testify/assert"
@@ -39,7 +40,7 @@ func TestPush(t *testing.T) {
 		// queue up 7 (2 more than cap of 5) and expect 5
 		{[]string{"a", "b", "c", "d", "e", "f", "g"}, 5},
 	} {
-		oc := New(5, false)
+		oc := New(5, false, BySlaveID)
 		for _, o := range tt.offers {
 			oc.Push(newOffer(o, o))
 		}
@@ -63,7 +64,7 @@ func TestRescind(t *testing.T) {
 		// we should have 4 offers cached.
 		{[]string{"a", "b", "c", "d", "e", "f", "g"}, []string{"a", "g"}, 4},
 	} {
-		oc := New(5, false)
+		oc := New(5, false, BySlaveID)
 		for _, o := range tt.offers {
 			oc.Push(newOffer(o, o))
 		}
@@ -77,6 +78,26 @@ func TestRescind(t *testing.T) {
 
 }
 
+func TestExpire(t *testing.T) {
+	now := time.Unix(1000, 0)
+	oc := New(5, false, BySlaveID)
+	oc.SetTimeSource(func() time.Time { return now })
+	oc.Push(newOffer("a", "a"))
+	now = now.Add(time.Second)
+	oc.Push(newOffer("b", "b"))
+
+	expired := oc.Expire(now)
+	if len(expired) != 1 || expired[0].GetId().GetValue() != "a" {
+		t.Fatalf("expected offer a to expire, got %v", expired)
+	}
+	if got := oc.Len(); got != 1 {
+		t.Errorf("got %d cached offers, want 1", got)
+	}
+	if got := oc.BlockingPop().GetId().GetValue(); got != "b" {
+		t.Errorf("popped %s, want b", got)
+	}
+}
+
 func TestBlockingPop(t *testing.T) {
 	for i, tt := range []struct {
 		offers   []string
@@ -90,7 +111,7 @@ func TestBlockingPop(t *testing.T) {
 		// we should be able to pop 4 offers
 		{[]string{"a", "b", "c", "d", "e", "f", "g"}, []string{"a", "g"}, 4},
 	} {
-		oc := New(5, false)
+		oc := New(5, false, BySlaveID)
 		for _, o := range tt.offers {
 			oc.Push(newOffer(o, o))
 		}
@@ -123,7 +144,7 @@ func TestBlockingPop(t *testing.T) {
 }
 
 func Test_gc(t *testing.T) {
-	oc := New(5, false)
+	oc := New(5, false, BySlaveID)
 	for i := 0; i < 5000; i++ {
 		oc.Rescind(util.NewOfferID(string(i - 50)))
 		oc.Push(newOffer(string(i), string(i)))
@@ -137,3 +158,79 @@ func newOffer(offer, slave string) *mesos.Offer {
 		SlaveId: util.NewSlaveID(slave),
 	}
 }
+
+func TestPopMatching(t *testing.T) {
+	oc := New(5, false, BySlaveID)
+	for _, o := range []string{"a", "b", "c"} {
+		oc.Push(newOffer(o, o))
+	}
+	isB := func(o *mesos.Offer) bool { return o.GetId().GetValue() == "b" }
+
+	if got := oc.PopMatching(isB); got.GetId().GetValue() != "b" {
+		t.Errorf("got offer %v, want b", got.GetId().GetValue())
+	}
+	if got := oc.PopMatching(isB); got != nil {
+		t.Errorf("got offer %v, want nil", got.GetId().GetValue())
+	}
+	if got := oc.Len(); got != 2 {
+		t.Errorf("got : %d, want: 2", got)
+	}
+	// BlockingPop skips the offer that was already popped.
+	for _, want := range []string{"a", "c"} {
+		if got := oc.BlockingPop(); got.GetId().GetValue() != want {
+			t.Errorf("got offer %v, want %s", got.GetId().GetValue(), want)
+		}
+	}
+}
+
+func TestSetSingleInstancePerSlave(t *testing.T) {
+	oc := New(5, false, BySlaveID)
+	for _, o := range []string{"a", "b"} {
+		oc.Push(newOffer(o, "slave"))
+	}
+	if got := oc.Len(); got != 2 {
+		t.Errorf("got : %d, want: 2", got)
+	}
+
+	if removed := oc.SetSingleInstancePerSlave(true); len(removed) != 1 {
+		t.Errorf("got %d removed offers, want 1", len(removed))
+	}
+	if got := oc.Len(); got != 1 {
+		t.Errorf("got : %d, want: 1", got)
+	}
+	if oc.Push(newOffer("c", "slave")) {
+		t.Error("accepted a second offer for the same slave")
+	}
+}
+
+func TestHostnameDedup(t *testing.T) {
+	onHost := func(offer, slave, host string) *mesos.Offer {
+		o := newOffer(offer, slave)
+		o.Hostname = proto.String(host)
+		return o
+	}
+	oc := New(5, true, ByHostname)
+	if !oc.Push(onHost("a", "slave-1", "host-1")) {
+		t.Error("rejected the first offer for a host")
+	}
+	if oc.Push(onHost("b", "slave-2", "host-1")) {
+		t.Error("accepted a second offer for the same host from another slave")
+	}
+	if !oc.Push(onHost("c", "slave-3", "host-2")) {
+		t.Error("rejected an offer for another host")
+	}
+
+	oc = New(5, true, BySlaveID)
+	oc.Push(onHost("a", "slave-1", "host-1"))
+	oc.Push(onHost("b", "slave-2", "host-1"))
+	if removed := oc.SetDedupKey(ByHostname); len(removed) != 1 {
+		t.Errorf("got %d removed offers, want 1", len(removed))
+	}
+	if got := oc.Len(); got != 1 {
+		t.Errorf("got : %d, want: 1", got)
+	}
+
+	if _, err := ParseDedupKey("rack"); err == nil {
+		t.Error("accepted an unknown dedup key")
+	}
+}
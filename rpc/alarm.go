@@ -0,0 +1,70 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	log "github.com/golang/glog"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+const (
+	// AlarmNoSpace is raised when a member's backend exceeds its quota,
+	// after which the cluster rejects writes.
+	AlarmNoSpace = "NOSPACE"
+	// AlarmCorrupt is raised when a member's data is found to be corrupt.
+	AlarmCorrupt = "CORRUPT"
+)
+
+// Alarm is an etcd cluster alarm raised against a member.
+type Alarm struct {
+	MemberID string `json:"memberID"`
+	Alarm    string `json:"alarm"`
+}
+
+// AlarmList returns the cluster's active alarms as reported by the first
+// reachable member.  Clusters without the etcd v3 API have no alarms, so
+// an empty list is returned for them.
+func AlarmList(running map[string]*config.Node) ([]Alarm, error) {
+	req := struct {
+		Action string `json:"action"`
+	}{
+		Action: "GET",
+	}
+
+	var err error
+	for _, args := range probeOrder(running, "") {
+		var resp struct {
+			Alarms []Alarm `json:"alarms"`
+		}
+		err = v3Post(args, "/v3/maintenance/alarm", req, &resp)
+		if err == errV3Unsupported {
+			return []Alarm{}, nil
+		}
+		if err != nil {
+			log.Errorf("Could not list alarms via %s: %v", args.Host, err)
+			continue
+		}
+		if resp.Alarms == nil {
+			resp.Alarms = []Alarm{}
+		}
+		return resp.Alarms, nil
+	}
+	return nil, wrapErr(ErrNoReachableMembers, err)
+}
@@ -0,0 +1,57 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+func TestAlarmList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v3/maintenance/alarm" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"header":{},"alarms":[{"memberID":"42","alarm":"NOSPACE"}]}`))
+	}))
+	defer server.Close()
+
+	alarms, err := AlarmList(map[string]*config.Node{
+		"etcd-1": newTestNode(t, "etcd-1", server),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Alarm{{MemberID: "42", Alarm: AlarmNoSpace}}, alarms)
+}
+
+func TestAlarmListWithoutV3(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	alarms, err := AlarmList(map[string]*config.Node{
+		"etcd-1": newTestNode(t, "etcd-1", server),
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, alarms)
+}
@@ -0,0 +1,75 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rpc
+
+import (
+	log "github.com/golang/glog"
+
+	"github.com/mesosphere/etcd-mesos/config"
+	"github.com/mesosphere/etcd-mesos/errors"
+)
+
+// leaderStatus returns the member that reports itself leader through the
+// etcd v3 status API, along with its status.
+func leaderStatus(running map[string]*config.Node) (*config.Node, v3StatusResponse, error) {
+	var err error
+	for _, args := range probeOrder(running, "") {
+		var status v3StatusResponse
+		err = v3Post(args, "/v3/maintenance/status", struct{}{}, &status)
+		if err != nil {
+			log.Errorf("Could not query %s for status: %v", args.Host, err)
+			continue
+		}
+		if status.Header.MemberID == status.Leader {
+			return args, status, nil
+		}
+	}
+	if err != nil {
+		return nil, v3StatusResponse{}, wrapErr(ErrNoReachableMembers, err)
+	}
+	return nil, v3StatusResponse{}, errors.ErrNoLeader
+}
+
+// CurrentRevision returns the revision of the cluster's keyspace, as
+// reported by the leader.
+func CurrentRevision(running map[string]*config.Node) (int64, error) {
+	_, status, err := leaderStatus(running)
+	return status.Header.Revision, err
+}
+
+// Compact discards the keyspace history of the cluster before revision
+// rev, issuing the compaction against the leader.  Unlike defragmentation,
+// this reclaims no disk by itself, but frees the backend space that a
+// following defragmentation returns to the filesystem.
+func Compact(running map[string]*config.Node, rev int64) error {
+	leader, _, err := leaderStatus(running)
+	if err != nil {
+		return err
+	}
+	req := struct {
+		Revision int64 `json:"revision,string"`
+	}{
+		Revision: rev,
+	}
+	log.Infof("Compacting the cluster to revision %d via %s", rev, leader.Name)
+	err = v3Post(leader, "/v3/kv/compaction", req, nil)
+	if err != nil {
+		log.Errorf("Failed to compact the cluster to revision %d: %v", rev, err)
+	}
+	return err
+}
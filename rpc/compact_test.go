@@ -0,0 +1,66 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+func TestCompactIssuedAgainstLeader(t *testing.T) {
+	compacted := map[string]string{}
+	newServer := func(name, memberID string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v3/maintenance/status":
+				w.Write([]byte(`{"header":{"member_id":"` + memberID +
+					`","revision":"500"},"leader":"1"}`))
+			case "/v3/kv/compaction":
+				var req struct {
+					Revision string `json:"revision"`
+				}
+				json.NewDecoder(r.Body).Decode(&req)
+				compacted[name] = req.Revision
+				w.Write([]byte(`{}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	}
+	leader := newServer("etcd-1", "1")
+	defer leader.Close()
+	follower := newServer("etcd-2", "2")
+	defer follower.Close()
+	running := map[string]*config.Node{
+		"etcd-1": newTestNode(t, "etcd-1", leader),
+		"etcd-2": newTestNode(t, "etcd-2", follower),
+	}
+
+	rev, err := CurrentRevision(running)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), rev)
+
+	assert.NoError(t, Compact(running, 400))
+	assert.Equal(t, map[string]string{"etcd-1": "400"}, compacted)
+}
@@ -18,7 +18,27 @@
 
 package rpc
 
-import "time"
+import (
+	"time"
+
+	"github.com/mesosphere/etcd-mesos/clock"
+)
+
+// clk is used for all backoffs and deadlines, so that tests may swap in a
+// fake clock.
+var clk clock.Clock = clock.Real{}
 
 const RPC_RETRIES = 5
 const RPC_TIMEOUT = time.Second * 5
+
+// RPC_PROBE_TIMEOUT is used for quick liveness probes that decide which
+// members are worth sending a real request to.
+const RPC_PROBE_TIMEOUT = time.Second
+
+// LEARNER_SYNC_TIMEOUT bounds how long a learner may take to catch up
+// with the leader before we give up on promoting it.
+const LEARNER_SYNC_TIMEOUT = time.Minute * 2
+
+// LEADER_STABLE_TIMEOUT bounds how long we wait for the cluster to settle
+// on a leader before changing its membership.
+const LEADER_STABLE_TIMEOUT = time.Minute * 2
@@ -0,0 +1,84 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+// contacts records when each member last answered a request, keyed by
+// the host:port of its client URL.
+var contacts = struct {
+	sync.Mutex
+	last map[string]time.Time
+}{last: map[string]time.Time{}}
+
+// contactTransport records a contact with the host of every request that
+// gets a response, whatever its status, as any response shows the member
+// to be reachable.
+type contactTransport struct {
+	base http.RoundTripper
+}
+
+func (t contactTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		noteContact(req.URL.Host)
+	}
+	return resp, err
+}
+
+// recordingContacts wraps base, or the default transport if it is nil,
+// to record contacts.
+func recordingContacts(base *http.Transport) http.RoundTripper {
+	if base == nil {
+		return contactTransport{http.DefaultTransport}
+	}
+	return contactTransport{base}
+}
+
+func noteContact(host string) {
+	contacts.Lock()
+	defer contacts.Unlock()
+	contacts.last[host] = clk.Now()
+}
+
+// contactHost returns the key under which contacts with node are recorded.
+func contactHost(node *config.Node) string {
+	u, err := url.Parse(clientURL(node))
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// LastContact returns when node last answered a request from this
+// process, or the zero time if it never has.  A member that the rest of
+// the cluster considers healthy, but that has not been contacted for a
+// while, is likely partitioned from the scheduler.
+func LastContact(node *config.Node) time.Time {
+	contacts.Lock()
+	defer contacts.Unlock()
+	return contacts.last[contactHost(node)]
+}
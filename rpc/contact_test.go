@@ -0,0 +1,58 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+func TestLastContactGoesStaleForUnreachableMember(t *testing.T) {
+	defer useSkipClock()()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"etcdserver":"3.0.0"}`))
+	})
+	up := httptest.NewServer(handler)
+	defer up.Close()
+	partitioned := httptest.NewServer(handler)
+	defer partitioned.Close()
+	running := map[string]*config.Node{
+		"etcd-1": newTestNode(t, "etcd-1", up),
+		"etcd-2": newTestNode(t, "etcd-2", partitioned),
+	}
+	never := &config.Node{Name: "etcd-3", Host: "127.0.0.1", ClientPort: 1}
+	assert.True(t, LastContact(never).IsZero())
+
+	MemberVersions(running)
+	first := clk.Now()
+	assert.Equal(t, first, LastContact(running["etcd-1"]))
+	assert.Equal(t, first, LastContact(running["etcd-2"]))
+
+	partitioned.Close()
+	clk.(skipClock).Advance(time.Minute)
+	MemberVersions(running)
+	assert.Equal(t, first.Add(time.Minute), LastContact(running["etcd-1"]))
+	assert.Equal(t, first, LastContact(running["etcd-2"]),
+		"A member that stops answering should keep its old last contact.")
+}
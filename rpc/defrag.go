@@ -0,0 +1,74 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"sort"
+
+	log "github.com/golang/glog"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+// DefragOrder returns the running members in the order they should be
+// defragmented: followers by name, then the leader last, so that the
+// leader stays available for as long as possible.  Members that do not
+// answer a probe are left out, as they can't be defragmented anyway.
+func DefragOrder(running map[string]*config.Node) []*config.Node {
+	var (
+		followers = []*config.Node{}
+		leader    *config.Node
+	)
+	for _, args := range running {
+		state, err := memberState(args)
+		if err != nil {
+			log.Warningf("Skipping unresponsive member %s for defrag: %v",
+				args.Name, err)
+			continue
+		}
+		if state == "StateLeader" {
+			leader = args
+		} else {
+			followers = append(followers, args)
+		}
+	}
+	sort.Sort(byName(followers))
+	if leader != nil {
+		followers = append(followers, leader)
+	}
+	return followers
+}
+
+type byName []*config.Node
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+
+// Defragment compacts the backend of a single member, which blocks that
+// member from serving requests until it is done.  It requires the etcd v3
+// API, as v2 clusters have no backend to defragment.
+func Defragment(node *config.Node) error {
+	log.Infof("Defragmenting member %s", node.Name)
+	err := v3Post(node, "/v3/maintenance/defragment", struct{}{}, nil)
+	if err != nil {
+		log.Errorf("Failed to defragment member %s: %v", node.Name, err)
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+func newMemberServer(state string, defragged *[]string, name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/stats/self":
+			w.Write([]byte(`{"state":"` + state + `"}`))
+		case "/v3/maintenance/defragment":
+			*defragged = append(*defragged, name)
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestDefragOrderLeaderLast(t *testing.T) {
+	var defragged []string
+	running := map[string]*config.Node{}
+	for name, state := range map[string]string{
+		"etcd-1": "StateLeader",
+		"etcd-2": "StateFollower",
+		"etcd-3": "StateFollower",
+	} {
+		server := newMemberServer(state, &defragged, name)
+		defer server.Close()
+		running[name] = newTestNode(t, name, server)
+	}
+	dead := httptest.NewServer(http.NotFoundHandler())
+	running["etcd-4"] = newTestNode(t, "etcd-4", dead)
+	dead.Close()
+
+	order := []string{}
+	for _, node := range DefragOrder(running) {
+		order = append(order, node.Name)
+		assert.NoError(t, Defragment(node))
+	}
+	assert.Equal(t, []string{"etcd-2", "etcd-3", "etcd-1"}, order)
+	assert.Equal(t, order, defragged)
+}
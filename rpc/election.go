@@ -0,0 +1,145 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const electionPrefix = "candidate-"
+
+// ErrLeadershipLost is returned by Election.Wait when our candidate znode
+// disappears, usually because the ZK session expired.
+var ErrLeadershipLost = errors.New("lost scheduler leadership")
+
+// Election is a scheduler's candidacy for leadership of a framework,
+// represented by an ephemeral sequential znode under
+// <zkChroot>/<frameworkName>_leader.  The candidate with the lowest
+// sequence number is the leader.
+type Election struct {
+	conn zkConn
+	dir  string
+	node string
+}
+
+// ElectLeader registers a candidate identified by id and blocks until it
+// becomes the leader.  Standbys watch only the candidate immediately ahead
+// of them, so a leader's death wakes exactly one standby.
+func ElectLeader(
+	zkServers []string,
+	zkChroot string,
+	frameworkName string,
+	id string,
+) (*Election, error) {
+	c, err := connectZK(zkServers)
+	if err != nil {
+		return nil, err
+	}
+	e := &Election{
+		conn: c,
+		dir:  zkPath(zkChroot, frameworkName, "_leader"),
+	}
+	if err = createPath(c, e.dir); err != nil {
+		c.Close()
+		return nil, err
+	}
+	e.node, err = c.Create(e.dir+"/"+electionPrefix, []byte(id),
+		zk.FlagEphemeral|zk.FlagSequence, zk.WorldACL(zk.PermAll))
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	log.Infof("Registered as scheduler leadership candidate %s", e.node)
+
+	for {
+		predecessor, err := e.predecessor()
+		if err != nil {
+			e.Resign()
+			return nil, err
+		}
+		if predecessor == "" {
+			log.Infof("Candidate %s is now the scheduler leader", e.node)
+			return e, nil
+		}
+		exists, _, events, err := c.ExistsW(e.dir + "/" + predecessor)
+		if err != nil {
+			e.Resign()
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		log.Infof("Standing by until candidate %s goes away", predecessor)
+		<-events
+	}
+}
+
+// predecessor returns the name of the candidate directly ahead of ours,
+// or "" if we are first in line.
+func (e *Election) predecessor() (string, error) {
+	children, _, err := e.conn.Children(e.dir)
+	if err != nil {
+		return "", err
+	}
+	candidates := []string{}
+	for _, child := range children {
+		if strings.HasPrefix(child, electionPrefix) {
+			candidates = append(candidates, child)
+		}
+	}
+	sort.Strings(candidates)
+	ours := e.node[len(e.dir)+1:]
+	predecessor := ""
+	for _, candidate := range candidates {
+		if candidate == ours {
+			return predecessor, nil
+		}
+		predecessor = candidate
+	}
+	return "", ErrLeadershipLost
+}
+
+// Wait blocks for as long as we remain the leader, returning
+// ErrLeadershipLost once our candidate znode is gone.
+func (e *Election) Wait() error {
+	for {
+		exists, _, events, err := e.conn.ExistsW(e.node)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrLeadershipLost
+		}
+		<-events
+	}
+}
+
+// Resign gives up leadership, or our place in line, allowing the next
+// candidate to take over.
+func (e *Election) Resign() {
+	if err := e.conn.Delete(e.node, -1); err != nil && err != zk.ErrNoNode {
+		log.Warningf("Failed to delete candidate znode %s: %s", e.node, err)
+	}
+	e.conn.Close()
+}
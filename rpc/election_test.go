@@ -0,0 +1,67 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElectLeaderPromotesStandby(t *testing.T) {
+	fake := newFakeZK()
+	defer fake.install()()
+	servers := []string{"localhost:2181"}
+
+	leader, err := ElectLeader(servers, "/etcd", "etcd", "scheduler-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	promoted := make(chan *Election)
+	go func() {
+		standby, err := ElectLeader(servers, "/etcd", "etcd", "scheduler-b")
+		assert.NoError(t, err)
+		promoted <- standby
+	}()
+
+	select {
+	case <-promoted:
+		t.Fatal("standby became active while the leader was alive")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	lost := make(chan error)
+	go func() { lost <- leader.Wait() }()
+
+	// Simulate the leader's session expiring, removing its ephemeral node.
+	assert.NoError(t, fake.Delete(leader.node, -1))
+	assert.Equal(t, ErrLeadershipLost, <-lost)
+
+	select {
+	case standby := <-promoted:
+		assert.Equal(t, "scheduler-b", string(fake.nodes[standby.node]))
+		standby.Resign()
+		_, present := fake.nodes[standby.node]
+		assert.False(t, present)
+	case <-time.After(5 * time.Second):
+		t.Fatal("standby was not promoted after leader death")
+	}
+}
@@ -0,0 +1,90 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrNoReachableMembers is returned when no running member could be
+	// reached to serve a request.
+	ErrNoReachableMembers = errors.New("no etcd members reachable")
+	// ErrEmptyMemberList is returned when a member reports that the cluster
+	// has no members.
+	ErrEmptyMemberList = errors.New("etcd member returned an empty member list")
+	// ErrMemberNotFound is returned when a member is absent from the
+	// cluster configuration.
+	ErrMemberNotFound = errors.New("member not found in etcd cluster configuration")
+	// ErrNoRunningMembers is returned when an operation needs running
+	// members and there are none.
+	ErrNoRunningMembers = errors.New("no running etcd members")
+	// ErrConfigureFailed is returned when the cluster configuration could
+	// not be changed.
+	ErrConfigureFailed = errors.New("failed to configure etcd cluster membership")
+	// ErrLearnerTimeout is returned when a learner does not catch up with
+	// the leader in time to be promoted.
+	ErrLearnerTimeout = errors.New("learner did not catch up with the leader")
+	// ErrLeaderUnstable is returned when the cluster does not settle on a
+	// leader in time for a membership change.
+	ErrLeaderUnstable = errors.New("etcd cluster leader did not stabilize")
+)
+
+// EtcdError is the error envelope etcd returns alongside a failed HTTP
+// status from its v2 API.
+type EtcdError struct {
+	StatusCode int    `json:"-"`
+	ErrorCode  int    `json:"errorCode"`
+	Message    string `json:"message"`
+	Cause      string `json:"cause,omitempty"`
+}
+
+func (e *EtcdError) Error() string {
+	msg := fmt.Sprintf("etcd returned %d (error %d): %s", e.StatusCode, e.ErrorCode, e.Message)
+	if e.Cause != "" {
+		msg += " (" + e.Cause + ")"
+	}
+	return msg
+}
+
+// responseError returns nil for a successful response, or the etcd error
+// the body carries otherwise.  Bodies without an etcd error envelope are
+// reported as they are.
+func responseError(resp *http.Response, body []byte) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	etcdErr := &EtcdError{StatusCode: resp.StatusCode}
+	if err := json.Unmarshal(body, etcdErr); err != nil || etcdErr.Message == "" {
+		return fmt.Errorf("etcd returned %s: %s", resp.Status, string(body))
+	}
+	return etcdErr
+}
+
+// wrapErr returns kind, annotated with the last underlying error seen if
+// there was one, such that errors.Is(err, kind) holds.
+func wrapErr(kind, last error) error {
+	if last == nil || last == kind {
+		return kind
+	}
+	return fmt.Errorf("%w: %v", kind, last)
+}
@@ -0,0 +1,118 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/etcdhttp/httptypes"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/clock"
+	"github.com/mesosphere/etcd-mesos/config"
+	emtesting "github.com/mesosphere/etcd-mesos/testing"
+)
+
+// skipClock is a fake clock that advances itself instead of sleeping, so
+// that backoffs and deadlines pass instantly.
+type skipClock struct {
+	*clock.Fake
+}
+
+func (c skipClock) Sleep(d time.Duration) { c.Advance(d) }
+
+func useSkipClock() func() {
+	previous := clk
+	clk = skipClock{clock.NewFake(time.Now())}
+	return func() { clk = previous }
+}
+
+func TestErrorKinds(t *testing.T) {
+	defer useSkipClock()()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	downNode := newTestNode(t, "etcd-1", down)
+	down.Close()
+	unreachable := map[string]*config.Node{"etcd-1": downNode}
+
+	_, err := MemberList(unreachable)
+	assert.True(t, errors.Is(err, ErrNoReachableMembers), "MemberList: %v", err)
+
+	_, err = AlarmList(unreachable)
+	assert.True(t, errors.Is(err, ErrNoReachableMembers), "AlarmList: %v", err)
+
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"members":[]}`))
+	}))
+	defer empty.Close()
+	_, err = MemberList(map[string]*config.Node{"etcd-1": newTestNode(t, "etcd-1", empty)})
+	assert.True(t, errors.Is(err, ErrEmptyMemberList), "MemberList: %v", err)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"errorCode":300,"message":"Raft Internal Error","cause":"etcdserver: request timed out"}`))
+	}))
+	defer failing.Close()
+	_, err = MemberList(map[string]*config.Node{"etcd-1": newTestNode(t, "etcd-1", failing)})
+	var etcdErr *EtcdError
+	if assert.True(t, errors.As(err, &etcdErr), "MemberList: %v", err) {
+		assert.Equal(t, http.StatusInternalServerError, etcdErr.StatusCode)
+		assert.Equal(t, 300, etcdErr.ErrorCode)
+		assert.Equal(t, "Raft Internal Error", etcdErr.Message)
+	}
+
+	err = RemoveInstance(map[string]*config.Node{}, "etcd-1", 1, true)
+	assert.True(t, errors.Is(err, ErrNoRunningMembers), "RemoveInstance: %v", err)
+
+	_, port, err := emtesting.NewTestEtcdServer(t, config.ClusterMemberList{
+		Members: []httptypes.Member{{ID: "1", Name: "etcd-1"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test etcd server: %s", err)
+	}
+	running := map[string]*config.Node{
+		"etcd-1": {Name: "etcd-1", Host: "localhost", ClientPort: uint64(port)},
+	}
+	err = RemoveInstance(running, "etcd-9", 1, true)
+	assert.True(t, errors.Is(err, ErrMemberNotFound), "RemoveInstance: %v", err)
+}
+
+func TestPromoteLearnerTimesOut(t *testing.T) {
+	defer useSkipClock()()
+
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"header":{"member_id":"1"},"leader":"1","raftIndex":"100"}`))
+	}))
+	defer leader.Close()
+	learner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"header":{"member_id":"42"},"leader":"1","raftIndex":"1","isLearner":true}`))
+	}))
+	defer learner.Close()
+
+	err := PromoteLearner(
+		map[string]*config.Node{"etcd-1": newTestNode(t, "etcd-1", leader)},
+		newTestNode(t, "etcd-2", learner),
+		"42",
+	)
+	assert.True(t, errors.Is(err, ErrLearnerTimeout), "PromoteLearner: %v", err)
+}
@@ -22,7 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
+	"strings"
 	"time"
 
 	"github.com/mesosphere/etcd-mesos/config"
@@ -33,87 +33,269 @@ import (
 	log "github.com/golang/glog"
 )
 
+// HealthProbe describes the request made to each member to find one that
+// can answer for the cluster before the raft progress checks run.
+type HealthProbe struct {
+	// Path is requested from the member's client URL.
+	Path string
+	// Healthy reports whether the response body shows a working member.
+	Healthy func(body []byte) bool
+	// RaftProgress is whether the member found is then watched through the
+	// v2 keys API for raft term changes and stalled commits.
+	RaftProgress bool
+}
+
+// LeaderStatsProbe is the default probe, which expects valid leader stats.
+var LeaderStatsProbe = HealthProbe{
+	Path:         "/v2/stats/leader",
+	Healthy:      leaderStatsHealthy,
+	RaftProgress: true,
+}
+
+// HealthEndpointProbe queries etcd's /health endpoint, for versions or
+// proxies that do not expose the v2 APIs.  etcd's /health already fails
+// without a leader or with raft stalled, so the check ends there.
+var HealthEndpointProbe = HealthProbe{
+	Path:    "/health",
+	Healthy: healthEndpointHealthy,
+}
+
+// healthProbe is the probe used by HealthCheck.
+var healthProbe = LeaderStatsProbe
+
+// SetHealthProbe replaces the probe used by HealthCheck.  It is meant to
+// be called once during startup.
+func SetHealthProbe(probe HealthProbe) {
+	healthProbe = probe
+}
+
+// ParseHealthProbe returns the probe for the named response format, which
+// is either "leader-stats" or "health".  A non-empty path overrides the
+// format's default path.
+func ParseHealthProbe(format, path string) (HealthProbe, error) {
+	var probe HealthProbe
+	switch format {
+	case "leader-stats":
+		probe = LeaderStatsProbe
+	case "health":
+		probe = HealthEndpointProbe
+	default:
+		return HealthProbe{}, fmt.Errorf("unknown health check format %q", format)
+	}
+	if path != "" {
+		if !strings.HasPrefix(path, "/") {
+			return HealthProbe{}, fmt.Errorf("health check path %q must begin with /", path)
+		}
+		probe.Path = path
+	}
+	return probe, nil
+}
+
+func leaderStatsHealthy(body []byte) bool {
+	return json.Unmarshal(body, &etcdstats.LeaderStats{}) == nil
+}
+
+// healthEndpointHealthy accepts both {"health":"true"} from older etcd
+// releases and {"health":"true","reason":""} from newer ones, as well as
+// proxies reporting health as a JSON boolean.
+func healthEndpointHealthy(body []byte) bool {
+	var resp struct {
+		Health json.RawMessage `json:"health"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false
+	}
+	switch string(resp.Health) {
+	case `"true"`, `true`:
+		return true
+	}
+	return false
+}
+
 // HealthCheck performs basic sanity checks on an etcd cluster.
 // This function explicitly forgoes backoffs.  If it fails
 // something, it is assumed to be unhealthy.
 func HealthCheck(running map[string]*config.Node) error {
+	return HealthCheckPreferring(running, "")
+}
+
+// HealthCheckPreferring is HealthCheck, but queries the named member, such
+// as the known leader, before any other.
+func HealthCheckPreferring(running map[string]*config.Node, preferred string) error {
 	// TODO(tyler) invariant: all nodes have same leader
 	if len(running) == 0 {
 		return nil
 	}
-	var validEndpoint string
-	for _, args := range running {
-		url := fmt.Sprintf(
-			"http://%s:%d",
-			args.Host,
-			args.ClientPort,
-		)
-		client := http.Client{
-			Timeout: RPC_TIMEOUT,
-		}
-		resp, err := client.Get(url + "/v2/stats/leader")
-		if err != nil {
-			log.Errorf("Could not query %s for leader stats: %+v", url, err)
-			continue
-		}
-		defer resp.Body.Close()
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Errorf("Could not query %s for leader stats", url)
-			continue
-		}
-		log.Info("Leader stats response:", string(body))
-		ls := &etcdstats.LeaderStats{}
-		err = json.Unmarshal(body, ls)
-		if err != nil {
-			log.Errorf("received invalid LeaderStats from endpoint %s:%s",
-				url, string(body))
-			continue
-		}
-		validEndpoint = url
-		break
-	}
-
+	validEndpoint, reached := healthyEndpoint(running, preferred, healthProbe)
 	if validEndpoint == "" {
 		log.Error("Leader could not be determined.")
-		return errors.ErrNoLeader
+		if !reached {
+			return errors.Unreachable(errors.ErrNoLeader)
+		}
+		return errors.Unhealthy(errors.ErrNoLeader)
+	}
+	if !healthProbe.RaftProgress {
+		return nil
 	}
 
 	// This has a 1s dial timeout, which is ok for us
-	client := etcd.NewClient([]string{validEndpoint})
+	client := newV2Client(validEndpoint)
 	client.SetDialTimeout(RPC_TIMEOUT)
 	if ok := client.SyncCluster(); !ok {
 		log.Errorf("Could not establish connection "+
 			"with cluster using endpoints %s", validEndpoint)
-		return errors.ErrEtcdConnection
+		return errors.Unreachable(errors.ErrEtcdConnection)
 	}
 
 	resp1, err := client.Get("/", false, false)
 	if err != nil {
 		log.Errorf("Could not query cluster: %s", err)
-		return errors.ErrEtcdEndpoint
+		return queryFailure(err)
 	}
 
 	// Give the cluster some time to propagate AppendEntries.
-	time.Sleep(time.Second)
+	clk.Sleep(time.Second)
 
 	resp2, err := client.Get("/", false, false)
 	if err != nil {
 		log.Errorf("Could not query cluster: %s", err)
-		return errors.ErrEtcdEndpoint
+		return queryFailure(err)
 	}
 
 	if resp1.RaftTerm != resp2.RaftTerm {
 		log.Error("Raft terms has increased while monitoring for " +
 			"1 second.  Leader is unstable.")
-		return errors.ErrEtcdRaftTermInstability
+		return errors.Unhealthy(errors.ErrEtcdRaftTermInstability)
 	}
 
 	if resp1.RaftIndex == resp2.RaftIndex {
 		log.Error("Raft commit index has not increased while " +
 			"monitoring for 1 second.  The cluster is not making progress.")
-		return errors.ErrEtcdRaftStall
+		return errors.Unhealthy(errors.ErrEtcdRaftStall)
 	}
 	return nil
 }
+
+// queryFailure classifies the error from querying a member that passed
+// its health probe: by whether the member could no longer be reached, or
+// answered with an error.
+func queryFailure(err error) error {
+	if etcdErr, ok := err.(*etcd.EtcdError); ok &&
+		etcdErr.ErrorCode != etcd.ErrCodeEtcdNotReachable {
+		return errors.Unhealthy(errors.ErrEtcdEndpoint)
+	}
+	return errors.Unreachable(errors.ErrEtcdEndpoint)
+}
+
+// CurrentLeader returns the ID of the member that reports itself leader
+// through the leader stats endpoint.
+func CurrentLeader(running map[string]*config.Node) (string, error) {
+	_, id, err := leaderStats(running)
+	return id, err
+}
+
+// leaderName returns the name of the running member that is the leader,
+// or "" if none could be found, so that requests best served by the
+// leader can be sent there first.
+func leaderName(running map[string]*config.Node) string {
+	leader, _, err := leaderStats(running)
+	if err != nil {
+		return ""
+	}
+	return leader.Name
+}
+
+// leaderStats returns the member that serves the leader stats endpoint,
+// which only the leader does, along with its member ID.
+func leaderStats(running map[string]*config.Node) (*config.Node, string, error) {
+	for _, args := range probeOrder(running, "") {
+		url := clientURL(args) + "/v2/stats/leader"
+		client := etcdClient(RPC_TIMEOUT)
+		resp, err := client.Get(url)
+		if err != nil {
+			log.Errorf("Could not query %s for leader stats: %+v", url, err)
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			continue
+		}
+		ls := &etcdstats.LeaderStats{}
+		if err = json.Unmarshal(body, ls); err != nil || ls.Leader == "" {
+			continue
+		}
+		return args, ls.Leader, nil
+	}
+	return nil, "", errors.ErrNoLeader
+}
+
+// waitForStableLeader blocks until the same member has been leader for at
+// least window, or returns ErrLeaderUnstable after LEADER_STABLE_TIMEOUT.
+// A window of zero returns immediately.
+func waitForStableLeader(running map[string]*config.Node, window time.Duration) error {
+	if window <= 0 {
+		return nil
+	}
+	var (
+		leader   string
+		since    time.Time
+		deadline = clk.Now().Add(LEADER_STABLE_TIMEOUT)
+	)
+	for clk.Now().Before(deadline) {
+		current, err := CurrentLeader(running)
+		switch {
+		case err != nil:
+			leader = ""
+		case current != leader:
+			log.Infof("Leader is now %s, waiting %s for it to stabilize.", current, window)
+			leader, since = current, clk.Now()
+		case clk.Since(since) >= window:
+			return nil
+		}
+		clk.Sleep(time.Second)
+	}
+	return ErrLeaderUnstable
+}
+
+// MemberHealthy returns whether the given member answers the health probe
+// with a healthy response.
+func MemberHealthy(node *config.Node) bool {
+	url, _ := healthyEndpoint(map[string]*config.Node{node.Name: node}, "", healthProbe)
+	return url != ""
+}
+
+// healthyEndpoint returns the client URL of the first member whose probe
+// response is healthy, or "" if there is none, along with whether any
+// member responded at all.
+func healthyEndpoint(
+	running map[string]*config.Node,
+	preferred string,
+	probe HealthProbe,
+) (string, bool) {
+	reached := false
+	for _, args := range probeOrder(running, preferred) {
+		url := clientURL(args)
+		client := etcdClient(RPC_TIMEOUT)
+		resp, err := client.Get(url + probe.Path)
+		if err != nil {
+			log.Errorf("Could not query %s%s: %+v", url, probe.Path, err)
+			continue
+		}
+		reached = true
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Errorf("Could not read %s%s", url, probe.Path)
+			continue
+		}
+		log.V(2).Infof("Health check response from %s%s: %s", url, probe.Path, string(body))
+		if !probe.Healthy(body) {
+			log.Errorf("received unhealthy response from endpoint %s%s: %s",
+				url, probe.Path, string(body))
+			continue
+		}
+		return url, true
+	}
+	return "", reached
+}
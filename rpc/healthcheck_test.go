@@ -0,0 +1,177 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
+	"github.com/mesosphere/etcd-mesos/errors"
+)
+
+func TestHealthEndpointFormats(t *testing.T) {
+	for body, healthy := range map[string]bool{
+		`{"health":"true"}`:                     true,
+		`{"health":"true","reason":""}`:         true,
+		`{"health":true}`:                       true,
+		`{"health":"false"}`:                    false,
+		`{"health":"false","reason":"NOSPACE"}`: false,
+		`{}`:                                    false,
+		`not json`:                              false,
+	} {
+		assert.Equal(t, healthy, healthEndpointHealthy([]byte(body)), body)
+	}
+
+	assert.True(t, leaderStatsHealthy([]byte(`{"leader":"8e9e05c52164694d","followers":{}}`)))
+	assert.False(t, leaderStatsHealthy([]byte(`404 page not found`)))
+}
+
+func TestParseHealthProbe(t *testing.T) {
+	probe, err := ParseHealthProbe("leader-stats", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "/v2/stats/leader", probe.Path)
+
+	probe, err = ParseHealthProbe("health", "/proxy/health")
+	assert.NoError(t, err)
+	assert.Equal(t, "/proxy/health", probe.Path)
+
+	_, err = ParseHealthProbe("bogus", "")
+	assert.Error(t, err)
+	_, err = ParseHealthProbe("health", "health")
+	assert.Error(t, err)
+}
+
+func TestHealthyEndpointUsesProbe(t *testing.T) {
+	serve := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/health" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+	}
+	old := serve(`{"health":"true"}`)
+	defer old.Close()
+	current := serve(`{"health":"true","reason":""}`)
+	defer current.Close()
+	sick := serve(`{"health":"false","reason":"NOSPACE"}`)
+	defer sick.Close()
+
+	for _, server := range []*httptest.Server{old, current} {
+		running := map[string]*config.Node{
+			"etcd-1": newTestNode(t, "etcd-1", server),
+			"etcd-2": newTestNode(t, "etcd-2", sick),
+		}
+		url, reached := healthyEndpoint(running, "etcd-2", HealthEndpointProbe)
+		assert.Equal(t, server.URL, url)
+		assert.True(t, reached)
+		url, reached = healthyEndpoint(running, "", LeaderStatsProbe)
+		assert.Equal(t, "", url,
+			"A member without the leader stats path should not be considered healthy.")
+		assert.True(t, reached, "Unhealthy members were still reached.")
+	}
+}
+
+func TestHealthFormatNeedsNoV2API(t *testing.T) {
+	defer SetHealthProbe(LeaderStatsProbe)
+	// Only /health is served, as by etcd built without the v2 API.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"health":"true","reason":""}`))
+	}))
+	defer server.Close()
+	running := map[string]*config.Node{"etcd-1": newTestNode(t, "etcd-1", server)}
+
+	SetHealthProbe(HealthEndpointProbe)
+	assert.NoError(t, HealthCheck(running))
+
+	probe, err := ParseHealthProbe("health", "/livez")
+	assert.NoError(t, err)
+	assert.False(t, probe.RaftProgress)
+}
+
+func TestWaitForStableLeader(t *testing.T) {
+	defer useSkipClock()()
+
+	// The leader changes once, as it would after a member add, and then
+	// holds steady.
+	leaders := []string{"a", "a", "b"}
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leader := leaders[len(leaders)-1]
+		if hits < len(leaders) {
+			leader = leaders[hits]
+		}
+		hits++
+		w.Write([]byte(`{"leader":"` + leader + `","followers":{}}`))
+	}))
+	defer server.Close()
+	running := map[string]*config.Node{"etcd-1": newTestNode(t, "etcd-1", server)}
+
+	assert.NoError(t, waitForStableLeader(running, 3*time.Second))
+	assert.Equal(t, 6, hits, "The wait should restart when the leader changes.")
+
+	hits = 0
+	assert.NoError(t, waitForStableLeader(running, 0))
+	assert.Equal(t, 0, hits, "A zero window should not wait at all.")
+
+	// A leader that never settles fails the add rather than piling on
+	// another election.
+	flapping := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte(fmt.Sprintf(`{"leader":"%d","followers":{}}`, hits)))
+	}))
+	defer flapping.Close()
+	running = map[string]*config.Node{"etcd-1": newTestNode(t, "etcd-1", flapping)}
+	assert.Equal(t, ErrLeaderUnstable, waitForStableLeader(running, 3*time.Second))
+}
+
+func TestHealthCheckErrorKinds(t *testing.T) {
+	defer useSkipClock()()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	downNode := newTestNode(t, "etcd-1", down)
+	down.Close()
+	err := HealthCheck(map[string]*config.Node{"etcd-1": downNode})
+	assert.True(t, goerrors.Is(err, errors.ErrUnreachable), "%v", err)
+	assert.False(t, goerrors.Is(err, errors.ErrUnhealthy), "%v", err)
+	assert.True(t, goerrors.Is(err, errors.ErrNoLeader), "%v", err)
+
+	// A member without a leader answers, but unhealthily.
+	leaderless := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("not current leader"))
+	}))
+	defer leaderless.Close()
+	err = HealthCheck(map[string]*config.Node{"etcd-1": newTestNode(t, "etcd-1", leaderless)})
+	assert.True(t, goerrors.Is(err, errors.ErrUnhealthy), "%v", err)
+	assert.False(t, goerrors.Is(err, errors.ErrUnreachable), "%v", err)
+	assert.True(t, goerrors.Is(err, errors.ErrNoLeader), "%v", err)
+}
@@ -0,0 +1,60 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	log "github.com/golang/glog"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+// LeaderMember returns the running member that is the cluster's leader,
+// as reported through the etcd v3 status API.
+func LeaderMember(running map[string]*config.Node) (*config.Node, error) {
+	leader, _, err := leaderStatus(running)
+	return leader, err
+}
+
+// MoveLeader transfers leadership of the cluster from its current leader
+// to target, which must be a running voting member.  It requires the etcd
+// v3 API.
+func MoveLeader(running map[string]*config.Node, target *config.Node) error {
+	leader, _, err := leaderStatus(running)
+	if err != nil {
+		return err
+	}
+	if leader.Name == target.Name {
+		return nil
+	}
+	var status v3StatusResponse
+	if err := v3Post(target, "/v3/maintenance/status", struct{}{}, &status); err != nil {
+		return err
+	}
+	req := struct {
+		TargetID string `json:"targetID"`
+	}{
+		TargetID: status.Header.MemberID,
+	}
+	log.Infof("Moving leadership from %s to %s", leader.Name, target.Name)
+	err = v3Post(leader, "/v3/maintenance/transfer-leadership", req, nil)
+	if err != nil {
+		log.Errorf("Failed to move leadership to %s: %v", target.Name, err)
+	}
+	return err
+}
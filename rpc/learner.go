@@ -0,0 +1,221 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	log "github.com/golang/glog"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+// errV3Unsupported is returned when a member does not serve the etcd v3
+// JSON gateway, meaning we must fall back to the v2 members API.
+var errV3Unsupported = errors.New("etcd v3 API is not available")
+
+type v3Member struct {
+	ID        string   `json:"ID"`
+	Name      string   `json:"name"`
+	PeerURLs  []string `json:"peerURLs"`
+	IsLearner bool     `json:"isLearner"`
+}
+
+type v3MemberAddResponse struct {
+	Member v3Member `json:"member"`
+}
+
+type v3StatusResponse struct {
+	Header struct {
+		MemberID string `json:"member_id"`
+		Revision int64  `json:"revision,string"`
+	} `json:"header"`
+	Leader    string `json:"leader"`
+	RaftIndex uint64 `json:"raftIndex,string"`
+	IsLearner bool   `json:"isLearner"`
+}
+
+// v3Post issues a request against the etcd v3 JSON gateway of a member
+// and decodes the response into out.
+func v3Post(node *config.Node, path string, in, out interface{}) error {
+	return v3PostWith(etcdClient(RPC_TIMEOUT), node, path, in, out)
+}
+
+// v3PostWith is v3Post issued through client.
+func v3PostWith(
+	client *http.Client,
+	node *config.Node,
+	path string,
+	in, out interface{},
+) error {
+	url := clientURL(node) + path
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return errV3Unsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", url, resp.Status, string(body))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// addLearner adds newInstance to the cluster as a non-voting learner,
+// asking the named leader first, and returns its member ID.
+func addLearner(
+	running map[string]*config.Node,
+	newInstance *config.Node,
+	leader string,
+) (string, error) {
+	req := struct {
+		PeerURLs  []string `json:"peerURLs"`
+		IsLearner bool     `json:"isLearner"`
+	}{
+		PeerURLs: []string{
+			newInstance.PeerURL(),
+		},
+		IsLearner: true,
+	}
+
+	var err error
+	for _, args := range probeOrder(running, leader) {
+		var resp v3MemberAddResponse
+		err = v3PostWith(membershipClient(RPC_TIMEOUT), args,
+			"/v3/cluster/member/add", req, &resp)
+		if err == errV3Unsupported {
+			return "", err
+		}
+		if err != nil {
+			log.Errorf("Could not add learner via %s: %v", args.Host, err)
+			continue
+		}
+		log.Infof("Added %s to the cluster as learner %s", newInstance.Name, resp.Member.ID)
+		return resp.Member.ID, nil
+	}
+	return "", wrapErr(ErrNoReachableMembers, err)
+}
+
+// leaderIndex returns the raft index of the current leader, as reported by
+// the first running member that identifies itself as the leader.
+func leaderIndex(running map[string]*config.Node) (uint64, error) {
+	var (
+		highest uint64
+		found   bool
+	)
+	for _, args := range running {
+		var status v3StatusResponse
+		if err := v3Post(args, "/v3/maintenance/status", struct{}{}, &status); err != nil {
+			log.Errorf("Could not query %s for status: %v", args.Host, err)
+			continue
+		}
+		if status.Header.MemberID == status.Leader {
+			return status.RaftIndex, nil
+		}
+		// Fall back to the most advanced follower if the leader itself
+		// is unreachable.
+		if status.RaftIndex > highest {
+			highest = status.RaftIndex
+		}
+		found = true
+	}
+	if !found {
+		return 0, ErrNoReachableMembers
+	}
+	return highest, nil
+}
+
+// PromoteLearner waits for a learner added by ConfigureInstance to catch up
+// with the leader's raft index, then promotes it to a voting member.
+func PromoteLearner(
+	running map[string]*config.Node,
+	learner *config.Node,
+	memberID string,
+) error {
+	target, err := leaderIndex(running)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Waiting for learner %s to reach raft index %d", learner.Name, target)
+	backoff := 1
+	deadline := clk.Now().Add(LEARNER_SYNC_TIMEOUT)
+	for {
+		var status v3StatusResponse
+		err = v3Post(learner, "/v3/maintenance/status", struct{}{}, &status)
+		if err == nil && status.RaftIndex >= target {
+			break
+		}
+		if clk.Now().After(deadline) {
+			return fmt.Errorf("%w: %s not caught up within %v",
+				ErrLearnerTimeout, learner.Name, LEARNER_SYNC_TIMEOUT)
+		}
+		log.Warningf("Learner %s not yet caught up.  Backing off for %d "+
+			"seconds and retrying.", learner.Name, backoff)
+		clk.Sleep(time.Duration(backoff) * time.Second)
+		backoff = int(math.Min(float64(backoff<<1), 8))
+	}
+
+	req := struct {
+		ID string `json:"ID"`
+	}{memberID}
+	backoff = 1
+	budget := newBudget()
+	for retries := 0; retries < RPC_RETRIES; retries++ {
+		for _, args := range probeOrder(running, "") {
+			err = v3PostWith(membershipClient(RPC_TIMEOUT), args,
+				"/v3/cluster/member/promote", req, nil)
+			if err != nil {
+				log.Errorf("Could not promote learner via %s: %v", args.Host, err)
+				continue
+			}
+			log.Infof("Promoted learner %s to a voting member.", learner.Name)
+			return nil
+		}
+		if !budget.allows(time.Duration(backoff) * time.Second) {
+			break
+		}
+		log.Warningf("Failed to promote learner.  Backing off for %d "+
+			"seconds and retrying.", backoff)
+		clk.Sleep(time.Duration(backoff) * time.Second)
+		backoff = int(math.Min(float64(backoff<<1), 8))
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	gotesting "testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+// newTestNode returns a config.Node whose client port points at server.
+func newTestNode(t *gotesting.T, name string, server *httptest.Server) *config.Node {
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPort, err := strconv.ParseUint(port, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &config.Node{
+		Name:       name,
+		Host:       host,
+		RPCPort:    clientPort,
+		ClientPort: clientPort,
+	}
+}
+
+func TestAddThenPromoteLearner(t *gotesting.T) {
+	var (
+		mut   sync.Mutex
+		calls []string
+	)
+	record := func(call string) {
+		mut.Lock()
+		defer mut.Unlock()
+		calls = append(calls, call)
+	}
+
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record("leader " + r.URL.Path)
+		switch r.URL.Path {
+		case "/v3/cluster/member/add":
+			w.Write([]byte(`{"member":{"ID":"42","peerURLs":["http://learner:1"],"isLearner":true}}`))
+		case "/v3/maintenance/status":
+			w.Write([]byte(`{"header":{"member_id":"1"},"leader":"1","raftIndex":"100"}`))
+		case "/v3/cluster/member/promote":
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer leader.Close()
+
+	learner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record("learner " + r.URL.Path)
+		w.Write([]byte(`{"header":{"member_id":"42"},"leader":"1","raftIndex":"100","isLearner":true}`))
+	}))
+	defer learner.Close()
+
+	running := map[string]*config.Node{
+		"etcd-1": newTestNode(t, "etcd-1", leader),
+	}
+	learnerNode := newTestNode(t, "etcd-2", learner)
+
+	id, err := addLearner(running, learnerNode, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "42", id)
+
+	err = PromoteLearner(running, learnerNode, id)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"leader /v3/cluster/member/add",
+		"leader /v3/maintenance/status",
+		"learner /v3/maintenance/status",
+		"leader /v3/cluster/member/promote",
+	}, calls, "learner should be added, caught up, then promoted")
+}
+
+func TestAddLearnerFallsBackOnV2(t *gotesting.T) {
+	v2Only := httptest.NewServer(http.NotFoundHandler())
+	defer v2Only.Close()
+
+	running := map[string]*config.Node{
+		"etcd-1": newTestNode(t, "etcd-1", v2Only),
+	}
+	_, err := addLearner(running, &config.Node{Name: "etcd-2", Host: "localhost"}, "")
+	assert.Equal(t, errV3Unsupported, err,
+		"members without the v3 gateway should trigger the v2 fallback")
+}
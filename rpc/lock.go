@@ -0,0 +1,108 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	log "github.com/golang/glog"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	// ErrFrameworkLocked is returned by LockFramework when another
+	// scheduler already holds the framework lock.
+	ErrFrameworkLocked = errors.New("framework is locked by another scheduler")
+	// ErrFrameworkLockLost is returned by FrameworkLock.Wait when the lock
+	// znode disappears, usually because the ZK session expired.
+	ErrFrameworkLockLost = errors.New("lost the framework lock")
+)
+
+// FrameworkLock is a scheduler's exclusive claim on driving a framework,
+// represented by an ephemeral znode at <zkChroot>/<frameworkName>_lock.
+// Unlike an Election, a scheduler that finds the lock held gives up rather
+// than queueing for it.
+type FrameworkLock struct {
+	conn zkConn
+	path string
+}
+
+// LockFramework takes the framework lock in the name of id, failing with
+// ErrFrameworkLocked if another scheduler holds it.  The lock is released
+// with Release or once our ZK session expires, so a scheduler that crashed
+// holds it for up to RPC_TIMEOUT.
+func LockFramework(
+	zkServers []string,
+	zkChroot string,
+	frameworkName string,
+	id string,
+) (*FrameworkLock, error) {
+	c, err := connectZK(zkServers)
+	if err != nil {
+		return nil, err
+	}
+	l := &FrameworkLock{
+		conn: c,
+		path: zkPath(zkChroot, frameworkName, "_lock"),
+	}
+	if err = createPath(c, path.Dir(l.path)); err != nil {
+		c.Close()
+		return nil, err
+	}
+	_, err = c.Create(l.path, []byte(id), zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+	if err == zk.ErrNodeExists {
+		holder, _, getErr := c.Get(l.path)
+		c.Close()
+		if getErr != nil {
+			return nil, ErrFrameworkLocked
+		}
+		return nil, fmt.Errorf("%w: held by %s", ErrFrameworkLocked, holder)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	log.Infof("Took the framework lock %s", l.path)
+	return l, nil
+}
+
+// Wait blocks for as long as we hold the lock, returning
+// ErrFrameworkLockLost once its znode is gone.
+func (l *FrameworkLock) Wait() error {
+	for {
+		exists, _, events, err := l.conn.ExistsW(l.path)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrFrameworkLockLost
+		}
+		<-events
+	}
+}
+
+// Release gives up the lock, allowing another scheduler to take it.
+func (l *FrameworkLock) Release() {
+	if err := l.conn.Delete(l.path, -1); err != nil && err != zk.ErrNoNode {
+		log.Warningf("Failed to delete framework lock znode %s: %s", l.path, err)
+	}
+	l.conn.Close()
+}
@@ -0,0 +1,55 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecondSchedulerFindsFrameworkLocked(t *testing.T) {
+	fake := newFakeZK()
+	defer fake.install()()
+	servers := []string{"localhost:2181"}
+
+	held, err := LockFramework(servers, "/etcd", "etcd", "scheduler-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LockFramework(servers, "/etcd", "etcd", "scheduler-b")
+	assert.True(t, errors.Is(err, ErrFrameworkLocked), "unexpected error %v", err)
+	assert.True(t, strings.Contains(err.Error(), "scheduler-a"),
+		"the error should name the holder: %v", err)
+	assert.Equal(t, "scheduler-a", string(fake.nodes[held.path]))
+
+	lost := make(chan error)
+	go func() { lost <- held.Wait() }()
+	held.Release()
+	assert.Equal(t, ErrFrameworkLockLost, <-lost)
+
+	taken, err := LockFramework(servers, "/etcd", "etcd", "scheduler-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "scheduler-b", string(fake.nodes[taken.path]))
+}
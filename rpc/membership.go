@@ -34,50 +34,91 @@ import (
 	log "github.com/golang/glog"
 )
 
+// ConfigureInstance adds newInstance to the cluster configuration.  When the
+// cluster serves the etcd v3 API the instance is added as a non-voting
+// learner, and the returned member ID must be passed to PromoteLearner once
+// the instance has started.  Otherwise it is added directly as a voting
+// member via the v2 API and the returned ID is empty.
 func ConfigureInstance(
 	running map[string]*config.Node,
 	newInstance *config.Node,
-) error {
+) (learnerID string, err error) {
 	if len(running) == 0 {
 		log.Info("No running members to configure.  Skipping configuration.")
-		return nil
+		return "", nil
 	}
-	err := HealthCheck(running)
+	err = HealthCheck(running)
 	if err != nil {
 		log.Errorf("!!!! cluster failed health check: %+v", err)
-		return err
+		return "", err
+	}
+
+	// Each add may trigger an election, so let the one caused by a
+	// previous add settle before starting another.
+	window := time.Duration(newInstance.LeaderStableSeconds) * time.Second
+	if err = waitForStableLeader(running, window); err != nil {
+		log.Errorf("Cluster leader is not stable, not adding %s: %v", newInstance.Name, err)
+		return "", err
+	}
+
+	// Membership changes made through a follower are forwarded to the
+	// leader, which can fail under load, so go to the leader first.
+	leader := leaderName(running)
+	learnerID, err = addLearner(running, newInstance, leader)
+	if err == nil {
+		return learnerID, nil
+	} else if err != errV3Unsupported {
+		log.Errorf("Failed to add new instance as a learner: %v", err)
+		return "", err
 	}
+	log.Info("etcd v3 API unavailable, falling back to v2 member add.")
+	return "", addMember(running, newInstance, leader)
+}
 
+// addMember adds newInstance to the cluster as a voting member through the
+// v2 members API, asking the named leader first and falling back to the
+// other members when it fails.
+func addMember(
+	running map[string]*config.Node,
+	newInstance *config.Node,
+	leader string,
+) error {
+	var lastErr error
 	backoff := 1
+	budget := newBudget()
 	log.Infof("trying to reconfigure cluster for newInstance %+v", newInstance)
 	for retries := 0; retries < RPC_RETRIES; retries++ {
-		for _, args := range running {
-			url := fmt.Sprintf(
-				"http://%s:%d/v2/members",
-				args.Host,
-				args.ClientPort)
+		for _, args := range probeOrder(running, leader) {
+			url := clientURL(args) + "/v2/members"
 			data := fmt.Sprintf(
-				`{"peerURLs": ["http://%s:%d"]}`,
-				newInstance.Host,
-				newInstance.RPCPort)
+				`{"peerURLs": [%q]}`,
+				newInstance.PeerURL())
 
 			req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(data)))
+			if err != nil {
+				return err
+			}
 			req.Header.Set("Content-Type", "application/json")
 
-			client := &http.Client{
-				Timeout: RPC_TIMEOUT,
-			}
+			client := membershipClient(RPC_TIMEOUT)
 			resp, err := client.Do(req)
 			if err != nil {
-				log.Error(err)
-				return err
+				log.Errorf("Could not add %s via %s: %v", newInstance.Name, args.Name, err)
+				lastErr = err
+				continue
 			}
 			defer resp.Body.Close()
 
 			body, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
-				log.Errorf("Problem configuring instance: %v", err)
-				return err
+				log.Errorf("Problem configuring instance via %s: %v", args.Name, err)
+				lastErr = err
+				continue
+			}
+			if err = responseError(resp, body); err != nil {
+				log.Errorf("Could not add %s via %s: %v", newInstance.Name, args.Name, err)
+				lastErr = err
+				continue
 			}
 			var memberList config.ClusterMemberList
 			err = json.Unmarshal(body, &memberList)
@@ -86,17 +127,22 @@ func ConfigureInstance(
 				log.Errorf("Failed to unmarshal json: %v", err)
 				return err
 			}
-			log.Infof("Successfully configured new node: %+v", memberList)
+			log.V(2).Infof("Member add response: %s", string(body))
+			log.Infof("Successfully configured new node %s via %s.",
+				newInstance.Name, args.Name)
 			return nil
 
 			// TODO(tyler) invariant: member list should now contain node
 		}
+		if !budget.allows(time.Duration(backoff) * time.Second) {
+			break
+		}
 		log.Warningf("Failed to configure cluster for new instance.  "+
 			"Backing off for %d seconds and retrying.", backoff)
-		time.Sleep(time.Duration(backoff) * time.Second)
+		clk.Sleep(time.Duration(backoff) * time.Second)
 		backoff = int(math.Min(float64(backoff<<1), 8))
 	}
-	return errors.New("Failed to configure cluster: no nodes reachable.")
+	return wrapErr(ErrConfigureFailed, wrapErr(ErrNoReachableMembers, lastErr))
 }
 
 func FixInstancePeers(
@@ -115,7 +161,7 @@ func FixInstancePeers(
 			log.Warningf("Failed to configure cluster for new instance.  "+
 				"Backing off for %d seconds and retrying.", backoff)
 		}
-		time.Sleep(time.Duration(backoff) * time.Second)
+		clk.Sleep(time.Duration(backoff) * time.Second)
 		backoff = int(math.Max(math.Min(float64(backoff<<1), 8), 1))
 
 		outerErr = HealthCheck(running)
@@ -134,27 +180,19 @@ func FixInstancePeers(
 		ident, present := members[node.Name]
 		if !present {
 			log.Errorf("Failed to get ident for node %s!", node.Name)
-			outerErr = errors.New("Failed to get cluster identifier for node.")
+			outerErr = ErrMemberNotFound
 			continue
 		}
 
-		url := fmt.Sprintf(
-			"http://%s:%d/v2/members/%s",
-			node.Host,
-			node.ClientPort,
-			ident)
+		url := clientURL(node) + "/v2/members/" + ident
 		data := fmt.Sprintf(
-			`{"peerURLs": ["http://%s:%d"]}`,
-			node.Host,
-			node.RPCPort)
+			`{"peerURLs": [%q]}`,
+			node.PeerURL())
 
 		req, err := http.NewRequest("PUT", url, bytes.NewBuffer([]byte(data)))
 		req.Header.Set("Content-Type", "application/json")
 
-		client := &http.Client{
-			Timeout: RPC_TIMEOUT,
-		}
-
+		client := membershipClient(RPC_TIMEOUT)
 		resp, err := client.Do(req)
 		if err != nil {
 			log.Error(err)
@@ -168,8 +206,9 @@ func FixInstancePeers(
 			return nil
 		}
 		log.Errorf("go unexpected response while fixing peer url: %s", resp.Status)
+		outerErr = fmt.Errorf("unexpected response %s", resp.Status)
 	}
-	return errors.New("Failed to configure cluster: no nodes reachable.")
+	return wrapErr(ErrConfigureFailed, outerErr)
 }
 
 func MemberList(
@@ -182,20 +221,18 @@ func MemberList(
 		return
 	}
 
+	var lastErr error
 	backoff := 1
+	budget := newBudget()
 	for retries := 0; retries < RPC_RETRIES; retries++ {
-		for _, args := range running {
-			url := fmt.Sprintf(
-				"http://%s:%d/v2/members",
-				args.Host,
-				args.ClientPort)
-
-			client := &http.Client{
-				Timeout: RPC_TIMEOUT,
-			}
+		for _, args := range probeOrder(running, "") {
+			url := clientURL(args) + "/v2/members"
+
+			client := etcdClient(RPC_TIMEOUT)
 			resp, err := client.Get(url)
 			if err != nil {
 				log.Errorf("Could not query %s for member list: %+v", args.Host, err)
+				lastErr = err
 				continue
 			}
 			defer resp.Body.Close()
@@ -203,17 +240,26 @@ func MemberList(
 			body, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
 				log.Errorf("could not query %s for member list", args.Host)
+				lastErr = err
 				continue
 			}
 			log.V(2).Info("MemberList response:", string(body))
+			if err = responseError(resp, body); err != nil {
+				log.Errorf("Could not query %s for member list: %v", args.Host, err)
+				lastErr = err
+				continue
+			}
 			var memberList config.ClusterMemberList
 			err = json.Unmarshal(body, &memberList)
 			if err != nil {
+				log.Errorf("Received unexpected member list from %s: %s",
+					args.Host, string(body))
 				log.Error(err)
+				lastErr = err
 				continue
 			}
 			if len(memberList.Members) == 0 {
-				err = errors.New("Remote node returned an empty etcd member list.")
+				lastErr = ErrEmptyMemberList
 				continue
 			}
 
@@ -222,15 +268,114 @@ func MemberList(
 			}
 			return nameToIdent, nil
 		}
+		if !budget.allows(time.Duration(backoff) * time.Second) {
+			break
+		}
 		log.Warningf("Failed to retrieve list of configured members.  "+
 			"Backing off for %d seconds and retrying.", backoff)
-		time.Sleep(time.Duration(backoff) * time.Second)
+		clk.Sleep(time.Duration(backoff) * time.Second)
 		backoff = int(math.Min(float64(backoff<<1), 8))
 	}
-	return nameToIdent, err
+	if _, isEtcdErr := lastErr.(*EtcdError); isEtcdErr || lastErr == ErrEmptyMemberList {
+		return nameToIdent, lastErr
+	}
+	return nameToIdent, wrapErr(ErrNoReachableMembers, lastErr)
 }
 
-func RemoveInstance(running map[string]*config.Node, task string) error {
+// memberState probes a member's self stats, returning its raft state
+// (e.g. "StateLeader" or "StateFollower").
+func memberState(node *config.Node) (string, error) {
+	url := clientURL(node) + "/v2/stats/self"
+	client := etcdClient(RPC_PROBE_TIMEOUT)
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var self struct {
+		State string `json:"state"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&self)
+	return self.State, err
+}
+
+// removalTargets returns the members other than task that answer a quick
+// probe, with the leader first as it applies membership changes without
+// forwarding them.  If no member answers the probe, every other member is
+// returned so that removal is still attempted.
+func removalTargets(running map[string]*config.Node, task string) []*config.Node {
+	var (
+		healthy = []*config.Node{}
+		all     = []*config.Node{}
+		others  = map[string]*config.Node{}
+	)
+	for id, args := range running {
+		if id != task {
+			others[id] = args
+		}
+	}
+	for _, args := range probeOrder(others, leaderName(others)) {
+		all = append(all, args)
+		if _, err := memberState(args); err != nil {
+			log.Warningf("Skipping unresponsive member %s for removal of %s: %v",
+				args.Name, task, err)
+			continue
+		}
+		healthy = append(healthy, args)
+	}
+	if len(healthy) == 0 {
+		return all
+	}
+	return healthy
+}
+
+// ErrRemovalBreaksQuorum is returned by RemoveInstance when too few of the
+// remaining members are healthy to form a quorum once the member is gone.
+var ErrRemovalBreaksQuorum = errors.New("removing member would leave " +
+	"too few healthy members for quorum")
+
+// checkRemovalQuorum returns ErrRemovalBreaksQuorum unless a majority of
+// the members that would remain after removing task answer a probe.
+// Configured members we are not running are counted as unhealthy.
+func checkRemovalQuorum(
+	running map[string]*config.Node,
+	members map[string]string,
+	task string,
+) error {
+	remaining, healthy := 0, 0
+	for name := range members {
+		if name == task {
+			continue
+		}
+		remaining++
+		node, present := running[name]
+		if !present {
+			continue
+		}
+		if _, err := memberState(node); err == nil {
+			healthy++
+		}
+	}
+	quorum := remaining/2 + 1
+	if healthy < quorum {
+		log.Errorf("Refusing to remove %s: only %d of the remaining %d "+
+			"members are healthy, and %d are needed for quorum.",
+			task, healthy, remaining, quorum)
+		return ErrRemovalBreaksQuorum
+	}
+	return nil
+}
+
+// RemoveInstance deconfigures task from the etcd cluster, making up to
+// retries passes over the responsive members.  Unless force is set, it
+// first ensures that the remaining members can still form a quorum.
+func RemoveInstance(
+	running map[string]*config.Node,
+	task string,
+	retries int,
+	force bool,
+) error {
 	log.Infof("Attempting to remove task %s from "+
 		"the etcd cluster configuration.", task)
 	members, err := MemberList(running)
@@ -240,22 +385,25 @@ func RemoveInstance(running map[string]*config.Node, task string) error {
 
 	if len(running) == 0 {
 		log.Infoln("Skipping RemoveInstance - no running instances.")
-		return errors.New("No running instances to deconfigure!")
+		return ErrNoRunningMembers
 	}
 
-	ident := members[task]
+	if force {
+		log.Warningf("Forcing removal of %s without checking quorum.", task)
+	} else if err := checkRemovalQuorum(running, members, task); err != nil {
+		return err
+	}
+
+	ident, present := members[task]
+	if !present {
+		return fmt.Errorf("%w: %s", ErrMemberNotFound, task)
+	}
 	backoff := 1
+	budget := newBudget()
 	var outerErr error
-	for retries := 0; retries < RPC_RETRIES; retries++ {
-		for id, args := range running {
-			if id == task {
-				continue
-			}
-			url := fmt.Sprintf(
-				"http://%s:%d/v2/members/%s",
-				args.Host,
-				args.ClientPort,
-				ident)
+	for retry := 0; retry < retries; retry++ {
+		for _, args := range removalTargets(running, task) {
+			url := clientURL(args) + "/v2/members/" + ident
 
 			req, err := http.NewRequest("DELETE", url, nil)
 			if err != nil {
@@ -264,9 +412,7 @@ func RemoveInstance(running map[string]*config.Node, task string) error {
 				continue
 			}
 
-			client := &http.Client{
-				Timeout: RPC_TIMEOUT,
-			}
+			client := membershipClient(RPC_TIMEOUT)
 			resp, err := client.Do(req)
 			if err != nil {
 				outerErr = err
@@ -275,18 +421,23 @@ func RemoveInstance(running map[string]*config.Node, task string) error {
 			}
 			defer resp.Body.Close()
 
+			if resp.StatusCode == http.StatusNoContent {
+				log.Info("Successfully removed member from cluster configuration.")
+				return nil
+			}
+
 			body, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
 				outerErr = err
 				log.Errorf("Problem removing instance for this attempt: %v", err)
 				continue
 			}
-			log.Info("RemoveInstance response: ", string(body))
+			log.V(2).Info("RemoveInstance response: ", string(body))
 			if strings.HasPrefix(string(body), "Method Not Allowed") {
 				err = errors.New("Received error response while trying to remove " +
 					"node from cluster configuration.")
 				outerErr = err
-				log.Error(err)
+				log.Errorf("%v: %s", err, string(body))
 				continue
 			}
 			var removeResponse struct {
@@ -308,10 +459,13 @@ func RemoveInstance(running map[string]*config.Node, task string) error {
 				return nil
 			}
 		}
+		if !budget.allows(time.Duration(backoff) * time.Second) {
+			break
+		}
 		log.Warningf("Failed to retrieve list of configured members.  "+
 			"Backing off for %d seconds and retrying.", backoff)
-		time.Sleep(time.Duration(backoff) * time.Second)
+		clk.Sleep(time.Duration(backoff) * time.Second)
 		backoff = int(math.Min(float64(backoff<<1), 8))
 	}
-	return outerErr
+	return wrapErr(ErrConfigureFailed, outerErr)
 }
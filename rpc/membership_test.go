@@ -19,6 +19,12 @@
 package rpc
 
 import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
 	gotesting "testing"
 
@@ -32,6 +38,102 @@ import (
 func TestConfigureInstance(t *gotesting.T) {
 }
 
+func TestAddMemberTargetsLeaderFirst(t *gotesting.T) {
+	defer useSkipClock()()
+	var (
+		adds         []string
+		leaderFailed bool
+	)
+	newServer := func(name string, leader bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/v2/stats/leader" && leader:
+				fmt.Fprint(w, `{"leader":"1","followers":{}}`)
+			case r.URL.Path == "/v2/stats/leader":
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"message":"not current leader"}`)
+			case r.URL.Path == "/v2/members" && r.Method == "POST":
+				adds = append(adds, name)
+				if leader && leaderFailed {
+					w.WriteHeader(http.StatusInternalServerError)
+					fmt.Fprint(w, `{"errorCode":300,"message":"Raft Internal Error"}`)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"id":"4","peerURLs":["http://10.0.0.4:2380"]}`)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	}
+	running := map[string]*config.Node{}
+	for _, name := range []string{"etcd-1", "etcd-2", "etcd-3"} {
+		server := newServer(name, name == "etcd-2")
+		defer server.Close()
+		running[name] = newTestNode(t, name, server)
+	}
+	newInstance := &config.Node{Name: "etcd-4", Host: "10.0.0.4", RPCPort: 2380}
+
+	for i := 0; i < 3; i++ {
+		adds = nil
+		assert.NoError(t, addMember(running, newInstance, leaderName(running)))
+		assert.Equal(t, []string{"etcd-2"}, adds, "only the leader should be asked")
+	}
+
+	leaderFailed = true
+	adds = nil
+	assert.NoError(t, addMember(running, newInstance, leaderName(running)))
+	if assert.Len(t, adds, 2) {
+		assert.Equal(t, "etcd-2", adds[0], "the leader should be asked first")
+		assert.NotEqual(t, "etcd-2", adds[1], "a follower should be asked next")
+	}
+}
+
+// captureLog returns what f logs at the default verbosity.
+func captureLog(t *gotesting.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	flag.Set("logtostderr", "true")
+	defer func() {
+		flag.Set("logtostderr", "false")
+		os.Stderr = stderr
+	}()
+	f()
+	w.Close()
+	logged, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(logged)
+}
+
+func TestMemberListLogsBodyOnlyOnError(t *gotesting.T) {
+	defer useSkipClock()()
+	body := `{"members": [{"id": "1", "name": "etcd-distinctive"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	running := map[string]*config.Node{"etcd-1": newTestNode(t, "etcd-1", server)}
+
+	logged := captureLog(t, func() {
+		_, err := MemberList(running)
+		assert.NoError(t, err)
+	})
+	assert.NotContains(t, logged, "etcd-distinctive")
+
+	body = `not a member list from etcd-distinctive`
+	logged = captureLog(t, func() {
+		_, err := MemberList(running)
+		assert.Error(t, err)
+	})
+	assert.Contains(t, logged, body)
+}
+
 func TestMemberList(t *gotesting.T) {
 	memberList := config.ClusterMemberList{
 		Members: []httptypes.Member{
@@ -103,4 +205,79 @@ func TestMemberList(t *gotesting.T) {
 }
 
 func TestRemoveInstance(t *gotesting.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadNode := newTestNode(t, "etcd-1", dead)
+	dead.Close()
+
+	var deleted []string
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/members":
+			w.Write([]byte(`{"members":[` +
+				`{"id":"1","name":"etcd-1"},` +
+				`{"id":"2","name":"etcd-2"},` +
+				`{"id":"3","name":"etcd-3"},` +
+				`{"id":"4","name":"etcd-4"}]}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/stats/self":
+			w.Write([]byte(`{"name":"etcd-2","state":"StateLeader"}`))
+		case r.Method == "DELETE":
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer healthy.Close()
+
+	running := map[string]*config.Node{
+		"etcd-1": deadNode,
+		"etcd-2": newTestNode(t, "etcd-2", healthy),
+		"etcd-3": newTestNode(t, "etcd-3", healthy),
+		"etcd-4": newTestNode(t, "etcd-4", healthy),
+	}
+
+	err := RemoveInstance(running, "etcd-3", 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/v2/members/3"}, deleted,
+		"removal should skip the unreachable member and succeed on the healthy one")
+}
+
+func TestRemoveInstanceRefusedWithoutQuorum(t *gotesting.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadNode := newTestNode(t, "etcd-1", dead)
+	dead.Close()
+
+	var deleted []string
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/members":
+			w.Write([]byte(`{"members":[` +
+				`{"id":"1","name":"etcd-1"},` +
+				`{"id":"2","name":"etcd-2"},` +
+				`{"id":"3","name":"etcd-3"}]}`))
+		case r.Method == "GET" && r.URL.Path == "/v2/stats/self":
+			w.Write([]byte(`{"name":"etcd-2","state":"StateLeader"}`))
+		case r.Method == "DELETE":
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer healthy.Close()
+
+	// Removing etcd-3 leaves etcd-1, which is dead, and etcd-2: one
+	// healthy member of two is not a quorum.
+	running := map[string]*config.Node{
+		"etcd-1": deadNode,
+		"etcd-2": newTestNode(t, "etcd-2", healthy),
+	}
+
+	err := RemoveInstance(running, "etcd-3", 1, false)
+	assert.Equal(t, ErrRemovalBreaksQuorum, err)
+	assert.Empty(t, deleted)
+
+	err = RemoveInstance(running, "etcd-3", 1, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/v2/members/3"}, deleted)
 }
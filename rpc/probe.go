@@ -0,0 +1,63 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+// probeStart rotates the member that first-success loops start from, so
+// that a persistently slow member is not always probed first, and so that
+// load is spread across the cluster.
+var probeStart uint32
+
+// probeOrder returns the members of running in the order they should be
+// tried: preferred first if it is running, followed by the others in name
+// order, rotated by one position on every call.
+func probeOrder(running map[string]*config.Node, preferred string) []*config.Node {
+	var (
+		first  *config.Node
+		others = byName{}
+	)
+	for _, node := range running {
+		if node == nil {
+			continue
+		}
+		if preferred != "" && node.Name == preferred && first == nil {
+			first = node
+			continue
+		}
+		others = append(others, node)
+	}
+	sort.Sort(others)
+
+	ordered := make([]*config.Node, 0, len(others)+1)
+	if first != nil {
+		ordered = append(ordered, first)
+	}
+	if len(others) > 0 {
+		start := int(atomic.AddUint32(&probeStart, 1) % uint32(len(others)))
+		ordered = append(ordered, others[start:]...)
+		ordered = append(ordered, others[:start]...)
+	}
+	return ordered
+}
@@ -0,0 +1,56 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+func TestProbeOrder(t *testing.T) {
+	running := map[string]*config.Node{
+		"etcd-1": {Name: "etcd-1"},
+		"etcd-2": {Name: "etcd-2"},
+		"etcd-3": {Name: "etcd-3"},
+	}
+	names := func(nodes []*config.Node) []string {
+		out := []string{}
+		for _, n := range nodes {
+			out = append(out, n.Name)
+		}
+		return out
+	}
+
+	firsts := map[string]struct{}{}
+	for i := 0; i < len(running); i++ {
+		order := names(probeOrder(running, ""))
+		assert.Equal(t, 3, len(order))
+		firsts[order[0]] = struct{}{}
+	}
+	assert.Equal(t, 3, len(firsts), "Each member should be probed first in turn.")
+
+	for i := 0; i < len(running); i++ {
+		order := names(probeOrder(running, "etcd-2"))
+		assert.Equal(t, "etcd-2", order[0], "The preferred member should be probed first.")
+		assert.Equal(t, 3, len(order))
+	}
+}
@@ -26,16 +26,16 @@ import (
 
 	"github.com/mesosphere/etcd-mesos/config"
 
-	"github.com/coreos/go-etcd/etcd"
 	log "github.com/golang/glog"
 )
 
-type nodeIndex struct {
+// NodeIndex is a reseed candidate along with its last known Raft index.
+type NodeIndex struct {
 	RaftIndex uint64
 	Node      string
 }
 
-type nodeIndices []nodeIndex
+type nodeIndices []NodeIndex
 
 func (n nodeIndices) Len() int {
 	return len(n)
@@ -52,17 +52,13 @@ func (n nodeIndices) Swap(i, j int) {
 	n[i], n[j] = n[j], n[i]
 }
 
-func RankReseedCandidates(running map[string]*config.Node) []nodeIndex {
+func RankReseedCandidates(running map[string]*config.Node) []NodeIndex {
 	nodeIndices := nodeIndices{}
 
 	for id, args := range running {
-		url := fmt.Sprintf(
-			"http://%s:%d",
-			args.Host,
-			args.ClientPort,
-		)
+		url := clientURL(args)
 		// This has a 1s dial timeout, which is good for us here
-		client := etcd.NewClient([]string{url})
+		client := newV2Client(url)
 		if ok := client.SyncCluster(); !ok {
 			log.Error("Could not establish connection "+
 				"with cluster using endpoints %+v", url)
@@ -74,8 +70,9 @@ func RankReseedCandidates(running map[string]*config.Node) []nodeIndex {
 			log.Errorf("Could not query cluster: %s", err)
 			continue
 		}
+		noteContact(contactHost(args))
 
-		nodeIndices = append(nodeIndices, nodeIndex{
+		nodeIndices = append(nodeIndices, NodeIndex{
 			RaftIndex: resp.RaftIndex,
 			Node:      id,
 		})
@@ -85,11 +82,7 @@ func RankReseedCandidates(running map[string]*config.Node) []nodeIndex {
 }
 
 func TriggerReseed(node *config.Node) error {
-	url := fmt.Sprintf(
-		"http://%s:%d",
-		node.Host,
-		node.ReseedPort,
-	)
+	url := node.ReseedURL()
 	client := http.Client{
 		Timeout: RPC_TIMEOUT,
 	}
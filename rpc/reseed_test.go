@@ -36,6 +36,6 @@ func TestRaftSort(t *testing.T) {
 	assert.Equal(
 		t,
 		ni[0],
-		nodeIndex{3, "recent"}, "should pick the longest raft index first",
+		NodeIndex{3, "recent"}, "should pick the longest raft index first",
 	)
 }
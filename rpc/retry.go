@@ -0,0 +1,57 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// retryBudget bounds the total time a retry loop may run, in addition to
+// its number of retries.  Zero leaves loops bounded by retries alone.
+var retryBudget time.Duration
+
+// SetRetryBudget bounds the wall-clock time of each retry loop, so that
+// backoff can't stall callers for the sum of every backoff when members
+// are unreachable.  It is meant to be called once during startup.
+func SetRetryBudget(budget time.Duration) {
+	retryBudget = budget
+}
+
+// budget tracks the time spent by a single retry loop against retryBudget.
+type budget struct {
+	start time.Time
+	limit time.Duration
+}
+
+func newBudget() budget {
+	return budget{start: clk.Now(), limit: retryBudget}
+}
+
+// allows returns whether backing off for backoff leaves the next attempt
+// within the budget, logging that the loop is giving up if not.
+func (b budget) allows(backoff time.Duration) bool {
+	if b.limit <= 0 || clk.Since(b.start)+backoff < b.limit {
+		return true
+	}
+	log.Warningf("Retry budget of %s exhausted after %s, giving up.",
+		b.limit, clk.Since(b.start))
+	return false
+}
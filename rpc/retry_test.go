@@ -0,0 +1,55 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+func TestRetryBudgetCutsBackoffShort(t *testing.T) {
+	defer useSkipClock()()
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	running := map[string]*config.Node{"etcd-1": newTestNode(t, "etcd-1", server)}
+
+	_, err := MemberList(running)
+	assert.Error(t, err)
+	assert.Equal(t, int32(RPC_RETRIES), atomic.SwapInt32(&attempts, 0))
+
+	// The first backoff of a second fits, but the second of two doesn't.
+	SetRetryBudget(3 * time.Second)
+	defer SetRetryBudget(0)
+	start := clk.Now()
+	_, err = MemberList(running)
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.True(t, clk.Since(start) < 3*time.Second)
+}
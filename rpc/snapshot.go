@@ -0,0 +1,107 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	log "github.com/golang/glog"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+// OpenSnapshot asks the first running member that will serve one for a
+// snapshot of its backend, returning the member's name and a reader of
+// the snapshot file.  The snapshot is streamed as the reader is consumed,
+// so it is never held in memory, and the reader must be closed.
+func OpenSnapshot(running map[string]*config.Node) (string, io.ReadCloser, error) {
+	if len(running) == 0 {
+		return "", nil, ErrNoRunningMembers
+	}
+	// Snapshots of large backends take far longer than RPC_TIMEOUT.
+	client := etcdClient(0)
+	var err error
+	for _, args := range probeOrder(running, "") {
+		url := clientURL(args) + "/v3/maintenance/snapshot"
+		var resp *http.Response
+		resp, err = client.Post(url, "application/json", strings.NewReader("{}"))
+		if err != nil {
+			log.Errorf("Could not request a snapshot from %s: %v", args.Name, err)
+			continue
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			err = errV3Unsupported
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			err = fmt.Errorf("%s returned %s: %s", url, resp.Status, string(body))
+			log.Errorf("Could not snapshot %s: %v", args.Name, err)
+			continue
+		}
+		log.Infof("Streaming a snapshot of member %s", args.Name)
+		return args.Name, &snapshotReader{
+			body: resp.Body,
+			dec:  json.NewDecoder(resp.Body),
+		}, nil
+	}
+	return "", nil, wrapErr(ErrNoReachableMembers, err)
+}
+
+// snapshotReader decodes the stream of snapshot chunks the etcd v3 gateway
+// sends, each a JSON message carrying part of the file.
+type snapshotReader struct {
+	body  io.ReadCloser
+	dec   *json.Decoder
+	chunk []byte
+}
+
+func (r *snapshotReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		var msg struct {
+			Result struct {
+				Blob []byte `json:"blob"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := r.dec.Decode(&msg); err != nil {
+			return 0, err
+		}
+		if msg.Error != nil {
+			return 0, fmt.Errorf("snapshot failed: %s", msg.Error.Message)
+		}
+		r.chunk = msg.Result.Blob
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *snapshotReader) Close() error {
+	return r.body.Close()
+}
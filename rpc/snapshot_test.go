@@ -0,0 +1,64 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	gotesting "testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+func TestOpenSnapshotStreamsChunks(t *gotesting.T) {
+	chunks := [][]byte{[]byte("bolt "), []byte("db "), []byte("contents")}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/maintenance/snapshot" {
+			http.NotFound(w, r)
+			return
+		}
+		enc := json.NewEncoder(w)
+		for _, chunk := range chunks {
+			enc.Encode(map[string]interface{}{
+				"result": map[string]interface{}{"blob": chunk},
+			})
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+	broken := httptest.NewServer(http.NotFoundHandler())
+	defer broken.Close()
+
+	name, snapshot, err := OpenSnapshot(map[string]*config.Node{
+		"etcd-1": newTestNode(t, "etcd-1", broken),
+		"etcd-2": newTestNode(t, "etcd-2", server),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Close()
+	assert.Equal(t, "etcd-2", name)
+	payload, err := ioutil.ReadAll(snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, "bolt db contents", string(payload))
+}
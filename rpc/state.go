@@ -108,7 +108,7 @@ func GetState(master string) (*MasterState, error) {
 			log.Error(err)
 		}
 		log.Warningf("Failed to get state: %v", outerErr)
-		time.Sleep(time.Duration(backoff) * time.Second)
+		clk.Sleep(time.Duration(backoff) * time.Second)
 		backoff = int(math.Min(float64(backoff<<1), 8))
 	}
 	return nil, outerErr
@@ -134,8 +134,7 @@ func GetPeersFromState(state *MasterState, frameworkName string) ([]string, erro
 			if err != nil {
 				return []string{}, err
 			}
-			peers = append(peers, fmt.Sprintf("%s=http://%s:%d",
-				node.Name, node.Host, node.RPCPort))
+			peers = append(peers, node.Name+"="+node.PeerURL())
 		}
 	}
 	return peers, nil
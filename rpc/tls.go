@@ -0,0 +1,132 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+// Transports for requests to etcd members, set by SetClientTLS.  Until
+// then both are nil, so the default transport is used over plain http.
+var (
+	clientTransport     *http.Transport
+	membershipTransport *http.Transport
+)
+
+// LoadClientTLS returns a TLS configuration that presents the client
+// certificate in certFile and keyFile, and trusts the CAs in caFile, or the
+// host's roots if caFile is empty.
+func LoadClientTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	return c, nil
+}
+
+// SetClientTLS makes requests to etcd members use https, presenting the
+// client certificate of c.  If membership is not nil its certificate is
+// presented instead when adding, updating or removing members, so that
+// those requests may be authorized as a different etcd user.  A nil c
+// restores plain http.
+func SetClientTLS(c, membership *tls.Config) {
+	if c == nil {
+		clientTransport, membershipTransport = nil, nil
+		return
+	}
+	if membership == nil {
+		membership = c
+	}
+	clientTransport = &http.Transport{TLSClientConfig: c}
+	membershipTransport = &http.Transport{TLSClientConfig: membership}
+}
+
+// CertUser returns the common name of the client certificate in c, which
+// etcd running with --client-cert-auth takes as the user of a request.
+func CertUser(c *tls.Config) (string, error) {
+	if c == nil || len(c.Certificates) == 0 ||
+		len(c.Certificates[0].Certificate) == 0 {
+		return "", errors.New("no client certificate configured")
+	}
+	cert, err := x509.ParseCertificate(c.Certificates[0].Certificate[0])
+	if err != nil {
+		return "", err
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// clientURL returns the URL at which the scheduler reaches node's client
+// API.  Unless node names its own scheme this is https once SetClientTLS
+// has been called.
+func clientURL(node *config.Node) string {
+	if node.Scheme != "" || node.ClientScheme != "" || clientTransport == nil {
+		return node.ClientURL()
+	}
+	return config.JoinURL("https", node.Host, node.ClientPort)
+}
+
+// etcdClient returns a client for requests to etcd members.
+func etcdClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: recordingContacts(clientTransport),
+	}
+}
+
+// membershipClient returns a client for requests that change the members
+// of the cluster.
+func membershipClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: recordingContacts(membershipTransport),
+	}
+}
+
+// newV2Client returns a go-etcd client for endpoint.
+func newV2Client(endpoint string) *etcd.Client {
+	c := etcd.NewClient([]string{endpoint})
+	if clientTransport != nil {
+		c.SetTransport(&http.Transport{
+			Dial:            c.DefaultDial,
+			TLSClientConfig: clientTransport.TLSClientConfig,
+		})
+	}
+	return c
+}
@@ -0,0 +1,244 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	golog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+// testCA issues certificates for TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue writes a certificate for commonName and its key as PEM files in
+// dir, returning their paths.
+func (ca *testCA) issue(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, commonName+".crt")
+	keyFile := filepath.Join(dir, commonName+".key")
+	err = ioutil.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestMemberOperationsPresentClientCert(t *testing.T) {
+	defer useSkipClock()()
+	defer SetClientTLS(nil, nil)
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	err := ioutil.WriteFile(caFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mut   sync.Mutex
+		users = map[string][]string{}
+	)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		users[r.Method] = append(users[r.Method], r.TLS.PeerCertificates[0].Subject.CommonName)
+		mut.Unlock()
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/members":
+			w.Write([]byte(`{"members":[` +
+				`{"id":"1","name":"etcd-1"},{"id":"2","name":"etcd-2"}]}`))
+		case r.Method == "DELETE" && r.URL.Path == "/v2/members/2":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	serverCert, serverKey := ca.issue(t, dir, "etcd-server")
+	cert, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}
+	server.Config.ErrorLog = golog.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	running := map[string]*config.Node{
+		"etcd-1": newTestNode(t, "etcd-1", server),
+		"etcd-2": newTestNode(t, "etcd-2", server),
+	}
+
+	// Neither plain http nor https without a client certificate is accepted.
+	_, err = MemberList(running)
+	assert.Error(t, err)
+	SetClientTLS(&tls.Config{RootCAs: ca.pool}, nil)
+	_, err = MemberList(running)
+	assert.Error(t, err)
+	assert.Empty(t, users)
+
+	clientCert, clientKey := ca.issue(t, dir, "etcd-mesos")
+	client, err := LoadClientTLS(clientCert, clientKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminCert, adminKey := ca.issue(t, dir, "etcd-admin")
+	admin, err := LoadClientTLS(adminCert, adminKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := CertUser(admin)
+	assert.NoError(t, err)
+	assert.Equal(t, "etcd-admin", user)
+
+	SetClientTLS(client, admin)
+	members, err := MemberList(running)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"etcd-1": "1", "etcd-2": "2"}, members)
+	assert.NoError(t, RemoveInstance(running, "etcd-2", 1, true))
+
+	// The member removal is made as the membership certificate's user.
+	mut.Lock()
+	defer mut.Unlock()
+	assert.Equal(t, []string{"etcd-admin"}, users["DELETE"])
+	for _, user := range users["GET"] {
+		assert.Equal(t, "etcd-mesos", user)
+	}
+}
+
+func TestNodeSchemeChoosesTransport(t *testing.T) {
+	defer SetClientTLS(nil, nil)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		w.Write([]byte(`{"etcdserver":"` + scheme + `"}`))
+	})
+	tlsServer := httptest.NewTLSServer(handler)
+	tlsServer.Config.ErrorLog = golog.New(ioutil.Discard, "", 0)
+	defer tlsServer.Close()
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+
+	secure := newTestNode(t, "etcd-1", tlsServer)
+	secure.Scheme = "https"
+	plain := newTestNode(t, "etcd-2", plainServer)
+	plain.Scheme = "http"
+	running := map[string]*config.Node{"etcd-1": secure, "etcd-2": plain}
+
+	// A node marked https is contacted over https even without client TLS,
+	// which fails until the test server's certificate is trusted.
+	assert.Equal(t, map[string]string{"etcd-2": "http"}, MemberVersions(running))
+
+	// With client TLS, a node marked http is still reached over http.
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsServer.Certificate())
+	SetClientTLS(&tls.Config{RootCAs: pool}, nil)
+	assert.Equal(t, map[string]string{"etcd-1": "https", "etcd-2": "http"},
+		MemberVersions(running))
+
+	// A node serving only its clients over https is reached over https.
+	secure.Scheme = ""
+	secure.ClientScheme = "https"
+	assert.Equal(t, map[string]string{"etcd-1": "https", "etcd-2": "http"},
+		MemberVersions(running))
+}
@@ -0,0 +1,65 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/golang/glog"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+// MemberVersion queries a member's /version endpoint for the version of
+// etcd it is running.
+func MemberVersion(node *config.Node) (string, error) {
+	client := etcdClient(RPC_PROBE_TIMEOUT)
+	resp, err := client.Get(clientURL(node) + "/version")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s from %s/version",
+			resp.Status, clientURL(node))
+	}
+
+	var version struct {
+		Server string `json:"etcdserver"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&version)
+	return version.Server, err
+}
+
+// MemberVersions returns the etcd version of each running member that
+// answers.  Unreachable members are left out.
+func MemberVersions(running map[string]*config.Node) map[string]string {
+	versions := map[string]string{}
+	for name, node := range running {
+		version, err := MemberVersion(node)
+		if err != nil {
+			log.Errorf("Could not query %s for its version: %v", name, err)
+			continue
+		}
+		versions[name] = version
+	}
+	return versions
+}
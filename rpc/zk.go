@@ -29,32 +29,55 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 	"unsafe"
 
 	"github.com/gogo/protobuf/proto"
 	log "github.com/golang/glog"
 	mesos "github.com/mesos/mesos-go/mesosproto"
 	"github.com/samuel/go-zookeeper/zk"
+
+	"github.com/mesosphere/etcd-mesos/config"
 )
 
+// zkConn is the subset of *zk.Conn used by this package, allowing tests to
+// substitute an in-memory implementation.
+type zkConn interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Delete(path string, version int32) error
+	Children(path string) ([]string, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Close()
+}
+
+// connectZK opens a connection to the given ZK servers.  It is a variable
+// so that tests may replace it.
+var connectZK = func(zkServers []string) (zkConn, error) {
+	c, _, err := zk.Connect(zkServers, RPC_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
 func ParseZKURI(zkURI string) (servers []string, chroot string, err error) {
 	servers = []string{}
 
 	// this is to use the canonical zk://host1:ip,host2/zkChroot format
 	strippedZKConnect := strings.TrimPrefix(zkURI, "zk://")
-	parts := strings.Split(strippedZKConnect, "/")
+	parts := strings.SplitN(strippedZKConnect, "/", 2)
 	if len(parts) == 2 {
-		if parts[1] == "" {
+		chroot = "/" + strings.Trim(parts[1], "/")
+		if chroot == "/" {
 			return nil, "", errors.New("ZK chroot must not be the root path \"/\"!")
 		}
-		chroot = "/" + parts[1]
-		servers = strings.Split(parts[0], ",")
-	} else if len(parts) == 1 {
-		servers = strings.Split(parts[0], ",")
-	} else {
-		return nil, "", errors.New("ZK URI must be of the form " +
-			"zk://$host1:$port1,$host2:$port2/path/to/zk/chroot")
+		if strings.Contains(chroot, "//") {
+			return nil, "", errors.New("ZK chroot must not contain empty path elements")
+		}
 	}
+	servers = strings.Split(parts[0], ",")
 	for _, zk := range servers {
 		if len(strings.Split(zk, ":")) != 2 {
 			return nil, "", errors.New("ZK URI must be of the form " +
@@ -64,29 +87,26 @@ func ParseZKURI(zkURI string) (servers []string, chroot string, err error) {
 	return servers, chroot, nil
 }
 
+// PersistFrameworkID stores the framework ID under zkChroot, creating the
+// chroot and any of its missing parent znodes first.
 func PersistFrameworkID(
 	fwid *mesos.FrameworkID,
 	zkServers []string,
 	zkChroot string,
 	frameworkName string,
 ) error {
-	c, _, err := zk.Connect(zkServers, RPC_TIMEOUT)
+	c, err := connectZK(zkServers)
 	if err != nil {
 		return err
 	}
 	defer c.Close()
-	// attempt to create the path
-	_, err = c.Create(
-		zkChroot,
-		[]byte(""),
-		0,
-		zk.WorldACL(zk.PermAll),
-	)
-	if err != nil && err != zk.ErrNodeExists {
+	// attempt to create the chroot, along with any missing parents
+	err = createPath(c, zkNamespace+zkChroot)
+	if err != nil {
 		return err
 	}
 	// attempt to write framework ID to <path> / <frameworkName>
-	_, err = c.Create(zkChroot+"/"+frameworkName+"_framework_id",
+	_, err = c.Create(FrameworkIDPath(zkChroot, frameworkName),
 		[]byte(fwid.GetValue()),
 		0,
 		zk.WorldACL(zk.PermAll))
@@ -99,6 +119,101 @@ func PersistFrameworkID(
 	return nil
 }
 
+// zkNamespace is prefixed onto zkChroot in every ZK path, as set by
+// SetZKNamespace.
+var zkNamespace string
+
+// ValidateZKNamespace checks that namespace is empty or an absolute ZK
+// path, such as /etcd-mesos/prod, with no trailing slash.
+func ValidateZKNamespace(namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	if !strings.HasPrefix(namespace, "/") {
+		return fmt.Errorf("zk namespace %q must start with /", namespace)
+	}
+	for i, part := range strings.Split(namespace[1:], "/") {
+		switch {
+		case part == "":
+			return fmt.Errorf("zk namespace %q contains an empty path element", namespace)
+		case part == "." || part == "..":
+			return fmt.Errorf("zk namespace %q contains a relative path element", namespace)
+		case i == 0 && part == "zookeeper":
+			return fmt.Errorf("zk namespace %q is reserved by zookeeper", namespace)
+		case strings.IndexFunc(part, unicode.IsSpace) >= 0 ||
+			strings.IndexFunc(part, unicode.IsControl) >= 0:
+			return fmt.Errorf("zk namespace %q contains whitespace or "+
+				"control characters", namespace)
+		}
+	}
+	return nil
+}
+
+// SetZKNamespace prefixes namespace onto the chroot of every ZK path the
+// scheduler uses, so that several environments may share a chroot.
+func SetZKNamespace(namespace string) error {
+	if err := ValidateZKNamespace(namespace); err != nil {
+		return err
+	}
+	zkNamespace = namespace
+	return nil
+}
+
+// zkPath returns the ZK node of frameworkName under zkChroot with the given
+// suffix.
+func zkPath(zkChroot, frameworkName, suffix string) string {
+	return zkNamespace + zkChroot + "/" + frameworkName + suffix
+}
+
+// FrameworkIDPath returns the ZK node under zkChroot where the framework ID
+// of frameworkName is persisted.
+func FrameworkIDPath(zkChroot, frameworkName string) string {
+	return zkPath(zkChroot, frameworkName, "_framework_id")
+}
+
+// RunningSnapshotPath returns the ZK node under zkChroot where the running
+// members of frameworkName are persisted.
+func RunningSnapshotPath(zkChroot, frameworkName string) string {
+	return zkPath(zkChroot, frameworkName, "_running")
+}
+
+// reconciliationPath returns the ZK node under zkChroot where the
+// reconciliation info of frameworkName is persisted.
+func reconciliationPath(zkChroot, frameworkName string) string {
+	return zkPath(zkChroot, frameworkName, "_reconciliation")
+}
+
+// setOrCreate writes data to path, creating the node if it does not exist.
+func setOrCreate(c zkConn, path string, data []byte) error {
+	// try to update an existing node, which may fail if it
+	// does not exist yet.
+	_, err := c.Set(path, data, -1)
+	if err != zk.ErrNoNode {
+		return err
+	}
+
+	// attempt to create the node, as it does not exist
+	_, err = c.Create(path, data, 0, zk.WorldACL(zk.PermAll))
+	return err
+}
+
+// createPath creates the znode at path if it does not already exist, first
+// creating any missing parent znodes in the manner of `mkdir -p`.
+func createPath(c zkConn, path string) error {
+	current := ""
+	for _, part := range strings.Split(path, "/") {
+		if part == "" {
+			continue
+		}
+		current += "/" + part
+		_, err := c.Create(current, []byte(""), 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
 func UpdateReconciliationInfo(
 	reconciliationInfo map[string]string,
 	zkServers []string,
@@ -111,27 +226,14 @@ func UpdateReconciliationInfo(
 	}
 
 	request := func() error {
-		c, _, err := zk.Connect(zkServers, RPC_TIMEOUT)
+		c, err := connectZK(zkServers)
 		if err != nil {
 			return err
 		}
 		defer c.Close()
 
-		// try to update an existing node, which may fail if it
-		// does not exist yet.
-		_, err = c.Set(zkChroot+"/"+frameworkName+"_reconciliation",
-			serializedReconciliationInfo,
-			-1)
-		if err != zk.ErrNoNode {
-			return err
-		}
-
-		// attempt to create the node, as it does not exist
-		_, err = c.Create(zkChroot+"/"+frameworkName+"_reconciliation",
-			serializedReconciliationInfo,
-			0,
-			zk.WorldACL(zk.PermAll),
-		)
+		err = setOrCreate(c, reconciliationPath(zkChroot, frameworkName),
+			serializedReconciliationInfo)
 		if err != nil {
 			return err
 		}
@@ -152,24 +254,70 @@ func UpdateReconciliationInfo(
 		}
 		log.Warningf("Failed to configure cluster for new instance: %+v.  "+
 			"Backing off for %d seconds and retrying.", outerErr, backoff)
-		time.Sleep(time.Duration(backoff) * time.Second)
+		clk.Sleep(time.Duration(backoff) * time.Second)
 		backoff = int(math.Min(float64(backoff<<1), 8))
 	}
 	return outerErr
 }
 
+// UpdateRunningSnapshot persists the configuration of the running members,
+// so that a restarted scheduler knows about them before reconciliation
+// completes.  Unlike the reconciliation info it is only a hint, so it is
+// not retried.
+func UpdateRunningSnapshot(
+	running map[string]*config.Node,
+	zkServers []string,
+	zkChroot string,
+	frameworkName string,
+) error {
+	serializedRunning, err := json.Marshal(running)
+	if err != nil {
+		return err
+	}
+	c, err := connectZK(zkServers)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return setOrCreate(c, RunningSnapshotPath(zkChroot, frameworkName), serializedRunning)
+}
+
+// GetRunningSnapshot returns the running members persisted by
+// UpdateRunningSnapshot, or none if there is no snapshot.
+func GetRunningSnapshot(
+	zkServers []string,
+	zkChroot string,
+	frameworkName string,
+) (map[string]*config.Node, error) {
+	c, err := connectZK(zkServers)
+	if err != nil {
+		return map[string]*config.Node{}, err
+	}
+	defer c.Close()
+	rawData, _, err := c.Get(RunningSnapshotPath(zkChroot, frameworkName))
+	if err == zk.ErrNoNode {
+		return map[string]*config.Node{}, nil
+	}
+	if err != nil {
+		return map[string]*config.Node{}, err
+	}
+	running := map[string]*config.Node{}
+	err = json.Unmarshal(rawData, &running)
+	return running, err
+}
+
 func GetPreviousFrameworkID(
 	zkServers []string,
 	zkChroot string,
 	frameworkName string,
 ) (fwid string, err error) {
 	request := func() (string, error) {
-		c, _, err := zk.Connect(zkServers, RPC_TIMEOUT)
+		c, err := connectZK(zkServers)
 		if err != nil {
 			return "", err
 		}
 		defer c.Close()
-		rawData, _, err := c.Get(zkChroot + "/" + frameworkName + "_framework_id")
+		rawData, _, err := c.Get(FrameworkIDPath(zkChroot, frameworkName))
 		return string(rawData), err
 	}
 
@@ -179,7 +327,7 @@ func GetPreviousFrameworkID(
 		if err == nil {
 			return fwid, err
 		}
-		time.Sleep(time.Duration(backoff) * time.Second)
+		clk.Sleep(time.Duration(backoff) * time.Second)
 		backoff = int(math.Min(float64(backoff<<1), 8))
 	}
 	return "", err
@@ -191,12 +339,12 @@ func GetPreviousReconciliationInfo(
 	frameworkName string,
 ) (recon map[string]string, err error) {
 	request := func() (map[string]string, error) {
-		c, _, err := zk.Connect(zkServers, RPC_TIMEOUT)
+		c, err := connectZK(zkServers)
 		if err != nil {
 			return map[string]string{}, err
 		}
 		defer c.Close()
-		rawData, _, err := c.Get(zkChroot + "/" + frameworkName + "_reconciliation")
+		rawData, _, err := c.Get(reconciliationPath(zkChroot, frameworkName))
 		if err == zk.ErrNoNode {
 			return map[string]string{}, nil
 		}
@@ -214,7 +362,7 @@ func GetPreviousReconciliationInfo(
 		if err == nil {
 			return recon, err
 		}
-		time.Sleep(time.Duration(backoff) * time.Second)
+		clk.Sleep(time.Duration(backoff) * time.Second)
 		backoff = int(math.Min(float64(backoff<<1), 8))
 	}
 	return recon, err
@@ -225,22 +373,101 @@ func ClearZKState(
 	zkChroot string,
 	frameworkName string,
 ) error {
-	c, _, err := zk.Connect(zkServers, RPC_TIMEOUT)
+	c, err := connectZK(zkServers)
 	if err != nil {
 		return err
 	}
 	defer c.Close()
-	err1 := c.Delete(zkChroot+"/"+frameworkName+"_framework_id", -1)
-	err2 := c.Delete(zkChroot+"/"+frameworkName+"_reconciliation", -1)
+	err1 := c.Delete(FrameworkIDPath(zkChroot, frameworkName), -1)
+	err2 := c.Delete(reconciliationPath(zkChroot, frameworkName), -1)
+	// Older schedulers never wrote a snapshot, so it may well be absent.
+	err3 := c.Delete(RunningSnapshotPath(zkChroot, frameworkName), -1)
 	if err1 != nil {
 		return err1
 	} else if err2 != nil {
 		return err2
+	} else if err3 != nil && err3 != zk.ErrNoNode {
+		return err3
 	} else {
 		return nil
 	}
 }
 
+// CleanStaleZKState removes znodes left behind for frameworkName that a
+// restarted scheduler could not make sense of: reconciliation info or a
+// running snapshot that fails to parse, a running snapshot without any
+// reconciliation info to go with it, and anything under the election
+// directory that is not a candidate.  The framework ID is never touched, so
+// the scheduler still reregisters with its existing tasks.  It returns the
+// paths that were removed.
+func CleanStaleZKState(
+	zkServers []string,
+	zkChroot string,
+	frameworkName string,
+) ([]string, error) {
+	c, err := connectZK(zkServers)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	removed := []string{}
+	remove := func(path string) error {
+		err := c.Delete(path, -1)
+		if err == zk.ErrNoNode {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		removed = append(removed, path)
+		return nil
+	}
+
+	reconPath := reconciliationPath(zkChroot, frameworkName)
+	haveRecon := false
+	rawData, _, err := c.Get(reconPath)
+	switch {
+	case err == zk.ErrNoNode:
+	case err != nil:
+		return removed, err
+	case json.Unmarshal(rawData, &map[string]string{}) != nil:
+		if err := remove(reconPath); err != nil {
+			return removed, err
+		}
+	default:
+		haveRecon = true
+	}
+
+	runningPath := RunningSnapshotPath(zkChroot, frameworkName)
+	rawData, _, err = c.Get(runningPath)
+	switch {
+	case err == zk.ErrNoNode:
+	case err != nil:
+		return removed, err
+	case !haveRecon ||
+		json.Unmarshal(rawData, &map[string]*config.Node{}) != nil:
+		if err := remove(runningPath); err != nil {
+			return removed, err
+		}
+	}
+
+	electionDir := zkPath(zkChroot, frameworkName, "_leader")
+	children, _, err := c.Children(electionDir)
+	if err != nil && err != zk.ErrNoNode {
+		return removed, err
+	}
+	for _, child := range children {
+		if strings.HasPrefix(child, electionPrefix) {
+			continue
+		}
+		if err := remove(electionDir + "/" + child); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
 type decoder func([]byte, interface{}) error
 
 var infoCodecs = map[string]decoder{
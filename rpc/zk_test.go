@@ -21,13 +21,133 @@ package rpc
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"path"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/gogo/protobuf/proto"
 	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mesosphere/etcd-mesos/config"
 )
 
+// fakeZK is an in-memory zkConn that enforces parent existence like a
+// real ZK server does.
+type fakeZK struct {
+	sync.Mutex
+	nodes   map[string][]byte
+	seq     int
+	watches map[string][]chan zk.Event
+}
+
+func newFakeZK() *fakeZK {
+	return &fakeZK{
+		nodes:   map[string][]byte{"/": nil},
+		watches: map[string][]chan zk.Event{},
+	}
+}
+
+// install makes connectZK hand out this fake, returning a func that
+// restores the real implementation.
+func (f *fakeZK) install() func() {
+	real := connectZK
+	connectZK = func([]string) (zkConn, error) {
+		return f, nil
+	}
+	return func() { connectZK = real }
+}
+
+func (f *fakeZK) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+	if flags&zk.FlagSequence != 0 {
+		p = fmt.Sprintf("%s%010d", p, f.seq)
+		f.seq++
+	}
+	if _, ok := f.nodes[p]; ok {
+		return "", zk.ErrNodeExists
+	}
+	if _, ok := f.nodes[path.Dir(p)]; !ok {
+		return "", zk.ErrNoNode
+	}
+	f.nodes[p] = data
+	f.fire(p, zk.EventNodeCreated)
+	return p, nil
+}
+
+// fire notifies and clears the one-shot watches set on p.  The caller
+// must hold the lock.
+func (f *fakeZK) fire(p string, t zk.EventType) {
+	for _, w := range f.watches[p] {
+		w <- zk.Event{Type: t, Path: p}
+	}
+	delete(f.watches, p)
+}
+
+func (f *fakeZK) ExistsW(p string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	f.Lock()
+	defer f.Unlock()
+	w := make(chan zk.Event, 1)
+	f.watches[p] = append(f.watches[p], w)
+	_, ok := f.nodes[p]
+	return ok, &zk.Stat{}, w, nil
+}
+
+func (f *fakeZK) Get(p string) ([]byte, *zk.Stat, error) {
+	f.Lock()
+	defer f.Unlock()
+	data, ok := f.nodes[p]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return data, &zk.Stat{}, nil
+}
+
+func (f *fakeZK) Set(p string, data []byte, version int32) (*zk.Stat, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.nodes[p]; !ok {
+		return nil, zk.ErrNoNode
+	}
+	f.nodes[p] = data
+	return &zk.Stat{}, nil
+}
+
+func (f *fakeZK) Delete(p string, version int32) error {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.nodes[p]; !ok {
+		return zk.ErrNoNode
+	}
+	delete(f.nodes, p)
+	f.fire(p, zk.EventNodeDeleted)
+	return nil
+}
+
+func (f *fakeZK) Children(p string) ([]string, *zk.Stat, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.nodes[p]; !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	children := []string{}
+	for node := range f.nodes {
+		if node != p && path.Dir(node) == p {
+			children = append(children, path.Base(node))
+		}
+	}
+	sort.Strings(children)
+	return children, &zk.Stat{}, nil
+}
+
+func (f *fakeZK) Close() {}
+
 func TestAddressFrom(t *testing.T) {
 	for i, tc := range []struct {
 		info         *mesos.MasterInfo
@@ -152,3 +272,168 @@ func TestMasterInfoFromZKNodes(t *testing.T) {
 		}
 	}
 }
+
+func TestParseZKURI(t *testing.T) {
+	for i, tc := range []struct {
+		uri          string
+		wantsServers []string
+		wantsChroot  string
+		wantsError   bool
+	}{
+		{"zk://a:1,b:2/etcd", []string{"a:1", "b:2"}, "/etcd", false},
+		{"zk://a:1/etcd/nested/path/", []string{"a:1"}, "/etcd/nested/path", false},
+		{"zk://a:1", []string{"a:1"}, "", false},
+		{"zk://a:1/", nil, "", true},
+		{"zk://a:1/etcd//path", nil, "", true},
+		{"zk://a/etcd", nil, "", true},
+	} {
+		servers, chroot, err := ParseZKURI(tc.uri)
+		if (err != nil) != tc.wantsError {
+			t.Errorf("test case %d failed, unexpected error state %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(servers, tc.wantsServers) && !tc.wantsError {
+			t.Errorf("test case %d failed, expected servers %v instead of %v", i, tc.wantsServers, servers)
+		}
+		if chroot != tc.wantsChroot {
+			t.Errorf("test case %d failed, expected chroot %q instead of %q", i, tc.wantsChroot, chroot)
+		}
+	}
+}
+
+func TestPersistFrameworkIDCreatesParents(t *testing.T) {
+	fake := newFakeZK()
+	defer fake.install()()
+
+	err := PersistFrameworkID(
+		&mesos.FrameworkID{Value: proto.String("fw-1")},
+		[]string{"localhost:2181"},
+		"/a/b/c",
+		"etcd",
+	)
+	assert.NoError(t, err)
+	for _, p := range []string{"/a", "/a/b", "/a/b/c"} {
+		_, present := fake.nodes[p]
+		assert.True(t, present, "parent znode %s should have been created", p)
+	}
+	assert.Equal(t, "fw-1", string(fake.nodes["/a/b/c/etcd_framework_id"]))
+
+	// Persisting again finds all parents already present.
+	err = PersistFrameworkID(
+		&mesos.FrameworkID{Value: proto.String("fw-1")},
+		[]string{"localhost:2181"},
+		"/a/b/c",
+		"etcd",
+	)
+	assert.Equal(t, zk.ErrNodeExists, err)
+}
+
+func TestRunningSnapshotRoundTrip(t *testing.T) {
+	fake := newFakeZK()
+	defer fake.install()()
+	fake.nodes["/etcd"] = nil
+	servers := []string{"localhost:2181"}
+
+	running, err := GetRunningSnapshot(servers, "/etcd", "etcd")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*config.Node{}, running)
+
+	want := map[string]*config.Node{
+		"etcd-1": {Name: "etcd-1", Host: "host-1", RPCPort: 1, ClientPort: 2, ReseedPort: 3, Version: "v1"},
+	}
+	for i := 0; i < 2; i++ {
+		// The second write updates the existing node.
+		assert.NoError(t, UpdateRunningSnapshot(want, servers, "/etcd", "etcd"))
+	}
+	running, err = GetRunningSnapshot(servers, "/etcd", "etcd")
+	assert.NoError(t, err)
+	assert.Equal(t, want, running)
+}
+
+func TestCleanStaleZKStateKeepsFrameworkID(t *testing.T) {
+	fake := newFakeZK()
+	defer fake.install()()
+	servers := []string{"localhost:2181"}
+	fake.nodes["/etcd"] = nil
+	fake.nodes[FrameworkIDPath("/etcd", "etcd")] = []byte("fwid")
+	fake.nodes["/etcd/etcd_reconciliation"] = []byte("{\"etcd-1\":")
+	fake.nodes[RunningSnapshotPath("/etcd", "etcd")] = []byte("{}")
+	fake.nodes["/etcd/etcd_leader"] = nil
+	fake.nodes["/etcd/etcd_leader/"+electionPrefix+"0000000001"] = []byte("me")
+	fake.nodes["/etcd/etcd_leader/lock"] = nil
+	// Another framework's state under the same chroot is left alone.
+	fake.nodes["/etcd/other_reconciliation"] = []byte("{")
+
+	removed, err := CleanStaleZKState(servers, "/etcd", "etcd")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/etcd/etcd_reconciliation",
+		"/etcd/etcd_running",
+		"/etcd/etcd_leader/lock",
+	}, removed)
+
+	fwid, err := GetPreviousFrameworkID(servers, "/etcd", "etcd")
+	assert.NoError(t, err)
+	assert.Equal(t, "fwid", fwid)
+	for _, p := range []string{
+		"/etcd/etcd_leader/" + electionPrefix + "0000000001",
+		"/etcd/other_reconciliation",
+	} {
+		_, ok := fake.nodes[p]
+		assert.True(t, ok, p)
+	}
+
+	// Parseable state is kept.
+	fake.nodes["/etcd/etcd_reconciliation"] = []byte(`{"etcd-1":"slave-1"}`)
+	fake.nodes[RunningSnapshotPath("/etcd", "etcd")] = []byte("{}")
+	removed, err = CleanStaleZKState(servers, "/etcd", "etcd")
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+}
+
+func TestZKNamespacePrefixesEveryPath(t *testing.T) {
+	for _, bad := range []string{"etcd", "/etcd/", "/a//b", "/a/../b", "/zookeeper", "/a b"} {
+		assert.Error(t, SetZKNamespace(bad), bad)
+	}
+	assert.NoError(t, SetZKNamespace("/env/prod"))
+	defer SetZKNamespace("")
+
+	fake := newFakeZK()
+	defer fake.install()()
+	servers := []string{"localhost:2181"}
+
+	fwid := &mesos.FrameworkID{Value: proto.String("fwid")}
+	assert.NoError(t, PersistFrameworkID(fwid, servers, "/etcd", "etcd"))
+	assert.NoError(t, UpdateReconciliationInfo(map[string]string{"etcd-1": "slave-1"},
+		servers, "/etcd", "etcd"))
+	assert.NoError(t, UpdateRunningSnapshot(map[string]*config.Node{}, servers, "/etcd", "etcd"))
+
+	namespaced := []string{}
+	for p := range fake.nodes {
+		if strings.HasPrefix(p, "/etcd") {
+			t.Errorf("%s is outside of the namespace", p)
+		}
+		if strings.HasPrefix(p, "/env/prod/etcd/") {
+			namespaced = append(namespaced, p)
+		}
+	}
+	sort.Strings(namespaced)
+	assert.Equal(t, []string{
+		"/env/prod/etcd/etcd_framework_id",
+		"/env/prod/etcd/etcd_reconciliation",
+		"/env/prod/etcd/etcd_running",
+	}, namespaced)
+	assert.Equal(t, "/env/prod/etcd/etcd_framework_id", FrameworkIDPath("/etcd", "etcd"))
+
+	previous, err := GetPreviousFrameworkID(servers, "/etcd", "etcd")
+	assert.NoError(t, err)
+	assert.Equal(t, "fwid", previous)
+	recon, err := GetPreviousReconciliationInfo(servers, "/etcd", "etcd")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"etcd-1": "slave-1"}, recon)
+
+	assert.NoError(t, ClearZKState(servers, "/etcd", "etcd"))
+	children, _, err := fake.Children("/env/prod/etcd")
+	assert.NoError(t, err)
+	assert.Empty(t, children)
+}
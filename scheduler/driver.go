@@ -0,0 +1,148 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"sync"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/mesos/mesos-go/scheduler"
+)
+
+// ReplaceableDriver is a SchedulerDriver that forwards to a driver it can
+// replace with a freshly built one, for instance to register again as a new
+// framework.  The scheduler's goroutines hold on to the ReplaceableDriver,
+// so they follow the replacement without the process restarting.
+type ReplaceableDriver struct {
+	mut       sync.RWMutex
+	current   scheduler.SchedulerDriver
+	newDriver func() (scheduler.SchedulerDriver, error)
+}
+
+// NewReplaceableDriver returns a ReplaceableDriver forwarding to a driver
+// built by newDriver, which is called again for each replacement.
+func NewReplaceableDriver(
+	newDriver func() (scheduler.SchedulerDriver, error),
+) (*ReplaceableDriver, error) {
+	current, err := newDriver()
+	if err != nil {
+		return nil, err
+	}
+	return &ReplaceableDriver{current: current, newDriver: newDriver}, nil
+}
+
+// Replace builds a new driver and aborts the current one, after which Run
+// carries on running the new driver.
+func (d *ReplaceableDriver) Replace() error {
+	next, err := d.newDriver()
+	if err != nil {
+		return err
+	}
+	d.mut.Lock()
+	previous := d.current
+	d.current = next
+	d.mut.Unlock()
+	// Not waited for, as Replace may be called from one of the previous
+	// driver's callbacks.
+	go previous.Abort()
+	return nil
+}
+
+func (d *ReplaceableDriver) driver() scheduler.SchedulerDriver {
+	d.mut.RLock()
+	defer d.mut.RUnlock()
+	return d.current
+}
+
+// Run runs the current driver until it stops, then any driver that replaced
+// it in the meantime.
+func (d *ReplaceableDriver) Run() (mesos.Status, error) {
+	for {
+		current := d.driver()
+		status, err := current.Run()
+		if d.driver() == current {
+			return status, err
+		}
+		log.Infof("Driver stopped with status %s, running its replacement.", status)
+	}
+}
+
+func (d *ReplaceableDriver) Start() (mesos.Status, error) {
+	return d.driver().Start()
+}
+
+func (d *ReplaceableDriver) Stop(failover bool) (mesos.Status, error) {
+	return d.driver().Stop(failover)
+}
+
+func (d *ReplaceableDriver) Abort() (mesos.Status, error) {
+	return d.driver().Abort()
+}
+
+func (d *ReplaceableDriver) Join() (mesos.Status, error) {
+	return d.driver().Join()
+}
+
+func (d *ReplaceableDriver) RequestResources(requests []*mesos.Request) (mesos.Status, error) {
+	return d.driver().RequestResources(requests)
+}
+
+func (d *ReplaceableDriver) AcceptOffers(
+	offerIDs []*mesos.OfferID,
+	operations []*mesos.Offer_Operation,
+	filters *mesos.Filters,
+) (mesos.Status, error) {
+	return d.driver().AcceptOffers(offerIDs, operations, filters)
+}
+
+func (d *ReplaceableDriver) LaunchTasks(
+	offerIDs []*mesos.OfferID,
+	tasks []*mesos.TaskInfo,
+	filters *mesos.Filters,
+) (mesos.Status, error) {
+	return d.driver().LaunchTasks(offerIDs, tasks, filters)
+}
+
+func (d *ReplaceableDriver) KillTask(taskID *mesos.TaskID) (mesos.Status, error) {
+	return d.driver().KillTask(taskID)
+}
+
+func (d *ReplaceableDriver) DeclineOffer(
+	offerID *mesos.OfferID,
+	filters *mesos.Filters,
+) (mesos.Status, error) {
+	return d.driver().DeclineOffer(offerID, filters)
+}
+
+func (d *ReplaceableDriver) ReviveOffers() (mesos.Status, error) {
+	return d.driver().ReviveOffers()
+}
+
+func (d *ReplaceableDriver) SendFrameworkMessage(
+	executorID *mesos.ExecutorID,
+	slaveID *mesos.SlaveID,
+	data string,
+) (mesos.Status, error) {
+	return d.driver().SendFrameworkMessage(executorID, slaveID, data)
+}
+
+func (d *ReplaceableDriver) ReconcileTasks(statuses []*mesos.TaskStatus) (mesos.Status, error) {
+	return d.driver().ReconcileTasks(statuses)
+}
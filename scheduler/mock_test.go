@@ -30,6 +30,7 @@ type MockSchedulerDriver struct {
 	scheduler       *EtcdScheduler
 	offers          chan *mesos.Offer
 	runningStatuses chan *mesos.TaskStatus
+	launched        []*mesos.TaskInfo
 	mock.Mock
 	sync.Mutex
 }
@@ -97,6 +98,7 @@ func (m *MockSchedulerDriver) ReconcileTasks(statuses []*mesos.TaskStatus) (meso
 func (m *MockSchedulerDriver) LaunchTasks(offerIds []*mesos.OfferID, ti []*mesos.TaskInfo, f *mesos.Filters) (mesos.Status, error) {
 	m.Lock()
 	defer m.Unlock()
+	m.launched = append(m.launched, ti...)
 	if m.scheduler != nil {
 		for _, taskInfo := range ti {
 			status := util.NewTaskStatus(
@@ -133,7 +135,7 @@ func (m *MockSchedulerDriver) ReviveOffers() (mesos.Status, error) {
 	m.Lock()
 	defer m.Unlock()
 	args := m.Called()
-	return status(args, 0), args.Error(0)
+	return status(args, 0), args.Error(1)
 }
 func (m *MockSchedulerDriver) SendFrameworkMessage(eid *mesos.ExecutorID, sid *mesos.SlaveID, s string) (mesos.Status, error) {
 	m.Lock()
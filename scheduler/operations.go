@@ -0,0 +1,114 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Operation describes a long-running scheduler action, such as a reseed,
+// that an operator may cancel through the admin interface.
+type Operation struct {
+	ID      string    `json:"id"`
+	Kind    string    `json:"kind"`
+	Started time.Time `json:"started"`
+	cancel  context.CancelFunc
+}
+
+// operations is the registry of in-flight Operations.
+type operations struct {
+	sync.Mutex
+	lastID   int
+	inFlight map[string]*Operation
+}
+
+func newOperations() *operations {
+	return &operations{inFlight: map[string]*Operation{}}
+}
+
+// start registers a new operation of the given kind, returning a context
+// that is cancelled when the operation is cancelled.  Callers must call
+// finish with the operation's ID once the work is done.
+func (o *operations) start(kind string) (context.Context, *Operation) {
+	o.Lock()
+	defer o.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	o.lastID++
+	op := &Operation{
+		ID:      strconv.Itoa(o.lastID),
+		Kind:    kind,
+		Started: time.Now(),
+		cancel:  cancel,
+	}
+	o.inFlight[op.ID] = op
+	return ctx, op
+}
+
+// finish removes a completed operation from the registry.
+func (o *operations) finish(id string) {
+	o.Lock()
+	defer o.Unlock()
+	if op, present := o.inFlight[id]; present {
+		op.cancel()
+		delete(o.inFlight, id)
+	}
+}
+
+// cancel requests that an in-flight operation stop, returning false if
+// no such operation exists.
+func (o *operations) cancel(id string) bool {
+	o.Lock()
+	defer o.Unlock()
+	op, present := o.inFlight[id]
+	if present {
+		op.cancel()
+	}
+	return present
+}
+
+// list returns a copy of the in-flight operations, oldest first.
+func (o *operations) list() []Operation {
+	o.Lock()
+	defer o.Unlock()
+	ops := make([]Operation, 0, len(o.inFlight))
+	for _, op := range o.inFlight {
+		ops = append(ops, Operation{
+			ID:      op.ID,
+			Kind:    op.Kind,
+			Started: op.Started,
+		})
+	}
+	sort.Sort(byID(ops))
+	return ops
+}
+
+type byID []Operation
+
+func (b byID) Len() int      { return len(b) }
+func (b byID) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byID) Less(i, j int) bool {
+	idI, _ := strconv.Atoi(b[i].ID)
+	idJ, _ := strconv.Atoi(b[j].ID)
+	return idI < idJ
+}
@@ -19,13 +19,19 @@
 package scheduler
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -38,8 +44,11 @@ import (
 	util "github.com/mesos/mesos-go/mesosutil"
 	"github.com/mesos/mesos-go/scheduler"
 	"github.com/samuel/go-zookeeper/zk"
+	"golang.org/x/net/context"
 
+	"github.com/mesosphere/etcd-mesos/clock"
 	"github.com/mesosphere/etcd-mesos/config"
+	etcderrors "github.com/mesosphere/etcd-mesos/errors"
 	"github.com/mesosphere/etcd-mesos/offercache"
 	"github.com/mesosphere/etcd-mesos/rpc"
 )
@@ -53,8 +62,96 @@ const (
 	executorWantsCpus  = 0.1
 	executorWantsMem   = 32
 	executorWantsPorts = 1
+
+	// volumeContainerPath is where a persistent volume is mounted in the
+	// sandbox unless the volume names its own path.
+	volumeContainerPath = "etcd_volume"
+	// hostDataContainerPath is where DataHostPath is mounted in the
+	// executor sandbox, and used as etcd's data directory.
+	hostDataContainerPath = "etcd_host_data"
+)
+
+// Partition-aware task states sent by newer Mesos masters, which the
+// vendored protobufs predate.
+const (
+	taskDropped        = mesos.TaskState(9)
+	taskUnreachable    = mesos.TaskState(10)
+	taskGone           = mesos.TaskState(11)
+	taskGoneByOperator = mesos.TaskState(12)
+)
+
+// partitionAware is the PARTITION_AWARE framework capability, without
+// which masters send TASK_LOST in place of the states above.
+const partitionAware = mesos.FrameworkInfo_Capability_Type(5)
+
+// NewFrameworkInfo returns the FrameworkInfo the scheduler registers with,
+// declaring the capabilities the scheduler handles.
+func NewFrameworkInfo(
+	user string,
+	name string,
+	failoverTimeout float64,
+	webURI string,
+) *mesos.FrameworkInfo {
+	return &mesos.FrameworkInfo{
+		User:            proto.String(user), // Mesos-go will fill in user if empty.
+		Name:            proto.String(name),
+		Checkpoint:      proto.Bool(true),
+		FailoverTimeout: proto.Float64(failoverTimeout),
+		WebuiUrl:        proto.String(webURI),
+		Capabilities: []*mesos.FrameworkInfo_Capability{
+			{Type: partitionAware.Enum()},
+		},
+	}
+}
+
+// AdminBindPolicy is what AdminHTTP does when the admin port cannot be
+// bound.
+type AdminBindPolicy string
+
+const (
+	// AdminBindExit shuts the scheduler down.
+	AdminBindExit AdminBindPolicy = "exit"
+	// AdminBindRetry retries the bind with backoff before shutting down.
+	AdminBindRetry AdminBindPolicy = "retry"
+	// AdminBindEphemeral binds an ephemeral port instead, and logs it.
+	AdminBindEphemeral AdminBindPolicy = "ephemeral"
+	// AdminBindContinue runs the scheduler without an admin interface.
+	AdminBindContinue AdminBindPolicy = "continue"
+)
+
+// ParseAdminBindPolicy returns the AdminBindPolicy named by policy.
+func ParseAdminBindPolicy(policy string) (AdminBindPolicy, error) {
+	switch p := AdminBindPolicy(policy); p {
+	case AdminBindExit, AdminBindRetry, AdminBindEphemeral, AdminBindContinue:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown admin bind failure policy %q", policy)
+}
+
+// TotalLossPolicy is what the scheduler does when every member of the
+// cluster has been lost.
+type TotalLossPolicy string
+
+const (
+	// TotalLossLock makes the scheduler Immutable, awaiting manual
+	// recovery.
+	TotalLossLock TotalLossPolicy = "lock"
+	// TotalLossSeedNew starts a new, empty cluster from a single member.
+	TotalLossSeedNew TotalLossPolicy = "seed-new"
+	// TotalLossRestoreBackup starts a new cluster as TotalLossSeedNew does,
+	// then runs RestoreCommand against its first member.
+	TotalLossRestoreBackup TotalLossPolicy = "restore-backup"
 )
 
+// ParseTotalLossPolicy returns the TotalLossPolicy named by policy.
+func ParseTotalLossPolicy(policy string) (TotalLossPolicy, error) {
+	switch p := TotalLossPolicy(policy); p {
+	case TotalLossLock, TotalLossSeedNew, TotalLossRestoreBackup:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown total loss policy %q", policy)
+}
+
 // State represents the mutability of the scheduler.
 type State int32
 
@@ -81,17 +178,115 @@ type EtcdScheduler struct {
 	ZkConnect                    string
 	ZkChroot                     string
 	ZkServers                    []string
+	ReregisterOnCompleted        bool
+	RemoveRetries                int
+	RemoveQuorumGuard            bool
+	ForceRemoveToken             string
+	PruneMinHealthy              int
+	HealthCheckConcurrency       int
+	LaunchTimeout                time.Duration
+	PendingAgeWarning            time.Duration
+	ExecutorLogDir               string
+	ExecutorLogLevel             int
+	QuotaBackendBytes            int64
+	AutoCompactionRetention      string
+	DefragInterval               time.Duration
+	CompactInterval              time.Duration
+	CompactRetention             int64
+	Version                      string
+	FullRefuseSeconds            float64
+	TransientRefuseSeconds       float64
+	UndersizedRefuseSeconds      float64
+	ReseedCooldown               time.Duration
+	ReseedKillTimeout            time.Duration
+	ReseedHealthTimeout          time.Duration
+	ReseedHealthBackoffCap       time.Duration
+	MaxReseeds                   int
+	ReseedWindow                 time.Duration
+	ClusterAttribute             string
+	PersistRetries               int
+	ExternalSeed                 []*config.Node
+	AvoidOtherClusters           bool
+	LeaderStableWindow           time.Duration
+	KillGracePeriod              time.Duration
+	ChillPerMember               time.Duration
+	MinAgentCpus                 float64
+	MinAgentMem                  float64
+	MinAgentDisk                 float64
+	LostGracePeriod              time.Duration
+	FailureThreshold             int
+	FailureWindow                time.Duration
+	SnapshotInterval             time.Duration
+	FetcherCache                 bool
+	RebalanceInterval            time.Duration
+	ZoneAttribute                string
+	PreferredZone                string
+	LeaderPlacementInterval      time.Duration
+	NoMatchTimeout               time.Duration
+	NoMatchShutdown              bool
+	BootstrapDeadline            time.Duration
+	BootstrapShutdown            bool
+	ClusterToken                 string
+	DiscoveryURL                 string
+	ExecutorSource               string
+	User                         string
+	DataHostPath                 string
+	EtcdServerCertFile           string
+	EtcdServerKeyFile            string
+	EtcdServerCAFile             string
+	EtcdExecutorCertFile         string
+	EtcdExecutorKeyFile          string
+	AdminBindFailure             AdminBindPolicy
+	ReconcileAttempts            int
+	ReconcileBackoffCap          time.Duration
+	SyncWaitAttempts             int
+	SyncWaitBackoffCap           time.Duration
+	ReconcileInterval            time.Duration
+	TotalLoss                    TotalLossPolicy
+	RestoreCommand               string
+	TaskCapMultiple              int
+	MaxInstanceCount             int
+	HeartbeatTimeout             time.Duration
+	StatsdAddress                string
+	StatsdPrefix                 string
+	StatsdInterval               time.Duration
 	singleInstancePerSlave       bool
 	desiredInstanceCount         int
 	healthCheck                  func(map[string]*config.Node) error
+	memberHealthy                func(*config.Node) bool
 	shutdown                     func()
+	reregister                   func() error
+	logOffer                     func(offerLog)
+	clearZKStateFunc             func([]string, string, string) error
+	persistFrameworkID           func(*mesos.FrameworkID, []string, string, string) error
+	persistBackoff               time.Duration
+	clock                        clock.Clock
+	rankReseedCandidates         func(map[string]*config.Node) []rpc.NodeIndex
+	triggerReseed                func(*config.Node) error
+	alarmList                    func(map[string]*config.Node) ([]rpc.Alarm, error)
+	defragOrder                  func(map[string]*config.Node) []*config.Node
+	defragment                   func(*config.Node) error
+	currentRevision              func(map[string]*config.Node) (int64, error)
+	compact                      func(map[string]*config.Node, int64) error
+	leaderMember                 func(map[string]*config.Node) (*config.Node, error)
+	moveLeader                   func(map[string]*config.Node, *config.Node) error
+	openSnapshot                 func(map[string]*config.Node) (string, io.ReadCloser, error)
+	memberList                   func(map[string]*config.Node) (map[string]string, error)
+	removeInstance               func(map[string]*config.Node, string, int, bool) error
+	memberVersions               func(map[string]*config.Node) map[string]string
+	lastContact                  func(*config.Node) time.Time
 	reconciliationInfoFunc       func([]string, string, string) (map[string]string, error)
 	updateReconciliationInfoFunc func(map[string]string, []string, string, string) error
+	runningSnapshot              func([]string, string, string) (map[string]*config.Node, error)
+	updateRunningSnapshot        func(map[string]*config.Node, []string, string, string) error
+	restoreBackup                func(*config.Node) error
 	mut                          sync.RWMutex
 	state                        State
 	frameworkID                  *mesos.FrameworkID
 	masterInfo                   *mesos.MasterInfo
-	pending                      map[string]struct{}
+	pending                      map[string]string
+	launchAttempts               map[string]launchAttempt
+	migrating                    map[string]struct{}
 	running                      map[string]*config.Node
 	heardFrom                    map[string]struct{}
 	tasks                        map[string]*mesos.TaskID
@@ -103,22 +298,134 @@ type EtcdScheduler struct {
 	cpusPerTask                  float64
 	memPerTask                   float64
 	offerRefuseSeconds           float64
+	offersSuppressed             bool
 	pauseChan                    chan struct{}
 	chillSeconds                 time.Duration
 	autoReseedEnabled            bool
 	reseedTimeout                time.Duration
 	livelockWindow               *time.Time
+	livelockSince                int64
 	reseeding                    int32
-	reconciliationInfo           map[string]string
+	safeMode                     int32
+	lastReseed                   time.Time
+	reseedTimes                  []time.Time
+	offeredSlaves                map[string]time.Time
+	dedupKey                     offercache.DedupKey
+	suspect                      map[string]time.Time
+	failures                     map[string][]time.Time
+	shortfall                    shortfall
+	defragging                   int32
+	upgrading                    int32
+	// surge counts the members a rolling upgrade runs beyond
+	// desiredInstanceCount while replacing others, which MaxInstanceCount
+	// allows for.
+	surge                 int
+	upgradePollInterval   time.Duration
+	bootstrapPollInterval time.Duration
+	bootstrapArmed        bool
+	reconciliationInfo    map[string]string
+	operations            *operations
+	lostAt                []time.Time
+	volumes               map[string][]string
+	lostVolumes           map[string]struct{}
+	alarms                []rpc.Alarm
+	restorePending        bool
+	// discovering is set while the members of a new cluster bootstrap
+	// against DiscoveryURL, until desiredInstanceCount of them are running.
+	discovering bool
+	// discoveryUsed is set once DiscoveryURL has bootstrapped a cluster, or
+	// one is known to have been started before, as a discovery token only
+	// ever bootstraps one cluster.
+	discoveryUsed bool
+	// heartbeats holds, for each goroutine reporting heartbeats, the time
+	// in unix nanoseconds by which it should beat again, or 0 while idle.
+	heartbeats map[string]*int64
 }
 
 type Stats struct {
-	RunningServers   uint32 `json:"running_servers"`
-	LaunchedServers  uint32 `json:"launched_servers"`
-	FailedServers    uint32 `json:"failed_servers"`
-	ClusterLivelocks uint32 `json:"cluster_livelocks"`
-	ClusterReseeds   uint32 `json:"cluster_reseeds"`
-	IsHealthy        uint32 `json:"healthy"`
+	// LastCompactRev is first so that its 64-bit atomic accesses stay
+	// aligned on 32-bit platforms, as Stats is first in EtcdScheduler.
+	LastCompactRev    int64  `json:"last_compact_revision"`
+	RunningServers    uint32 `json:"running_servers"`
+	LaunchedServers   uint32 `json:"launched_servers"`
+	FailedServers     uint32 `json:"failed_servers"`
+	ClusterLivelocks  uint32 `json:"cluster_livelocks"`
+	ClusterReseeds    uint32 `json:"cluster_reseeds"`
+	IsHealthy         uint32 `json:"healthy"`
+	Recoveries        uint32 `json:"recoveries"`
+	LastRecoveryMs    uint32 `json:"last_recovery_ms"`
+	ActiveAlarms      uint32 `json:"active_alarms"`
+	NoSpaceAlarm      uint32 `json:"nospace_alarm"`
+	MixedVersions     uint32 `json:"mixed_versions"`
+	ReseedsSuppressed uint32 `json:"reseeds_suppressed"`
+	SlavesExhausted   uint32 `json:"slaves_exhausted"`
+	NoMatchingOffers  uint32 `json:"no_matching_offers"`
+	LastCompactTime   uint32 `json:"last_compact_time"`
+	CachedOffers      uint32 `json:"cached_offers"`
+	OldestPendingSecs uint32 `json:"oldest_pending_secs"`
+	SafeMode          uint32 `json:"safe_mode"`
+	AutoReseed        uint32 `json:"auto_reseed"`
+	LivelockOpen      uint32 `json:"livelock_open"`
+	SecsUntilReseed   uint32 `json:"secs_until_reseed"`
+}
+
+// shortfall tracks consecutive offers declined for the same lack of
+// resources while instances are wanted.
+type shortfall struct {
+	reason  string
+	count   int
+	since   time.Time
+	tripped bool
+}
+
+// SchedulerSnapshot is a point-in-time copy of the scheduler's state.  It
+// exposes the same information as the /stats and /members admin endpoints
+// for callers embedding the scheduler in-process.
+type SchedulerSnapshot struct {
+	State   State                  `json:"state"`
+	Running map[string]config.Node `json:"running"`
+	Pending []string               `json:"pending"`
+	Stats   Stats                  `json:"stats"`
+}
+
+// offerLog holds the fields logged for each offer decision.
+type offerLog struct {
+	OfferID  string
+	SlaveID  string
+	Cpus     float64
+	Mem      float64
+	Ports    uint64
+	Disk     float64
+	Decision string
+	Reason   string
+}
+
+// String formats the entry as space separated key=value fields, quoting
+// values that contain spaces.
+func (o offerLog) String() string {
+	line := fmt.Sprintf("offer=%s slave=%s cpus=%g mem=%g ports=%d disk=%g decision=%s",
+		o.OfferID, o.SlaveID, o.Cpus, o.Mem, o.Ports, o.Disk, o.Decision)
+	if o.Reason != "" {
+		line += fmt.Sprintf(" reason=%q", o.Reason)
+	}
+	return line
+}
+
+// launchAttempt records a task launch that has not yet been heard from.
+type launchAttempt struct {
+	taskID   *mesos.TaskID
+	launched time.Time
+	version  string
+	host     string
+	// confirmed is set once the task has been reported staging or beyond,
+	// and so counted in LaunchedServers.
+	confirmed bool
+	// reclaimed holds the lost volumes the launch took, which are lost
+	// again should the driver reject it.
+	reclaimed []string
+	// node is the member as launched.  Its task ID only records its name,
+	// host and ports, so the member is adopted from here once running.
+	node *config.Node
 }
 
 type OfferResources struct {
@@ -140,43 +447,140 @@ func NewEtcdScheduler(
 	memPerTask float64,
 	offerRefuseSeconds float64,
 ) *EtcdScheduler {
-	return &EtcdScheduler{
+	s := &EtcdScheduler{
 		Stats: Stats{
 			IsHealthy: 1,
 		},
-		state:                Immutable,
-		running:              map[string]*config.Node{},
-		heardFrom:            map[string]struct{}{},
-		pending:              map[string]struct{}{},
-		tasks:                map[string]*mesos.TaskID{},
-		highestInstanceID:    time.Now().Unix(),
-		executorUris:         executorUris,
-		ZkServers:            []string{},
-		chillSeconds:         time.Duration(chillSeconds),
-		autoReseedEnabled:    autoReseed,
-		reseedTimeout:        time.Second * time.Duration(reseedTimeout),
-		desiredInstanceCount: desiredInstanceCount,
-		launchChan:           make(chan struct{}, 2048),
-		pauseChan:            make(chan struct{}, 2048),
+		state:                   Immutable,
+		running:                 map[string]*config.Node{},
+		heardFrom:               map[string]struct{}{},
+		pending:                 map[string]string{},
+		launchAttempts:          map[string]launchAttempt{},
+		migrating:               map[string]struct{}{},
+		tasks:                   map[string]*mesos.TaskID{},
+		highestInstanceID:       time.Now().Unix(),
+		executorUris:            executorUris,
+		ZkServers:               []string{},
+		RemoveRetries:           rpc.RPC_RETRIES,
+		PersistRetries:          rpc.RPC_RETRIES,
+		RemoveQuorumGuard:       true,
+		LaunchTimeout:           5 * time.Minute,
+		HeartbeatTimeout:        10 * time.Minute,
+		PendingAgeWarning:       2 * time.Minute,
+		FullRefuseSeconds:       300,
+		TransientRefuseSeconds:  5,
+		UndersizedRefuseSeconds: 300,
+		ReseedCooldown:          30 * time.Second,
+		ReseedKillTimeout:       time.Minute,
+		ReseedHealthBackoffCap:  8 * time.Second,
+		HealthCheckConcurrency:  8,
+		LeaderPlacementInterval: time.Minute,
+		MaxReseeds:              1,
+		ReseedWindow:            10 * time.Minute,
+		LeaderStableWindow:      5 * time.Second,
+		KillGracePeriod:         10 * time.Second,
+		FailureThreshold:        1,
+		FailureWindow:           10 * time.Minute,
+		SnapshotInterval:        30 * time.Second,
+		upgradePollInterval:     time.Second,
+		bootstrapPollInterval:   5 * time.Second,
+		ExecutorLogDir:          "./",
+		AdminBindFailure:        AdminBindExit,
+		ReconcileAttempts:       5,
+		ReconcileBackoffCap:     8 * time.Second,
+		SyncWaitAttempts:        5,
+		SyncWaitBackoffCap:      8 * time.Second,
+		ReconcileInterval:       5 * time.Minute,
+		TotalLoss:               TotalLossLock,
+		TaskCapMultiple:         2,
+		StatsdPrefix:            "etcd_mesos",
+		StatsdInterval:          10 * time.Second,
+		chillSeconds:            time.Duration(chillSeconds),
+		autoReseedEnabled:       autoReseed,
+		reseedTimeout:           time.Second * time.Duration(reseedTimeout),
+		desiredInstanceCount:    desiredInstanceCount,
+		launchChan:              make(chan struct{}, 2048),
+		pauseChan:               make(chan struct{}, 2048),
 		offerCache: offercache.New(
 			desiredInstanceCount,
 			singleInstancePerSlave,
+			offercache.BySlaveID,
 		),
 		healthCheck:                  rpc.HealthCheck,
+		memberHealthy:                rpc.MemberHealthy,
 		shutdown:                     func() { os.Exit(1) },
+		logOffer:                     func(o offerLog) { log.V(2).Info(o) },
+		clearZKStateFunc:             rpc.ClearZKState,
+		persistFrameworkID:           rpc.PersistFrameworkID,
+		persistBackoff:               time.Second,
+		clock:                        clock.Real{},
+		heartbeats:                   newHeartbeats(),
+		rankReseedCandidates:         rpc.RankReseedCandidates,
+		triggerReseed:                rpc.TriggerReseed,
+		alarmList:                    rpc.AlarmList,
+		defragOrder:                  rpc.DefragOrder,
+		defragment:                   rpc.Defragment,
+		currentRevision:              rpc.CurrentRevision,
+		compact:                      rpc.Compact,
+		leaderMember:                 rpc.LeaderMember,
+		moveLeader:                   rpc.MoveLeader,
+		openSnapshot:                 rpc.OpenSnapshot,
+		memberList:                   rpc.MemberList,
+		removeInstance:               rpc.RemoveInstance,
+		memberVersions:               rpc.MemberVersions,
+		lastContact:                  rpc.LastContact,
 		reconciliationInfoFunc:       rpc.GetPreviousReconciliationInfo,
 		updateReconciliationInfoFunc: rpc.UpdateReconciliationInfo,
+		runningSnapshot:              rpc.GetRunningSnapshot,
+		updateRunningSnapshot:        rpc.UpdateRunningSnapshot,
 		singleInstancePerSlave:       singleInstancePerSlave,
 		diskPerTask:                  diskPerTask,
 		cpusPerTask:                  cpusPerTask,
 		memPerTask:                   memPerTask,
 		offerRefuseSeconds:           offerRefuseSeconds,
 		reconciliationInfo:           map[string]string{},
+		operations:                   newOperations(),
+		volumes:                      map[string][]string{},
+		lostVolumes:                  map[string]struct{}{},
+		offeredSlaves:                map[string]time.Time{},
+		suspect:                      map[string]time.Time{},
+		failures:                     map[string][]time.Time{},
 	}
+	s.offerCache.SetTimeSource(func() time.Time { return s.clock.Now() })
+	s.restoreBackup = s.runRestoreCommand
+	return s
 }
 
 // ----------------------- mesos callbacks ------------------------- //
 
+// persistFrameworkIDWithRetries persists the framework ID to ZK, retrying
+// with backoff so that a momentary ZK blip at registration does not take
+// the scheduler down.  It always makes at least one attempt, as a framework
+// ID never persisted leaves a restarted scheduler unable to find its tasks.
+func (s *EtcdScheduler) persistFrameworkIDWithRetries(frameworkID *mesos.FrameworkID) error {
+	attempts := s.PersistRetries
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	backoff := 1
+	for retries := 0; retries < attempts; retries++ {
+		err = s.persistFrameworkID(
+			frameworkID,
+			s.ZkServers,
+			s.ZkChroot,
+			s.FrameworkName,
+		)
+		if err == nil || err == zk.ErrNodeExists {
+			return err
+		}
+		log.Warningf("Failed to persist framework ID, retrying: %s", err)
+		s.clock.Sleep(time.Duration(backoff) * s.persistBackoff)
+		backoff = int(math.Min(float64(backoff<<1), 8))
+	}
+	return err
+}
+
 func (s *EtcdScheduler) Registered(
 	driver scheduler.SchedulerDriver,
 	frameworkID *mesos.FrameworkID,
@@ -188,12 +592,7 @@ func (s *EtcdScheduler) Registered(
 	s.mut.Unlock()
 
 	if s.ZkConnect != "" {
-		err := rpc.PersistFrameworkID(
-			frameworkID,
-			s.ZkServers,
-			s.ZkChroot,
-			s.FrameworkName,
-		)
+		err := s.persistFrameworkIDWithRetries(frameworkID)
 		if err != nil && err != zk.ErrNodeExists {
 			log.Errorf("Failed to persist framework ID: %s", err)
 			if s.shutdown != nil {
@@ -232,84 +631,127 @@ func (s *EtcdScheduler) ResourceOffers(
 		portsWanted = uint64(portsPerTask + executorWantsPorts)
 	)
 	for _, offer := range offers {
-		resources := parseOffer(offer)
+		resources, err := parseOffer(offer)
+		if err != nil {
+			log.Warningf("Declining malformed offer %s from slave %s: %v",
+				offer.GetId().GetValue(), offer.GetSlaveId().GetValue(), err)
+			s.logOffer(offerLog{
+				OfferID:  offer.GetId().GetValue(),
+				SlaveID:  offer.GetSlaveId().GetValue(),
+				Decision: "declined",
+				Reason:   "malformed offer",
+			})
+			s.decline(driver, offer)
+			continue
+		}
 
 		totalPorts := uint64(0)
 		for _, pr := range resources.ports {
 			totalPorts += (*pr.End + 1) - *pr.Begin
 		}
 
-		log.V(2).Infoln("Received Offer <", offer.Id.GetValue(),
-			"> with cpus=", resources.cpus,
-			" mem=", resources.mems,
-			" ports=", totalPorts,
-			" disk=", resources.disk,
-			" from slave ", *offer.SlaveId.Value)
+		entry := offerLog{
+			OfferID: offer.GetId().GetValue(),
+			SlaveID: offer.GetSlaveId().GetValue(),
+			Cpus:    resources.cpus,
+			Mem:     resources.mems,
+			Ports:   totalPorts,
+			Disk:    resources.disk,
+		}
+		decline := func(reason string) {
+			entry.Decision, entry.Reason = "declined", reason
+			s.logOffer(entry)
+			s.declineFor(driver, offer, s.refuseSecondsFor(reason))
+		}
 
-		s.mut.RLock()
+		// The placement decision and the push into the offer cache happen
+		// under a single hold of the scheduler lock, so that a concurrent
+		// StatusUpdate or launch can't claim this slave in between.
+		s.mut.Lock()
 		if s.state == Immutable {
-			log.V(2).Info("Scheduler is Immutable.  Declining received offer.")
-			s.decline(driver, offer)
-			s.mut.RUnlock()
+			decline("scheduler immutable")
+			s.mut.Unlock()
 			continue
 		}
-		s.mut.RUnlock()
-
-		alreadyUsingSlave := false
-		for _, config := range s.RunningCopy() {
-			if config.SlaveID == offer.GetSlaveId().GetValue() {
-				alreadyUsingSlave = true
-				break
-			}
-		}
-		if alreadyUsingSlave {
-			log.V(2).Infoln("Already using this slave for etcd instance.")
-			if s.singleInstancePerSlave {
-				log.V(2).Infoln("Skipping offer.")
-				s.decline(driver, offer)
-				continue
-			}
-			log.V(2).Infoln("-single-instance-per-slave is false, continuing.")
-		}
 
-		if resources.cpus < cpusWanted {
-			log.V(1).Infoln("Offer cpu is insufficient.")
+		// Offers carry an unavailability when their slave is scheduled
+		// for maintenance.  Never place a new instance there, and move any
+		// instance already there elsewhere before the window begins.
+		if offer.GetUnavailability() != nil {
+			decline("slave scheduled for maintenance")
+			s.migrateFromSlave(driver, offer.GetSlaveId().GetValue())
+			s.mut.Unlock()
+			continue
 		}
 
-		if resources.mems < memWanted {
-			log.V(1).Infoln("Offer memory is insufficient.")
+		// There is nothing to launch while the cluster is at full
+		// strength, so turn offers away for longer rather than caching
+		// them, until a member is lost.
+		if len(s.running) >= s.desiredInstanceCount &&
+			atomic.LoadInt32(&s.reseeding) != reseedUnderway {
+			entry.Decision, entry.Reason = "declined", "cluster at desired size"
+			s.logOffer(entry)
+			s.declineFor(driver, offer, s.FullRefuseSeconds)
+			s.offersSuppressed = true
+			s.mut.Unlock()
+			continue
 		}
 
-		if totalPorts < portsWanted {
-			log.V(1).Infoln("Offer ports are insuffient.")
+		if s.AvoidOtherClusters && s.hostsOtherCluster(offer) {
+			decline("slave hosts another etcd cluster")
+			s.mut.Unlock()
+			continue
 		}
 
-		if resources.disk < s.diskPerTask {
-			log.V(1).Infoln("Offer disk is insufficient.")
+		if s.undersizedAgent(offer) {
+			decline("slave below minimum capacity")
+			s.noteShortfall("slave below minimum capacity")
+			s.mut.Unlock()
+			continue
 		}
 
-		if resources.cpus >= cpusWanted &&
-			resources.mems >= memWanted &&
-			totalPorts >= portsWanted &&
-			resources.disk >= s.diskPerTask &&
-			s.offerCache.Push(offer) {
+		s.offeredSlaves[s.dedupKey.Of(offer)] = s.clock.Now()
 
-			// golang for-loop variable reuse necessitates a copy here.
-			offerCpy := *offer
-			go func() {
-				time.Sleep(s.chillSeconds / 2 * time.Second)
-				// Decline the offer if we don't try to take it after a few seconds.
-				if s.offerCache.Rescind(offerCpy.Id) {
-					s.decline(driver, &offerCpy)
-				}
-			}()
+		if s.usingSlave(offer) && s.singleInstancePerSlave {
+			decline("slave already in use")
+			s.checkSlaveExhaustion()
+			s.mut.Unlock()
+			continue
+		}
 
-			log.V(2).Infoln("Added offer to offer cache.")
-			s.QueueLaunchAttempt()
-		} else {
-			s.decline(driver, offer)
-			log.V(2).Infoln("Offer rejected.")
+		reason := ""
+		switch {
+		case resources.cpus < cpusWanted:
+			reason = "insufficient cpus"
+		case resources.mems < memWanted:
+			reason = "insufficient mem"
+		case totalPorts < portsWanted:
+			reason = "insufficient ports"
+		case resources.disk < s.diskPerTask:
+			reason = "insufficient disk"
+		case s.offerCache.Len() >= s.launchesNeeded():
+			// Launches are serial and chilled, so offers cached beyond
+			// those that can be used would only sit until rescinded.
+			reason = "enough offers cached"
+		case !s.offerCache.Push(offer):
+			reason = "offer cache full"
+		}
+		if reason != "" {
+			decline(reason)
+			if strings.HasPrefix(reason, "insufficient") {
+				s.noteShortfall(reason)
+			}
+			s.mut.Unlock()
+			continue
 		}
+		s.shortfall = shortfall{}
+		atomic.StoreUint32(&s.Stats.NoMatchingOffers, 0)
+
+		atomic.StoreUint32(&s.Stats.SlavesExhausted, 0)
+		entry.Decision = "cached"
+		s.logOffer(entry)
+		s.QueueLaunchAttempt()
+		s.mut.Unlock()
 	}
 }
 
@@ -341,44 +783,39 @@ func (s *EtcdScheduler) StatusUpdate(
 		mesos.TaskState_TASK_FINISHED,
 		mesos.TaskState_TASK_KILLED,
 		mesos.TaskState_TASK_ERROR,
-		mesos.TaskState_TASK_FAILED:
-
-		log.Errorf("Task contraction: %+v", status.GetState())
-		log.Errorf("message: %s", status.GetMessage())
-		log.Errorf("reason: %+v", status.GetReason())
-
-		atomic.AddUint32(&s.Stats.FailedServers, 1)
-
-		// TODO(tyler) kill this
-		// Pump the brakes so that we have time to deconfigure the lost node
-		// before adding a new one.  If we don't deconfigure first, we risk
-		// split brain.
-		s.PumpTheBrakes()
-
-		// now we know this task is dead
-		delete(s.pending, node.Name)
-		delete(s.running, node.Name)
-		delete(s.tasks, node.Name)
-
-		// We don't have to clean up the state in ZK for this
-		// as it is fine to eventually just persist when we
-		// receive a new TASK_RUNNING below.
-		delete(s.reconciliationInfo, status.TaskId.GetValue())
-
-		s.QueueLaunchAttempt()
-
-		// TODO(tyler) do we want to lock if the first task fails?
-		// TODO(tyler) can we handle a total loss at reconciliation time,
-		//             when s.state == Immutable?
-		if len(s.running) == 0 && s.state == Mutable {
-			log.Error("TOTAL CLUSTER LOSS!  LOCKING SCHEDULER, " +
-				"FOLLOW RESTORATION GUIDE AT " +
-				"https://github.com/mesosphere/" +
-				"etcd-mesos/blob/master/docs/response.md")
-			s.state = Immutable
-		}
-	case mesos.TaskState_TASK_STARTING:
+		mesos.TaskState_TASK_FAILED,
+		taskDropped,
+		taskGone,
+		taskGoneByOperator:
+		if status.GetState() == mesos.TaskState_TASK_LOST &&
+			s.suspectLost(driver, status, node) {
+			return
+		}
+		if status.GetState() == mesos.TaskState_TASK_FAILED &&
+			s.tolerateFailure(node) {
+			return
+		}
+		s.taskTerminated(driver, status, node)
+	case taskUnreachable:
+		// The slave is partitioned from the master, which is often
+		// transient.  Keep the member until the task is reported gone or
+		// comes back, rather than replacing it on every network blip.
+		log.Warningf("Task %s is unreachable, not yet treating it as lost: %s",
+			status.GetTaskId().GetValue(), status.GetMessage())
+	case mesos.TaskState_TASK_STAGING,
+		mesos.TaskState_TASK_STARTING:
+		s.confirmLaunch(node.Name)
 	case mesos.TaskState_TASK_RUNNING:
+		if s.beyondTaskCap(node) {
+			log.Errorf("Refusing to adopt task %s, as %d tasks are already "+
+				"tracked for a cluster of %d.  Is the framework ID shared "+
+				"with another cluster?  Killing it.",
+				status.GetTaskId().GetValue(), len(s.running)+len(s.pending),
+				s.desiredInstanceCount)
+			driver.KillTask(status.TaskId)
+			return
+		}
+
 		// We update data to ZK synchronously because it must happen
 		// in-order.  If we spun off a goroutine this would possibly retry
 		// and succeed in the wrong order, and older data would win.
@@ -396,11 +833,39 @@ func (s *EtcdScheduler) StatusUpdate(
 			log.Errorf("Failed to persist reconciliation info: %+v", err)
 		}
 
+		// The version an instance was launched with, along with its zone,
+		// schemes and the rest of its configuration beyond what the task
+		// ID records, is only known for launches we made ourselves, not for
+		// reconciled instances.
+		if attempt, launched := s.launchAttempts[node.Name]; launched &&
+			attempt.node != nil &&
+			attempt.taskID.GetValue() == status.TaskId.GetValue() {
+			launchedNode := *attempt.node
+			launchedNode.SlaveID = node.SlaveID
+			node = &launchedNode
+		}
+		node.Version = s.launchAttempts[node.Name].version
+		s.confirmLaunch(node.Name)
 		delete(s.pending, node.Name)
+		delete(s.launchAttempts, node.Name)
+		if _, suspect := s.suspect[node.Name]; suspect {
+			log.Infof("Member %s recovered from being lost.", node.Name)
+			delete(s.suspect, node.Name)
+		}
 		_, present := s.running[node.Name]
 		if !present {
 			s.running[node.Name] = node
 			s.tasks[node.Name] = status.TaskId
+			s.recordRecovery()
+		}
+		if s.discovering && len(s.running) >= s.desiredInstanceCount {
+			log.Infof("Cluster formed through discovery with %d members, "+
+				"managing its membership from now on.", len(s.running))
+			s.discovering = false
+		}
+		if s.restorePending && len(s.running) == 1 {
+			s.restorePending = false
+			go s.restoreInto(node)
 		}
 
 		// During reconcilliation, we may find nodes with higher ID's due to ntp drift
@@ -422,6 +887,212 @@ func (s *EtcdScheduler) StatusUpdate(
 	}
 }
 
+// CheckInstanceCount returns an error if a cluster of count members would
+// exceed MaxInstanceCount, a hard cap on resource usage whatever size the
+// cluster is asked to be.  0 leaves the size uncapped.
+func (s *EtcdScheduler) CheckInstanceCount(count int) error {
+	if s.MaxInstanceCount > 0 && count > s.MaxInstanceCount {
+		return fmt.Errorf("a cluster of %d members exceeds the maximum of %d",
+			count, s.MaxInstanceCount)
+	}
+	return nil
+}
+
+// beyondTaskCap returns whether node is a task we do not yet track, and
+// tracking it would take us to more than TaskCapMultiple times the desired
+// number of instances.  Not thread safe!  Callers must hold s.mut.
+func (s *EtcdScheduler) beyondTaskCap(node *config.Node) bool {
+	if s.TaskCapMultiple <= 0 {
+		return false
+	}
+	_, running := s.running[node.Name]
+	_, pending := s.pending[node.Name]
+	if running || pending {
+		return false
+	}
+	return len(s.running)+len(s.pending) >= s.TaskCapMultiple*s.desiredInstanceCount
+}
+
+// tolerateFailure holds off on reacting to a running member reported
+// failed until it has failed FailureThreshold times within FailureWindow,
+// as crashes that heal by themselves would otherwise churn the cluster.
+// It returns whether the failure is being tolerated.  Not thread safe!
+// Callers must hold s.mut.
+func (s *EtcdScheduler) tolerateFailure(node *config.Node) bool {
+	if s.FailureThreshold <= 1 {
+		return false
+	}
+	if _, present := s.running[node.Name]; !present {
+		return false
+	}
+	recent := []time.Time{}
+	for _, at := range s.failures[node.Name] {
+		if s.clock.Since(at) < s.FailureWindow {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, s.clock.Now())
+	if len(recent) >= s.FailureThreshold {
+		log.Errorf("Member %s has failed %d times within %s.",
+			node.Name, len(recent), s.FailureWindow)
+		delete(s.failures, node.Name)
+		return false
+	}
+	s.failures[node.Name] = recent
+	log.Warningf("Member %s reported failed, %d of %d failures within %s "+
+		"before it is replaced.", node.Name, len(recent), s.FailureThreshold,
+		s.FailureWindow)
+	return true
+}
+
+// suspectLost holds off on reacting to a running member reported lost for
+// LostGracePeriod, as the loss may be a transient partition and the task
+// may come back.  It returns whether the loss is being held off.  Not
+// thread safe!  Callers must hold s.mut.
+func (s *EtcdScheduler) suspectLost(
+	driver scheduler.SchedulerDriver,
+	status *mesos.TaskStatus,
+	node *config.Node,
+) bool {
+	if s.LostGracePeriod <= 0 {
+		return false
+	}
+	if _, present := s.running[node.Name]; !present {
+		return false
+	}
+	if _, suspect := s.suspect[node.Name]; suspect {
+		return true
+	}
+	since := s.clock.Now()
+	s.suspect[node.Name] = since
+	log.Warningf("Member %s reported lost, waiting %s for it to recover.",
+		node.Name, s.LostGracePeriod)
+	go func() {
+		s.clock.Sleep(s.LostGracePeriod)
+		s.mut.Lock()
+		defer s.mut.Unlock()
+		// A recovery status clears the suspicion, and a later loss
+		// starts a new one with its own deadline.
+		if s.suspect[node.Name] != since {
+			return
+		}
+		log.Errorf("Member %s did not recover from being lost.", node.Name)
+		s.taskTerminated(driver, status, node)
+	}()
+	return true
+}
+
+// taskTerminated forgets a task that has reached a terminal state and
+// queues a launch to replace it.  Not thread safe!  Callers must hold
+// s.mut.
+func (s *EtcdScheduler) taskTerminated(
+	driver scheduler.SchedulerDriver,
+	status *mesos.TaskStatus,
+	node *config.Node,
+) {
+	log.Errorf("Task contraction: %+v", status.GetState())
+	log.Errorf("message: %s", status.GetMessage())
+	log.Errorf("reason: %+v", status.GetReason())
+
+	atomic.AddUint32(&s.Stats.FailedServers, 1)
+
+	// The discovery token now expects a member that will never register,
+	// so its replacement, and those after it, join as usual instead.
+	if s.discovering {
+		log.Warningf("Member %s failed while the cluster formed through "+
+			"discovery, no longer using the discovery URL.", node.Name)
+		s.discovering = false
+	}
+
+	// TODO(tyler) kill this
+	// Pump the brakes so that we have time to deconfigure the lost node
+	// before adding a new one.  If we don't deconfigure first, we risk
+	// split brain.
+	s.PumpTheBrakes()
+
+	// Note when a running member is lost, so that we can measure how
+	// long it takes for a replacement to heal the cluster.
+	if _, present := s.running[node.Name]; present {
+		s.lostAt = append(s.lostAt, s.clock.Now())
+		s.reviveOffers(driver)
+	}
+
+	// Any persistent volumes offered alongside this member's launch
+	// hold its data, so prefer them for its replacement.
+	for _, id := range s.volumes[node.Name] {
+		s.lostVolumes[id] = struct{}{}
+	}
+	delete(s.volumes, node.Name)
+
+	// now we know this task is dead
+	delete(s.pending, node.Name)
+	delete(s.launchAttempts, node.Name)
+	delete(s.migrating, node.Name)
+	delete(s.running, node.Name)
+	delete(s.tasks, node.Name)
+	delete(s.suspect, node.Name)
+	delete(s.failures, node.Name)
+
+	// We don't have to clean up the state in ZK for this
+	// as it is fine to eventually just persist when we
+	// receive a new TASK_RUNNING.
+	delete(s.reconciliationInfo, status.TaskId.GetValue())
+
+	s.QueueLaunchAttempt()
+
+	// TODO(tyler) do we want to lock if the first task fails?
+	// TODO(tyler) can we handle a total loss at reconciliation time,
+	//             when s.state == Immutable?
+	if len(s.running) == 0 && s.state == Mutable {
+		s.totalLoss()
+	}
+}
+
+// totalLoss reacts to every member having been lost, according to
+// TotalLoss.  Not thread safe!  Callers must hold s.mut.
+func (s *EtcdScheduler) totalLoss() {
+	policy := s.TotalLoss
+	if policy == TotalLossRestoreBackup && s.RestoreCommand == "" {
+		log.Error("No -restore-command is configured to restore a backup with.")
+		policy = TotalLossLock
+	}
+	switch policy {
+	case TotalLossSeedNew, TotalLossRestoreBackup:
+		// The launch queued for the lost member starts a new cluster,
+		// as none are running.
+		log.Errorf("TOTAL CLUSTER LOSS!  Seeding a new cluster, "+
+			"as the total loss policy is %s.", policy)
+		s.restorePending = policy == TotalLossRestoreBackup
+	default:
+		log.Error("TOTAL CLUSTER LOSS!  LOCKING SCHEDULER, " +
+			"FOLLOW RESTORATION GUIDE AT " +
+			"https://github.com/mesosphere/" +
+			"etcd-mesos/blob/master/docs/response.md")
+		s.state = Immutable
+	}
+}
+
+// restoreInto restores a backup into the new cluster seeded by node after a
+// total loss.
+func (s *EtcdScheduler) restoreInto(node *config.Node) {
+	log.Infof("Restoring a backup into the new cluster via %s.", node.Name)
+	if err := s.restoreBackup(node); err != nil {
+		log.Errorf("Failed to restore a backup into the new cluster: %v", err)
+		return
+	}
+	log.Info("Restored a backup into the new cluster.")
+}
+
+// runRestoreCommand runs RestoreCommand with ETCD_ENDPOINTS set to node's
+// client URL.
+func (s *EtcdScheduler) runRestoreCommand(node *config.Node) error {
+	cmd := exec.Command("sh", "-c", s.RestoreCommand)
+	cmd.Env = append(os.Environ(), "ETCD_ENDPOINTS="+node.ClientURL())
+	output, err := cmd.CombinedOutput()
+	log.Infof("Restore command output: %s", output)
+	return err
+}
+
 func (s *EtcdScheduler) OfferRescinded(
 	driver scheduler.SchedulerDriver,
 	offerID *mesos.OfferID,
@@ -456,7 +1127,21 @@ func (s *EtcdScheduler) ExecutorLost(
 func (s *EtcdScheduler) Error(driver scheduler.SchedulerDriver, err string) {
 	log.Infoln("Scheduler received error:", err)
 	if err == "Completed framework attempted to re-register" {
-		rpc.ClearZKState(s.ZkServers, s.ZkChroot, s.FrameworkName)
+		s.clearZKStateFunc(s.ZkServers, s.ZkChroot, s.FrameworkName)
+		s.mut.RLock()
+		reregister := s.reregister
+		s.mut.RUnlock()
+		if s.ReregisterOnCompleted && reregister != nil {
+			log.Error(
+				"Removing reference to completed framework in " +
+					"zookeeper and re-registering as a new framework.",
+			)
+			err := reregister()
+			if err == nil {
+				return
+			}
+			log.Errorf("Could not re-register as a new framework: %s", err)
+		}
 		log.Error(
 			"Removing reference to completed " +
 				"framework in zookeeper and dying.",
@@ -467,6 +1152,15 @@ func (s *EtcdScheduler) Error(driver scheduler.SchedulerDriver, err string) {
 	}
 }
 
+// SetReregister sets how the scheduler registers again as a new framework
+// when ReregisterOnCompleted is set, typically by replacing its driver, see
+// ReplaceableDriver.  It is meant to be called before the driver starts.
+func (s *EtcdScheduler) SetReregister(reregister func() error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.reregister = reregister
+}
+
 // ----------------------- helper functions ------------------------- //
 
 // decline declines an offer.
@@ -474,68 +1168,664 @@ func (s *EtcdScheduler) decline(
 	driver scheduler.SchedulerDriver,
 	offer *mesos.Offer,
 ) {
-	log.V(2).Infof("Declining offer %s.", offer.Id.GetValue())
+	// Decline offers for configured interval.
+	s.declineFor(driver, offer, s.offerRefuseSeconds)
+}
+
+// refuseSecondsFor returns how long the slave of an offer declined for
+// reason should be refused.  Ports and disk are often only held for a while
+// by other tasks, so slaves short on them are offered again after
+// TransientRefuseSeconds, while slaves whose total capacity is below the
+// minimum, which no amount of waiting changes, are left alone for
+// UndersizedRefuseSeconds.  An offer short on cpus or mem may just be a
+// fragment of a larger slave, so it gets the configured refuse seconds, as
+// do other reasons or either being 0.
+func (s *EtcdScheduler) refuseSecondsFor(reason string) float64 {
+	refuseSeconds := float64(0)
+	switch reason {
+	case "insufficient ports", "insufficient disk":
+		refuseSeconds = s.TransientRefuseSeconds
+	case "slave below minimum capacity":
+		refuseSeconds = s.UndersizedRefuseSeconds
+	}
+	if refuseSeconds <= 0 {
+		return s.offerRefuseSeconds
+	}
+	return refuseSeconds
+}
+
+func (s *EtcdScheduler) declineFor(
+	driver scheduler.SchedulerDriver,
+	offer *mesos.Offer,
+	refuseSeconds float64,
+) {
+	log.V(2).Infof("offer=%s slave=%s decision=declined refuse_seconds=%g",
+		offer.GetId().GetValue(), offer.GetSlaveId().GetValue(), refuseSeconds)
 	driver.DeclineOffer(
 		offer.Id,
 		&mesos.Filters{
-			// Decline offers for configured interval.
-			RefuseSeconds: proto.Float64(s.offerRefuseSeconds),
+			RefuseSeconds: proto.Float64(refuseSeconds),
 		},
 	)
 }
 
-// RunningCopy makes a copy of the running map to minimize time
-// spent with the scheduler lock is minimized.
-func (s *EtcdScheduler) RunningCopy() map[string]*config.Node {
-	s.mut.RLock()
-	defer s.mut.RUnlock()
-	runningCopy := map[string]*config.Node{}
-	for k, v := range s.running {
-		runningCopy[k] = v
+// reviveOffers clears the long filters placed on offers declined while the
+// cluster was at full strength, now that we need an offer again.  Not
+// thread safe!  Callers must hold s.mut.
+func (s *EtcdScheduler) reviveOffers(driver scheduler.SchedulerDriver) {
+	if !s.offersSuppressed {
+		return
+	}
+	log.Info("Reviving offers declined while at desired size.")
+	s.offersSuppressed = false
+	if _, err := driver.ReviveOffers(); err != nil {
+		log.Errorf("Failed to revive offers: %v", err)
 	}
-	return runningCopy
 }
 
-func (s *EtcdScheduler) Initialize(
+// recordRecovery attributes a newly running member to the oldest
+// outstanding member loss, recording how long the cluster took to heal.
+// Instance names are never reused, so losses and replacements are matched
+// in order.  Not thread safe!  Callers must hold s.mut.
+func (s *EtcdScheduler) recordRecovery() {
+	if len(s.lostAt) == 0 {
+		return
+	}
+	healTime := s.clock.Since(s.lostAt[0])
+	s.lostAt = s.lostAt[1:]
+	log.Infof("Recovered from member loss in %s.", healTime)
+	atomic.AddUint32(&s.Stats.Recoveries, 1)
+	atomic.StoreUint32(&s.Stats.LastRecoveryMs,
+		uint32(healTime/time.Millisecond))
+}
+
+// migrateFromSlave kills the etcd instance running on a slave that is
+// about to undergo maintenance, so that it is deconfigured and replaced on
+// another slave through the usual task loss handling.  Only one instance
+// is migrated at a time, and only while the cluster is at full strength,
+// so that a migration never costs the cluster its quorum.  Not thread
+// safe!  Callers must hold s.mut.
+func (s *EtcdScheduler) migrateFromSlave(
 	driver scheduler.SchedulerDriver,
-	masterInfo *mesos.MasterInfo,
+	slaveID string,
 ) {
-	// Reset mutable state
-	s.mut.Lock()
-	s.running = map[string]*config.Node{}
-	s.heardFrom = map[string]struct{}{}
-	s.reconciliationInfo = map[string]string{}
-	s.masterInfo = masterInfo
-	s.mut.Unlock()
-
-	go s.attemptMasterSync(driver)
+	for name, node := range s.running {
+		if node.SlaveID != slaveID {
+			continue
+		}
+		s.migrate(driver, name, "ahead of maintenance")
+		return
+	}
 }
 
-func (s *EtcdScheduler) attemptMasterSync(driver scheduler.SchedulerDriver) {
-	// Request that the master send us TaskStatus for live tasks.
+// migrate kills a running member so that it is deconfigured and replaced
+// elsewhere, unless a migration is already underway, the cluster is not
+// at full strength, or the remaining members would not hold a quorum of
+// the cluster while the replacement starts.  Not thread safe!  Callers
+// must hold s.mut.
+func (s *EtcdScheduler) migrate(
+	driver scheduler.SchedulerDriver,
+	name string,
+	why string,
+) {
+	if _, underway := s.migrating[name]; underway {
+		return
+	}
+	node := s.running[name]
+	if s.inSafeMode() {
+		log.Infof("In safe mode, not migrating %s off of slave %s.",
+			name, node.SlaveID)
+		return
+	}
+	if len(s.migrating) != 0 || len(s.pending) != 0 ||
+		len(s.running) < s.desiredInstanceCount {
+		log.Infof("Postponing migration of %s off of slave %s until "+
+			"the cluster is at full strength.", name, node.SlaveID)
+		return
+	}
+	// A cluster of one or two members loses its quorum, or its only
+	// member, for as long as the replacement takes to start.
+	if len(s.running)-1 < s.desiredInstanceCount/2+1 {
+		log.Warningf("Not migrating %s off of slave %s, as the remaining "+
+			"%d members would not hold a quorum of %d.", name, node.SlaveID,
+			len(s.running)-1, s.desiredInstanceCount)
+		return
+	}
+	log.Warningf("Migrating %s off of slave %s %s.", name, node.SlaveID, why)
+	s.migrating[name] = struct{}{}
+	driver.KillTask(s.tasks[name])
+}
 
-	backoff := 1
-	for retries := 0; retries < 5; retries++ {
-		previousReconciliationInfo, err := s.reconciliationInfoFunc(
-			s.ZkServers,
-			s.ZkChroot,
-			s.FrameworkName,
-		)
-		if err == nil {
-			s.mut.Lock()
-			s.reconciliationInfo = previousReconciliationInfo
-			s.mut.Unlock()
+// PeriodicRebalancer spreads members sharing a slave out onto other slaves
+// every RebalanceInterval.
+func (s *EtcdScheduler) PeriodicRebalancer(driver scheduler.SchedulerDriver) {
+	if s.RebalanceInterval <= 0 {
+		return
+	}
+	for {
+		s.clock.Sleep(s.RebalanceInterval)
+		s.rebalance(driver)
+	}
+}
 
-			statuses := []*mesos.TaskStatus{}
-			for taskID, slaveID := range previousReconciliationInfo {
-				statuses = append(statuses, &mesos.TaskStatus{
-					SlaveId: util.NewSlaveID(slaveID),
-					TaskId:  util.NewTaskID(taskID),
-					State:   mesos.TaskState_TASK_RUNNING.Enum(),
-				})
-			}
+// PeriodicLeaderPlacer moves leadership of the cluster into PreferredZone
+// every LeaderPlacementInterval, so that it returns there after elections.
+func (s *EtcdScheduler) PeriodicLeaderPlacer() {
+	if s.PreferredZone == "" || s.LeaderPlacementInterval <= 0 {
+		return
+	}
+	for {
+		s.clock.Sleep(s.LeaderPlacementInterval)
+		s.placeLeader()
+	}
+}
 
-			// Here we do both implicit and explicit task reconciliation
+// placeLeader moves leadership to the first member, by name, in
+// PreferredZone, if the leader is elsewhere.  It waits for the cluster to
+// be at full strength, so that leadership is not moved about while
+// membership is changing.
+func (s *EtcdScheduler) placeLeader() {
+	if s.inSafeMode() {
+		log.V(2).Infoln("In safe mode, not moving the leader.")
+		return
+	}
+	s.mut.RLock()
+	if s.state != Mutable || len(s.pending) != 0 ||
+		len(s.running) < s.desiredInstanceCount {
+		s.mut.RUnlock()
+		return
+	}
+	s.mut.RUnlock()
+	running := s.RunningCopy()
+
+	leader, err := s.leaderMember(running)
+	if err != nil {
+		log.V(2).Infof("Could not find the leader to place it: %v", err)
+		return
+	}
+	if leader.Zone == s.PreferredZone {
+		return
+	}
+	names := []string{}
+	for name, node := range running {
+		if node != nil && node.Zone == s.PreferredZone {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		log.V(2).Infof("No member is in preferred zone %s to move leadership to.",
+			s.PreferredZone)
+		return
+	}
+	sort.Strings(names)
+	log.Infof("Leader %s is in zone %q, moving leadership to %s in "+
+		"preferred zone %s.", leader.Name, leader.Zone, names[0], s.PreferredZone)
+	if err := s.moveLeader(running, running[names[0]]); err != nil {
+		log.Errorf("Failed to move leadership to %s: %v", names[0], err)
+	}
+}
+
+// rebalance migrates one member off of the slave hosting the most members,
+// if several share it and another slave has recently offered resources
+// without hosting any.  Migrations go through the usual loss handling and
+// serial launcher one at a time, so quorum is never at risk.
+func (s *EtcdScheduler) rebalance(driver scheduler.SchedulerDriver) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.state != Mutable {
+		return
+	}
+
+	members := map[string][]string{}
+	for name, node := range s.running {
+		if node != nil {
+			machine := s.machine(node.SlaveID, node.Host)
+			members[machine] = append(members[machine], name)
+		}
+	}
+	crowded := ""
+	for machine, names := range members {
+		if len(names) > len(members[crowded]) ||
+			(len(names) == len(members[crowded]) && machine < crowded) {
+			crowded = machine
+		}
+	}
+	if len(members[crowded]) < 2 {
+		return
+	}
+
+	free := false
+	for machine, at := range s.offeredSlaves {
+		if _, used := members[machine]; !used && s.clock.Since(at) <= offeredSlaveTTL {
+			free = true
+			break
+		}
+	}
+	if !free {
+		log.V(2).Infof("%d members share slave %s, but no other slave is "+
+			"free to rebalance onto.", len(members[crowded]), crowded)
+		return
+	}
+
+	sort.Strings(members[crowded])
+	s.migrate(driver, members[crowded][len(members[crowded])-1], "to rebalance members")
+}
+
+var (
+	errUnknownMember       = errors.New("no such running member")
+	errLastMember          = errors.New("refusing to kill the last running member")
+	errForceRemoveDisabled = errors.New("force removal is disabled without -force-remove-token")
+	errForceRemoveConfirm  = errors.New("confirm does not match -force-remove-token")
+)
+
+// forceRemoveMember deconfigures a member without regard for quorum, as
+// the break-glass escape from a cluster wedged below quorum, and kills its
+// task if it has one.  It requires confirm to match ForceRemoveToken.
+func (s *EtcdScheduler) forceRemoveMember(
+	driver scheduler.SchedulerDriver,
+	name string,
+	confirm string,
+) error {
+	if s.ForceRemoveToken == "" {
+		return errForceRemoveDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(confirm), []byte(s.ForceRemoveToken)) != 1 {
+		return errForceRemoveConfirm
+	}
+	running := s.RunningCopy()
+	s.mut.RLock()
+	taskID, present := s.tasks[name]
+	s.mut.RUnlock()
+
+	log.Errorf("FORCE REMOVING MEMBER %s ON REQUEST, IGNORING QUORUM!  "+
+		"The remaining members may lose writes or be left unable to elect "+
+		"a leader.", name)
+	if err := s.removeInstance(running, name, s.RemoveRetries, true); err != nil {
+		log.Errorf("Failed to force remove member %s: %v", name, err)
+		return err
+	}
+	log.Errorf("Force removed member %s from the cluster configuration.", name)
+	if present {
+		log.Warningf("Killing force removed member %s.", name)
+		if _, err := driver.KillTask(taskID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// killMember kills a running member without deconfiguring it first, leaving
+// the usual failure handling to deconfigure and replace it.
+func (s *EtcdScheduler) killMember(driver scheduler.SchedulerDriver, name string) error {
+	s.mut.RLock()
+	taskID, present := s.tasks[name]
+	_, running := s.running[name]
+	remaining := len(s.running)
+	s.mut.RUnlock()
+
+	if !present || !running {
+		return errUnknownMember
+	}
+	if remaining <= 1 {
+		return errLastMember
+	}
+	log.Warningf("Killing %s on request.", name)
+	_, err := driver.KillTask(taskID)
+	return err
+}
+
+// SetSingleInstancePerSlave changes whether an etcd instance may share a
+// slave with another.  When enabling it, cached offers that would now
+// violate it are declined.
+func (s *EtcdScheduler) SetSingleInstancePerSlave(
+	driver scheduler.SchedulerDriver,
+	single bool,
+) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	log.Infof("Setting single instance per slave to %t.", single)
+	s.singleInstancePerSlave = single
+	stale := s.offerCache.SetSingleInstancePerSlave(single)
+	if single {
+		for {
+			offer := s.offerCache.PopMatching(func(o *mesos.Offer) bool {
+				return s.usingSlave(o)
+			})
+			if offer == nil {
+				break
+			}
+			stale = append(stale, offer)
+		}
+	}
+	for _, offer := range stale {
+		s.decline(driver, offer)
+	}
+	if !single {
+		// Offers previously turned away may now be usable.
+		s.QueueLaunchAttempt()
+	}
+}
+
+// SingleInstancePerSlave returns whether etcd instances are kept on
+// separate slaves.
+func (s *EtcdScheduler) SingleInstancePerSlave() bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.singleInstancePerSlave
+}
+
+// launchesNeeded returns how many more instances must be launched to reach
+// the desired cluster size, counting pending launches, and at least one as
+// a reseed may need a launch while the cluster appears full.  Not thread
+// safe!  Callers must hold s.mut.
+func (s *EtcdScheduler) launchesNeeded() int {
+	needed := s.desiredInstanceCount - len(s.running) - len(s.pending)
+	if needed < 1 {
+		return 1
+	}
+	return needed
+}
+
+// noteShortfall records an offer declined for lack of resources while
+// instances are wanted.  When every offer for NoMatchTimeout has been
+// declined for the same reason, no launch is likely to ever succeed, so it
+// reports the misconfiguration prominently and, with NoMatchShutdown,
+// shuts the scheduler down rather than looping forever.  Not thread safe!
+// Callers must hold s.mut.
+func (s *EtcdScheduler) noteShortfall(reason string) {
+	if s.NoMatchTimeout <= 0 {
+		return
+	}
+	if s.shortfall.reason != reason {
+		s.shortfall = shortfall{reason: reason, since: s.clock.Now()}
+	}
+	s.shortfall.count++
+	if s.shortfall.tripped ||
+		s.shortfall.count < noMatchMinDeclines ||
+		s.clock.Since(s.shortfall.since) < s.NoMatchTimeout {
+		return
+	}
+	s.shortfall.tripped = true
+	atomic.StoreUint32(&s.Stats.NoMatchingOffers, 1)
+	log.Errorf("No offer has matched for %s: the last %d offers were all "+
+		"declined with %q.  Check the requested resources against what "+
+		"the slaves offer.", s.clock.Since(s.shortfall.since),
+		s.shortfall.count, reason)
+	if s.NoMatchShutdown {
+		log.Error("Shutting down, as -no-match-shutdown is set.")
+		s.shutdown()
+	}
+}
+
+// armBootstrapDeadline starts enforcing BootstrapDeadline from the first
+// launch of a new cluster, unless it is disabled or already armed.  Not
+// thread safe!
+// Callers must hold s.mut.
+func (s *EtcdScheduler) armBootstrapDeadline() {
+	if s.BootstrapDeadline <= 0 || s.bootstrapArmed {
+		return
+	}
+	s.bootstrapArmed = true
+	go s.enforceBootstrapDeadline()
+}
+
+// enforceBootstrapDeadline fails the bootstrap, and with BootstrapShutdown
+// shuts the scheduler down, if the cluster has not reached the desired
+// number of healthy members within BootstrapDeadline, so that provisioning
+// pipelines fail fast rather than wait on launches retried forever.
+func (s *EtcdScheduler) enforceBootstrapDeadline() {
+	start := s.clock.Now()
+	for !s.bootstrapped() {
+		if s.clock.Since(start) >= s.BootstrapDeadline {
+			log.Errorf("Bootstrap failed: the cluster did not reach %d healthy "+
+				"members within %s of the first launch.",
+				s.desiredInstanceCount, s.BootstrapDeadline)
+			if s.BootstrapShutdown {
+				log.Error("Shutting down, as -bootstrap-shutdown is set.")
+				s.shutdown()
+			}
+			return
+		}
+		s.clock.Sleep(s.bootstrapPollInterval)
+	}
+	log.Infof("Bootstrap complete after %s.", s.clock.Since(start))
+}
+
+// bootstrapped returns whether the desired number of members are running
+// and the cluster passes a health check.
+func (s *EtcdScheduler) bootstrapped() bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	if len(s.running) < s.desiredInstanceCount {
+		return false
+	}
+	return s.healthCheck(s.running) == nil
+}
+
+// noMatchMinDeclines is how many offers in a row must be declined for the
+// same shortfall before NoMatchTimeout is enforced, so that a single slow
+// trickle of offers doesn't trip it.
+const noMatchMinDeclines = 3
+
+// offeredSlaveTTL is how long a slave is assumed to still be available
+// after it last sent us an offer.
+const offeredSlaveTTL = 10 * time.Minute
+
+// checkSlaveExhaustion warns when single instance per slave is preventing
+// the cluster from growing because there are fewer slaves offering
+// resources than desired instances.  Not thread safe!  Callers must hold
+// s.mut.
+func (s *EtcdScheduler) checkSlaveExhaustion() {
+	slaves := map[string]struct{}{}
+	for slaveID, at := range s.offeredSlaves {
+		if s.clock.Since(at) > offeredSlaveTTL {
+			delete(s.offeredSlaves, slaveID)
+			continue
+		}
+		slaves[slaveID] = struct{}{}
+	}
+	for _, node := range s.running {
+		if node != nil {
+			slaves[s.machine(node.SlaveID, node.Host)] = struct{}{}
+		}
+	}
+	for name, slaveID := range s.pending {
+		slaves[s.machine(slaveID, s.launchAttempts[name].host)] = struct{}{}
+	}
+
+	if len(s.running) >= s.desiredInstanceCount ||
+		len(slaves) >= s.desiredInstanceCount {
+		atomic.StoreUint32(&s.Stats.SlavesExhausted, 0)
+		return
+	}
+	if atomic.SwapUint32(&s.Stats.SlavesExhausted, 1) == 0 {
+		log.Warningf("Cannot grow to %d instances: only %d slaves are "+
+			"offering resources, and each may only run one instance.  "+
+			"Add slaves or disable -single-instance-per-slave.",
+			s.desiredInstanceCount, len(slaves))
+	}
+}
+
+// usingSlave returns whether an etcd instance is running on, or is being
+// launched onto, the machine an offer comes from.  Not thread safe!
+// Callers must hold s.mut.
+func (s *EtcdScheduler) usingSlave(offer *mesos.Offer) bool {
+	key := s.dedupKey.Of(offer)
+	for _, node := range s.running {
+		if node != nil && s.machine(node.SlaveID, node.Host) == key {
+			return true
+		}
+	}
+	for name, slaveID := range s.pending {
+		if s.machine(slaveID, s.launchAttempts[name].host) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// machine returns the dedup key of the machine with the given slave ID and
+// hostname.
+func (s *EtcdScheduler) machine(slaveID, host string) string {
+	if s.dedupKey == offercache.ByHostname {
+		return host
+	}
+	return slaveID
+}
+
+// SetDedupKey changes what identifies the machine an offer comes from when
+// only a single instance may run per slave.  It is meant to be called
+// before the scheduler driver starts.
+func (s *EtcdScheduler) SetDedupKey(key offercache.DedupKey) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.dedupKey = key
+	if stale := s.offerCache.SetDedupKey(key); len(stale) > 0 {
+		log.Warningf("Dropped %d cached offers when changing the dedup key.", len(stale))
+	}
+}
+
+// RunningCopy makes a copy of the running map to minimize time
+// spent with the scheduler lock is minimized.
+func (s *EtcdScheduler) RunningCopy() map[string]*config.Node {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	runningCopy := map[string]*config.Node{}
+	for k, v := range s.running {
+		runningCopy[k] = v
+	}
+	return runningCopy
+}
+
+// StatsCopy atomically loads each of the scheduler's counters.
+func (s *EtcdScheduler) StatsCopy() Stats {
+	// livelockSince mirrors livelockWindow, which is guarded by s.mut.
+	livelockOpen, secsUntilReseed := uint32(0), uint32(0)
+	if since := atomic.LoadInt64(&s.livelockSince); since != 0 {
+		livelockOpen = 1
+		remaining := s.reseedTimeout - s.clock.Since(time.Unix(0, since))
+		if remaining > 0 {
+			secsUntilReseed = uint32(math.Ceil(remaining.Seconds()))
+		}
+	}
+	stats := Stats{
+		RunningServers:    atomic.LoadUint32(&s.Stats.RunningServers),
+		LaunchedServers:   atomic.LoadUint32(&s.Stats.LaunchedServers),
+		FailedServers:     atomic.LoadUint32(&s.Stats.FailedServers),
+		ClusterLivelocks:  atomic.LoadUint32(&s.Stats.ClusterLivelocks),
+		ClusterReseeds:    atomic.LoadUint32(&s.Stats.ClusterReseeds),
+		IsHealthy:         atomic.LoadUint32(&s.Stats.IsHealthy),
+		Recoveries:        atomic.LoadUint32(&s.Stats.Recoveries),
+		LastRecoveryMs:    atomic.LoadUint32(&s.Stats.LastRecoveryMs),
+		ActiveAlarms:      atomic.LoadUint32(&s.Stats.ActiveAlarms),
+		NoSpaceAlarm:      atomic.LoadUint32(&s.Stats.NoSpaceAlarm),
+		MixedVersions:     atomic.LoadUint32(&s.Stats.MixedVersions),
+		ReseedsSuppressed: atomic.LoadUint32(&s.Stats.ReseedsSuppressed),
+		SlavesExhausted:   atomic.LoadUint32(&s.Stats.SlavesExhausted),
+		NoMatchingOffers:  atomic.LoadUint32(&s.Stats.NoMatchingOffers),
+		LastCompactRev:    atomic.LoadInt64(&s.Stats.LastCompactRev),
+		LastCompactTime:   atomic.LoadUint32(&s.Stats.LastCompactTime),
+		CachedOffers:      uint32(s.offerCache.Len()),
+		OldestPendingSecs: atomic.LoadUint32(&s.Stats.OldestPendingSecs),
+		SafeMode:          uint32(atomic.LoadInt32(&s.safeMode)),
+		LivelockOpen:      livelockOpen,
+		SecsUntilReseed:   secsUntilReseed,
+	}
+	if s.autoReseedEnabled {
+		stats.AutoReseed = 1
+	}
+	return stats
+}
+
+// Snapshot returns a copy of the scheduler's current state that shares no
+// memory with the scheduler, so it may be freely inspected or modified.
+func (s *EtcdScheduler) Snapshot() SchedulerSnapshot {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	snapshot := SchedulerSnapshot{
+		State:   s.state,
+		Running: make(map[string]config.Node, len(s.running)),
+		Pending: make([]string, 0, len(s.pending)),
+		Stats:   s.StatsCopy(),
+	}
+	for name, node := range s.running {
+		if node != nil {
+			snapshot.Running[name] = *node
+		}
+	}
+	for name := range s.pending {
+		snapshot.Pending = append(snapshot.Pending, name)
+	}
+	sort.Strings(snapshot.Pending)
+	return snapshot
+}
+
+func (s *EtcdScheduler) Initialize(
+	driver scheduler.SchedulerDriver,
+	masterInfo *mesos.MasterInfo,
+) {
+	// Reset mutable state
+	s.mut.Lock()
+	s.running = map[string]*config.Node{}
+	s.heardFrom = map[string]struct{}{}
+	s.reconciliationInfo = map[string]string{}
+	s.masterInfo = masterInfo
+	s.mut.Unlock()
+
+	go s.attemptMasterSync(driver)
+}
+
+// attemptMasterSync reconciles with the master up to ReconcileAttempts
+// times, each time waiting for the reconciled statuses with up to
+// SyncWaitAttempts checks, and shuts the scheduler down if it never syncs.
+func (s *EtcdScheduler) attemptMasterSync(driver scheduler.SchedulerDriver) {
+	// Request that the master send us TaskStatus for live tasks.
+
+	log.Infof("Syncing with master: up to %d reconcile attempts of %d sync "+
+		"checks each, %d checks in all, sleeping at most %s.",
+		s.ReconcileAttempts, s.SyncWaitAttempts,
+		s.ReconcileAttempts*s.SyncWaitAttempts,
+		backoffBudget(s.ReconcileAttempts, s.ReconcileBackoffCap)+
+			time.Duration(s.ReconcileAttempts)*
+				backoffBudget(s.SyncWaitAttempts, s.SyncWaitBackoffCap))
+
+	backoff := time.Second
+	for retries := 0; retries < s.ReconcileAttempts; retries++ {
+		previousReconciliationInfo, err := s.reconciliationInfoFunc(
+			s.ZkServers,
+			s.ZkChroot,
+			s.FrameworkName,
+		)
+		if err == nil {
+			snapshot, snapErr := s.runningSnapshot(
+				s.ZkServers,
+				s.ZkChroot,
+				s.FrameworkName,
+			)
+			if snapErr != nil {
+				log.Warningf("Could not load running snapshot, "+
+					"relying on reconciliation alone: %v", snapErr)
+			}
+			s.mut.Lock()
+			s.reconciliationInfo = previousReconciliationInfo
+			// A previous scheduler launched members, so the cluster
+			// has already been bootstrapped.
+			if len(previousReconciliationInfo) > 0 {
+				s.discoveryUsed = true
+			}
+			s.primeRunning(snapshot)
+			s.mut.Unlock()
+
+			statuses := []*mesos.TaskStatus{}
+			for taskID, slaveID := range previousReconciliationInfo {
+				statuses = append(statuses, &mesos.TaskStatus{
+					SlaveId: util.NewSlaveID(slaveID),
+					TaskId:  util.NewTaskID(taskID),
+					State:   mesos.TaskState_TASK_RUNNING.Enum(),
+				})
+			}
+
+			// Here we do both implicit and explicit task reconciliation
 			// in the off-chance that we were unable to persist a running
 			// task in ZK after it started.
 			_, err = driver.ReconcileTasks([]*mesos.TaskStatus{})
@@ -563,8 +1853,7 @@ func (s *EtcdScheduler) attemptMasterSync(driver scheduler.SchedulerDriver) {
 		} else {
 			log.Error(err)
 		}
-		time.Sleep(time.Duration(backoff) * time.Second)
-		backoff = int(math.Min(float64(backoff<<1), 8))
+		backoff = s.backoffSleep(backoff, s.ReconcileBackoffCap)
 	}
 	log.Error("Failed to synchronize with master!  " +
 		"It is dangerous to continue at this point.  Dying.")
@@ -574,6 +1863,67 @@ func (s *EtcdScheduler) attemptMasterSync(driver scheduler.SchedulerDriver) {
 
 }
 
+// primeRunning adopts the members of a persisted running snapshot whose
+// tasks are also in the persisted reconciliation info, so that they are
+// known before their reconciled statuses arrive.  Any that turn out to be
+// dead are removed when their terminal status arrives.  Not thread safe!
+// Callers must hold s.mut.
+func (s *EtcdScheduler) primeRunning(snapshot map[string]*config.Node) {
+	for name, node := range snapshot {
+		if node == nil || node.Name != name {
+			continue
+		}
+		taskID := node.String()
+		slaveID, present := s.reconciliationInfo[taskID]
+		if !present {
+			log.Warningf("Ignoring snapshot of %s, which is not in the "+
+				"persisted reconciliation info.", name)
+			continue
+		}
+		node.SlaveID = slaveID
+		s.running[name] = node
+		s.tasks[name] = util.NewTaskID(taskID)
+	}
+	if len(snapshot) > 0 {
+		log.Infof("Primed %d running members from the snapshot.", len(s.running))
+	}
+}
+
+// PeriodicSnapshotter persists the running members to ZK every
+// SnapshotInterval.
+func (s *EtcdScheduler) PeriodicSnapshotter() {
+	if s.SnapshotInterval <= 0 {
+		return
+	}
+	for {
+		s.clock.Sleep(s.SnapshotInterval)
+		s.snapshotRunning()
+	}
+}
+
+func (s *EtcdScheduler) snapshotRunning() {
+	s.mut.RLock()
+	// Until reconciliation completes the running members are incomplete,
+	// and would overwrite a better snapshot.
+	if s.state != Mutable {
+		s.mut.RUnlock()
+		return
+	}
+	running := map[string]*config.Node{}
+	for name, node := range s.running {
+		if node != nil {
+			nodeCopy := *node
+			running[name] = &nodeCopy
+		}
+	}
+	s.mut.RUnlock()
+
+	err := s.updateRunningSnapshot(running, s.ZkServers, s.ZkChroot, s.FrameworkName)
+	if err != nil {
+		log.Errorf("Failed to persist running snapshot: %v", err)
+	}
+}
+
 func (s *EtcdScheduler) isInSync() bool {
 	// TODO(tyler) clean up rpc.GetPeersFromState!
 	s.mut.RLock()
@@ -589,12 +1939,25 @@ func (s *EtcdScheduler) isInSync() bool {
 			return false
 		}
 	}
+	// Matching counts are not enough: the tasks we have adopted as running
+	// must be exactly the ones we previously persisted, or we may have
+	// adopted the wrong member while missing a correct one.
+	if len(s.tasks) != len(s.reconciliationInfo) {
+		return false
+	}
+	for name, taskID := range s.tasks {
+		if _, present := s.reconciliationInfo[taskID.GetValue()]; !present {
+			log.Warningf("Running task %s for %s is not in the persisted "+
+				"reconciliation info.", taskID.GetValue(), name)
+			return false
+		}
+	}
 	return true
 }
 
 func (s *EtcdScheduler) waitForMasterSync() error {
-	backoff := 1
-	for retries := 0; retries < 5; retries++ {
+	backoff := time.Second
+	for retries := 0; retries < s.SyncWaitAttempts; retries++ {
 		log.Info("Trying to sync with master.")
 
 		if s.masterInfo == nil || s.masterInfo.Hostname == nil {
@@ -608,12 +1971,36 @@ func (s *EtcdScheduler) waitForMasterSync() error {
 		} else {
 			log.Warning("Scheduler not yet in sync with master.")
 		}
-		time.Sleep(time.Duration(backoff) * time.Second)
-		backoff = int(math.Min(float64(backoff<<1), 8))
+		backoff = s.backoffSleep(backoff, s.SyncWaitBackoffCap)
 	}
 	return errors.New("Unable to sync with master.")
 }
 
+// backoffSleep sleeps for backoff, or limit if that is shorter, and
+// returns the doubled backoff for the next retry.
+func (s *EtcdScheduler) backoffSleep(backoff, limit time.Duration) time.Duration {
+	if backoff > limit {
+		backoff = limit
+	}
+	s.clock.Sleep(backoff)
+	return backoff * 2
+}
+
+// backoffBudget returns the total time slept by attempts retries through
+// backoffSleep, starting from a second and capped at limit.
+func backoffBudget(attempts int, limit time.Duration) time.Duration {
+	budget := time.Duration(0)
+	backoff := time.Second
+	for i := 0; i < attempts; i++ {
+		if backoff > limit {
+			backoff = limit
+		}
+		budget += backoff
+		backoff *= 2
+	}
+	return budget
+}
+
 func (s *EtcdScheduler) QueueLaunchAttempt() {
 	select {
 	case s.launchChan <- struct{}{}:
@@ -623,6 +2010,29 @@ func (s *EtcdScheduler) QueueLaunchAttempt() {
 	}
 }
 
+// EnterSafeMode stops the scheduler from launching, pruning, reseeding or
+// migrating members until LeaveSafeMode is called.  Tasks are still tracked
+// and the admin endpoints still served, so that an operator can inspect
+// the cluster after a restart before the scheduler acts on it.
+func (s *EtcdScheduler) EnterSafeMode() {
+	atomic.StoreInt32(&s.safeMode, 1)
+	log.Warningln("Entered safe mode: no members will be launched, pruned, " +
+		"reseeded or migrated until POST /unsafe.")
+}
+
+// LeaveSafeMode undoes EnterSafeMode and queues a launch attempt to make
+// up any shortfall that built up in the meantime.
+func (s *EtcdScheduler) LeaveSafeMode() {
+	if atomic.CompareAndSwapInt32(&s.safeMode, 1, 0) {
+		log.Warningln("Left safe mode.")
+		s.QueueLaunchAttempt()
+	}
+}
+
+func (s *EtcdScheduler) inSafeMode() bool {
+	return atomic.LoadInt32(&s.safeMode) == 1
+}
+
 func (s *EtcdScheduler) PumpTheBrakes() {
 	select {
 	case s.pauseChan <- struct{}{}:
@@ -631,7 +2041,10 @@ func (s *EtcdScheduler) PumpTheBrakes() {
 	}
 }
 
-// Perform implicit reconciliation every 5 minutes
+// PeriodicReconciler performs implicit reconciliation, and explicit
+// reconciliation of every tracked task, every ReconcileInterval.  The
+// master answers explicit reconciliation of tasks it no longer knows of
+// with TASK_LOST, correcting state left stale by lost status updates.
 func (s *EtcdScheduler) PeriodicReconciler(driver scheduler.SchedulerDriver) {
 	for {
 		s.mut.RLock()
@@ -642,40 +2055,234 @@ func (s *EtcdScheduler) PeriodicReconciler(driver scheduler.SchedulerDriver) {
 			if err != nil {
 				log.Errorf("Error while calling ReconcileTasks: %s", err)
 			}
+			_, err = driver.ReconcileTasks(s.trackedStatuses())
+			if err != nil {
+				log.Errorf("Error while calling ReconcileTasks: %s", err)
+			}
+		}
+		s.clock.Sleep(s.ReconcileInterval)
+	}
+}
+
+// trackedStatuses returns the statuses we believe our tracked tasks to
+// have, for explicit reconciliation.
+func (s *EtcdScheduler) trackedStatuses() []*mesos.TaskStatus {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	statuses := []*mesos.TaskStatus{}
+	for name, taskID := range s.tasks {
+		status := &mesos.TaskStatus{
+			TaskId: taskID,
+			State:  mesos.TaskState_TASK_RUNNING.Enum(),
+		}
+		if node := s.running[name]; node != nil && node.SlaveID != "" {
+			status.SlaveId = util.NewSlaveID(node.SlaveID)
 		}
-		time.Sleep(5 * time.Minute)
+		statuses = append(statuses, status)
 	}
+	return statuses
 }
 
 func (s *EtcdScheduler) PeriodicHealthChecker() {
 	for {
-		time.Sleep(5 * s.chillSeconds * time.Second)
-		nodes := s.RunningCopy()
+		s.clock.Sleep(5 * s.chillSeconds * time.Second)
+		s.checkClusterHealth()
+	}
+}
+
+// checkClusterHealth refreshes the health and alarm stats.  A cluster with
+// an active NOSPACE alarm rejects writes, so it is never considered healthy
+// regardless of whether raft is making progress.
+func (s *EtcdScheduler) checkClusterHealth() {
+	nodes := s.RunningCopy()
+
+	atomic.StoreUint32(&s.Stats.RunningServers, uint32(len(nodes)))
+
+	if len(nodes) == 0 {
+		atomic.StoreUint32(&s.Stats.IsHealthy, 0)
+		return
+	}
+
+	alarms, err := s.alarmList(nodes)
+	if err != nil {
+		log.Errorf("Failed to list etcd alarms: %v", err)
+	} else {
+		noSpace := uint32(0)
+		for _, alarm := range alarms {
+			log.Errorf("etcd alarm %s is active on member %s",
+				alarm.Alarm, alarm.MemberID)
+			if alarm.Alarm == rpc.AlarmNoSpace {
+				noSpace = 1
+			}
+		}
+		s.mut.Lock()
+		s.alarms = alarms
+		s.mut.Unlock()
+		atomic.StoreUint32(&s.Stats.ActiveAlarms, uint32(len(alarms)))
+		atomic.StoreUint32(&s.Stats.NoSpaceAlarm, noSpace)
+	}
 
-		atomic.StoreUint32(&s.Stats.RunningServers, uint32(len(nodes)))
+	s.refreshVersions(nodes)
 
-		if len(nodes) == 0 {
-			atomic.StoreUint32(&s.Stats.IsHealthy, 0)
+	err = s.healthCheck(nodes)
+	if err != nil || atomic.LoadUint32(&s.Stats.NoSpaceAlarm) == 1 {
+		atomic.StoreUint32(&s.Stats.IsHealthy, 0)
+	} else {
+		atomic.StoreUint32(&s.Stats.IsHealthy, 1)
+	}
+}
+
+// refreshVersions records the etcd version reported by each member, and
+// warns when members disagree, as a mixed-version cluster should only exist
+// for the duration of an upgrade.
+func (s *EtcdScheduler) refreshVersions(nodes map[string]*config.Node) {
+	versions := s.memberVersions(nodes)
+
+	s.mut.Lock()
+	for name, version := range versions {
+		node, present := s.running[name]
+		if !present || node.EtcdVersion == version {
 			continue
 		}
+		// Replace rather than modify the node, as copies of the running
+		// map share it.
+		updated := *node
+		updated.EtcdVersion = version
+		s.running[name] = &updated
+	}
+	s.mut.Unlock()
+
+	distinct := map[string][]string{}
+	for name, version := range versions {
+		distinct[version] = append(distinct[version], name)
+	}
+	if len(distinct) > 1 {
+		log.Warningf("etcd members are running mixed versions: %v", distinct)
+		atomic.StoreUint32(&s.Stats.MixedVersions, 1)
+	} else {
+		atomic.StoreUint32(&s.Stats.MixedVersions, 0)
+	}
+}
+
+// PeriodicDefragmenter defragments the cluster every DefragInterval.  A
+// zero interval disables periodic defragmentation.
+func (s *EtcdScheduler) PeriodicDefragmenter() {
+	if s.DefragInterval <= 0 {
+		return
+	}
+	for {
+		s.clock.Sleep(s.DefragInterval)
+		s.defragCluster()
+	}
+}
+
+// defragCluster defragments one member at a time, leader last, as a
+// member is unavailable while it defragments.  It stops as soon as the
+// cluster fails a health check, so that it never takes down a member of
+// an already degraded cluster.
+func (s *EtcdScheduler) defragCluster() {
+	if !atomic.CompareAndSwapInt32(&s.defragging, 0, 1) {
+		log.Info("Defrag already underway.")
+		return
+	}
+	defer atomic.StoreInt32(&s.defragging, 0)
+
+	ctx, op := s.operations.start("defrag")
+	defer s.operations.finish(op.ID)
+
+	running := s.RunningCopy()
+	for _, node := range s.defragOrder(running) {
+		if ctx.Err() != nil {
+			log.Warningf("Defrag operation %s cancelled.", op.ID)
+			return
+		}
+		if atomic.LoadInt32(&s.reseeding) == reseedUnderway {
+			log.Warning("Reseed underway, abandoning defrag.")
+			return
+		}
+		if err := s.healthCheck(running); err != nil {
+			log.Errorf("Cluster failed health check, abandoning defrag "+
+				"before %s: %v", node.Name, err)
+			return
+		}
+		if err := s.defragment(node); err != nil {
+			log.Errorf("Abandoning defrag after failure on %s: %v", node.Name, err)
+			return
+		}
+	}
+	log.Info("Defragmented all members.")
+}
 
-		err := s.healthCheck(nodes)
+// PeriodicCompactor compacts the keyspace history every CompactInterval,
+// keeping the last CompactRetention revisions.  A zero interval disables
+// periodic compaction.
+func (s *EtcdScheduler) PeriodicCompactor() {
+	if s.CompactInterval <= 0 {
+		return
+	}
+	for {
+		s.clock.Sleep(s.CompactInterval)
+		if _, err := s.compactCluster(0); err != nil {
+			log.Errorf("Periodic compaction failed: %v", err)
+		}
+	}
+}
+
+// compactCluster discards keyspace history before revision rev, or when rev
+// is zero, all but the last CompactRetention revisions.  It returns the
+// revision compacted to, which is zero if there was nothing to compact.
+func (s *EtcdScheduler) compactCluster(rev int64) (int64, error) {
+	running := s.RunningCopy()
+	if rev <= 0 {
+		current, err := s.currentRevision(running)
 		if err != nil {
-			atomic.StoreUint32(&s.Stats.IsHealthy, 0)
-		} else {
-			atomic.StoreUint32(&s.Stats.IsHealthy, 1)
+			return 0, err
 		}
+		if rev = current - s.CompactRetention; rev <= 0 {
+			log.V(2).Infof("Revision %d is within the retention of %d, "+
+				"not compacting.", current, s.CompactRetention)
+			return 0, nil
+		}
+	}
+	if err := s.compact(running, rev); err != nil {
+		return 0, err
+	}
+	atomic.StoreInt64(&s.Stats.LastCompactRev, rev)
+	atomic.StoreUint32(&s.Stats.LastCompactTime, uint32(s.clock.Now().Unix()))
+	log.Infof("Compacted the cluster to revision %d.", rev)
+	return rev, nil
+}
+
+// offerReapInterval is how often cached offers are checked for expiry.
+const offerReapInterval = time.Second
+
+// PeriodicOfferReaper declines cached offers that have gone unused for half
+// the chill period, so that Mesos may offer the resources elsewhere.
+func (s *EtcdScheduler) PeriodicOfferReaper(driver scheduler.SchedulerDriver) {
+	for {
+		s.clock.Sleep(offerReapInterval)
+		s.reapOffers(driver)
+	}
+}
+
+// reapOffers declines the cached offers that have expired.
+func (s *EtcdScheduler) reapOffers(driver scheduler.SchedulerDriver) {
+	ttl := s.chillSeconds / 2 * time.Second
+	for _, offer := range s.offerCache.Expire(s.clock.Now().Add(-ttl)) {
+		s.decline(driver, offer)
 	}
 }
 
 func (s *EtcdScheduler) PeriodicLaunchRequestor() {
 	for {
+		s.heartbeat(requestorHeartbeat, s.HeartbeatTimeout)
 		s.mut.RLock()
 		log.Infof(
 			"running instances: %d desired: %d offers: %d",
 			len(s.running), s.desiredInstanceCount, s.offerCache.Len(),
 		)
 		atomic.StoreUint32(&s.Stats.RunningServers, uint32(len(s.running)))
+		s.checkPendingAge()
 
 		if len(s.running) < s.desiredInstanceCount &&
 			s.state == Mutable {
@@ -685,15 +2292,46 @@ func (s *EtcdScheduler) PeriodicLaunchRequestor() {
 				"Immutable scheduler state.")
 		}
 		s.mut.RUnlock()
-		time.Sleep(5 * s.chillSeconds * time.Second)
+		interval := 5 * s.chillSeconds * time.Second
+		s.heartbeat(requestorHeartbeat, interval+s.HeartbeatTimeout)
+		s.clock.Sleep(interval)
+	}
+}
+
+// checkPendingAge records how long the oldest pending launch has waited to
+// report a status, warning if that exceeds PendingAgeWarning.  Not thread
+// safe!  Callers must hold s.mut.
+func (s *EtcdScheduler) checkPendingAge() {
+	oldest, age := "", time.Duration(0)
+	for name := range s.pending {
+		attempt, present := s.launchAttempts[name]
+		if !present {
+			continue
+		}
+		if since := s.clock.Since(attempt.launched); since > age {
+			oldest, age = name, since
+		}
+	}
+	atomic.StoreUint32(&s.Stats.OldestPendingSecs, uint32(age/time.Second))
+	if s.PendingAgeWarning > 0 && age > s.PendingAgeWarning {
+		log.Warningf("%s has been pending for %s without reporting a "+
+			"status, blocking further launches.", oldest, age)
 	}
 }
 
 func (s *EtcdScheduler) Prune() error {
+	if s.inSafeMode() {
+		log.V(2).Infoln("In safe mode, not pruning.")
+		return nil
+	}
 	s.mut.RLock()
 	defer s.mut.RUnlock()
+	if s.discovering {
+		log.V(2).Infoln("Cluster is forming through discovery, not pruning.")
+		return nil
+	}
 	if s.state == Mutable {
-		configuredMembers, err := rpc.MemberList(s.running)
+		configuredMembers, err := s.memberList(s.running)
 		if err != nil {
 			log.Errorf("Prune could not retrieve current member list: %s",
 				err)
@@ -701,12 +2339,15 @@ func (s *EtcdScheduler) Prune() error {
 		} else {
 			for k := range configuredMembers {
 				_, present := s.running[k]
-				if !present {
+				if !present && !s.isExternalSeed(k) {
 					_, pending := s.pending[k]
 					if !pending {
+						if !s.safeToPrune(k, len(configuredMembers)) {
+							return nil
+						}
 						log.Warningf("Prune attempting to deconfigure unknown etcd "+
 							"instance: %s", k)
-						if err := rpc.RemoveInstance(s.running, k); err != nil {
+						if err := s.removeInstance(s.running, k, s.RemoveRetries, !s.RemoveQuorumGuard); err != nil {
 							log.Errorf("Failed to remove instance: %s", err)
 						} else {
 							return nil
@@ -721,6 +2362,83 @@ func (s *EtcdScheduler) Prune() error {
 	return nil
 }
 
+// safeToPrune returns whether the scheduler's view of the cluster can be
+// trusted enough to deconfigure the member named, which it does not know
+// of.  Statuses must have arrived for every task being reconciled, lest
+// the member be one of them, and enough running members must be healthy
+// to keep quorum of the configured members, or PruneMinHealthy if higher,
+// without it.  The health requirement is waived when RemoveQuorumGuard is
+// off.  Not thread safe!  Callers must hold s.mut.
+func (s *EtcdScheduler) safeToPrune(name string, configured int) bool {
+	for taskID := range s.reconciliationInfo {
+		if _, heard := s.heardFrom[taskID]; !heard {
+			log.Warningf("Not pruning %s: reconciliation of %s is incomplete.",
+				name, taskID)
+			return false
+		}
+	}
+	if !s.RemoveQuorumGuard {
+		return true
+	}
+	required := configured/2 + 1
+	if s.PruneMinHealthy > required {
+		required = s.PruneMinHealthy
+	}
+	nodes := []*config.Node{}
+	for _, node := range s.running {
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	healthy := 0
+	for _, ok := range s.probeMembers(nodes) {
+		if ok {
+			healthy++
+		}
+	}
+	if healthy < required {
+		log.Warningf("Not pruning %s: only %d running members are healthy, "+
+			"and %d are required.", name, healthy, required)
+		return false
+	}
+	return true
+}
+
+// probeMembers returns whether each of nodes answers the health probe,
+// probing up to HealthCheckConcurrency of them at a time so that large
+// clusters are checked quickly without opening a connection to every
+// member at once.  A HealthCheckConcurrency of 0 probes them all at once.
+func (s *EtcdScheduler) probeMembers(nodes []*config.Node) []bool {
+	healthy := make([]bool, len(nodes))
+	limit := s.HealthCheckConcurrency
+	if limit <= 0 || limit > len(nodes) {
+		limit = len(nodes)
+	}
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, node *config.Node) {
+			defer wg.Done()
+			healthy[i] = s.memberHealthy(node)
+			<-slots
+		}(i, node)
+	}
+	wg.Wait()
+	return healthy
+}
+
+// chill returns how long SerialLauncher waits for the cluster to settle
+// after a launch or pause: chillSeconds, plus ChillPerMember for each
+// running member, so that larger clusters are given longer to settle.
+func (s *EtcdScheduler) chill() time.Duration {
+	s.mut.RLock()
+	running := len(s.running)
+	s.mut.RUnlock()
+	return s.chillSeconds*time.Second + time.Duration(running)*s.ChillPerMember
+}
+
 // SerialLauncher performs the launching of all tasks in a time-limited
 // way.  This helps to prevent misconfiguration by allowing time for state
 // to propagate.
@@ -731,33 +2449,72 @@ func (s *EtcdScheduler) SerialLauncher(driver scheduler.SchedulerDriver) {
 		for {
 			select {
 			case <-s.pauseChan:
-				log.V(2).Infof("SerialLauncher sleeping for %d seconds "+
-					"after receiving pause signal.", s.chillSeconds)
-				time.Sleep(s.chillSeconds * time.Second)
+				chill := s.chill()
+				log.V(2).Infof("SerialLauncher sleeping for %s "+
+					"after receiving pause signal.", chill)
+				s.heartbeat(launcherHeartbeat, chill+s.HeartbeatTimeout)
+				s.clock.Sleep(chill)
 			default:
 				goto FCFSPauseOrLaunch
 			}
 		}
 	FCFSPauseOrLaunch:
+		// Waiting for a launch request is not a sign of being wedged.
+		s.heartbeat(launcherHeartbeat, 0)
 		select {
 		case _, ok := <-s.launchChan:
 			if !ok {
 				return
 			}
+			s.heartbeat(launcherHeartbeat, s.HeartbeatTimeout)
 			s.launchOne(driver)
 
 			// Wait some time between launches to allow a cluster to settle.
-			log.V(2).Infof("SerialLauncher sleeping for %d seconds after "+
-				"launch attempt.", s.chillSeconds)
-			time.Sleep(s.chillSeconds * time.Second)
+			chill := s.chill()
+			log.V(2).Infof("SerialLauncher sleeping for %s after "+
+				"launch attempt.", chill)
+			s.heartbeat(launcherHeartbeat, chill+s.HeartbeatTimeout)
+			s.clock.Sleep(chill)
 		case <-s.pauseChan:
-			log.V(2).Infof("SerialLauncher sleeping for %d seconds "+
-				"after receiving pause signal.", s.chillSeconds)
-			time.Sleep(s.chillSeconds * time.Second)
+			chill := s.chill()
+			log.V(2).Infof("SerialLauncher sleeping for %s "+
+				"after receiving pause signal.", chill)
+			s.heartbeat(launcherHeartbeat, chill+s.HeartbeatTimeout)
+			s.clock.Sleep(chill)
 		}
 	}
 }
 
+// expirePendingLaunches kills and forgets any pending task that has not
+// reported a status within LaunchTimeout, which would otherwise block all
+// further launches, and requests a replacement launch.
+func (s *EtcdScheduler) expirePendingLaunches(driver scheduler.SchedulerDriver) {
+	s.mut.Lock()
+	expired := []*mesos.TaskID{}
+	for name, attempt := range s.launchAttempts {
+		if s.clock.Since(attempt.launched) < s.LaunchTimeout {
+			continue
+		}
+		log.Errorf("Task %s has not reported a status within %s of "+
+			"being launched.  Killing it and launching a replacement.",
+			attempt.taskID.GetValue(), s.LaunchTimeout)
+		delete(s.pending, name)
+		delete(s.launchAttempts, name)
+		expired = append(expired, attempt.taskID)
+	}
+	// Not deferred, as the driver may call back into StatusUpdate.
+	s.mut.Unlock()
+
+	for _, taskID := range expired {
+		atomic.AddUint32(&s.Stats.FailedServers, 1)
+		if _, err := driver.KillTask(taskID); err != nil {
+			log.Errorf("Failed to kill timed out task %s: %s",
+				taskID.GetValue(), err)
+		}
+		s.QueueLaunchAttempt()
+	}
+}
+
 func (s *EtcdScheduler) shouldLaunch(driver scheduler.SchedulerDriver) bool {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
@@ -767,11 +2524,24 @@ func (s *EtcdScheduler) shouldLaunch(driver scheduler.SchedulerDriver) bool {
 		return false
 	}
 
+	if s.inSafeMode() {
+		log.Infoln("In safe mode, not launching a task.")
+		return false
+	}
+
 	if atomic.LoadInt32(&s.reseeding) == reseedUnderway {
 		log.Infoln("Currently reseeding, not launching a task.")
 		return false
 	}
 
+	// Give a freshly reseeded cluster time to settle before adding
+	// members to it, or the joins may livelock it all over again.
+	if cooldown := s.ReseedCooldown - s.clock.Since(s.lastReseed); cooldown > 0 {
+		log.Infof("Cluster was reseeded recently, not launching a task "+
+			"for another %s.", cooldown)
+		return false
+	}
+
 	if len(s.pending) != 0 {
 		log.Infoln("Waiting on pending task to fail or submit status. " +
 			"Not launching until we hear back.")
@@ -784,12 +2554,34 @@ func (s *EtcdScheduler) shouldLaunch(driver scheduler.SchedulerDriver) bool {
 		return false
 	}
 
-	members, err := rpc.MemberList(s.running)
+	// Replacements launched by a rolling upgrade briefly take the cluster
+	// one past its size, which the cap allows; it would never finish
+	// otherwise with a cap equal to the cluster size.
+	count := len(s.running) + len(s.pending) + 1 - s.surge
+	if err := s.CheckInstanceCount(count); err != nil {
+		log.Errorf("Not launching a task: %s", err)
+		return false
+	}
+
+	// Members bootstrapping through discovery are not served by etcd
+	// until enough of them have registered, so launch the rest without
+	// consulting the cluster.
+	if s.discovering {
+		log.Infoln("Cluster is forming through discovery, launching a member.")
+		return true
+	}
+
+	members, err := s.memberList(s.running)
 	if err != nil {
 		log.Errorf("Failed to retrieve running member list, "+
 			"rescheduling launch attempt for later: %s", err)
 		return false
 	}
+	for name := range members {
+		if s.isExternalSeed(name) {
+			delete(members, name)
+		}
+	}
 	if len(members) == s.desiredInstanceCount {
 		log.Errorf("Cluster is already configured for desired number of nodes.  " +
 			"Must deconfigure any dead nodes first or we may risk livelock.")
@@ -810,12 +2602,21 @@ func (s *EtcdScheduler) shouldLaunch(driver scheduler.SchedulerDriver) bool {
 	}
 
 	err = s.healthCheck(s.running)
+	if errors.Is(err, etcderrors.ErrUnreachable) {
+		// Members that are down are replaced as their tasks terminate,
+		// whereas reseeding is for a cluster that is up but livelocked,
+		// so this neither starts nor ends a livelock window.
+		atomic.StoreUint32(&s.Stats.IsHealthy, 0)
+		log.Errorf("No member could be reached for a health check, "+
+			"rescheduling launch attempt for later: %s", err)
+		return false
+	}
 	if err != nil {
 		atomic.StoreUint32(&s.Stats.IsHealthy, 0)
 		atomic.AddUint32(&s.Stats.ClusterLivelocks, 1)
 		// If we have been unhealthy for reseedTimeout seconds, it's time to reseed.
 		if s.livelockWindow != nil {
-			if time.Since(*s.livelockWindow) > s.reseedTimeout {
+			if s.clock.Since(*s.livelockWindow) > s.reseedTimeout {
 				log.Errorf("Cluster has been livelocked for longer than %d seconds!",
 					s.reseedTimeout/time.Second)
 				if s.autoReseedEnabled {
@@ -830,23 +2631,31 @@ func (s *EtcdScheduler) shouldLaunch(driver scheduler.SchedulerDriver) bool {
 				return false
 			}
 		} else {
-			now := time.Now()
+			now := s.clock.Now()
 			s.livelockWindow = &now
+			atomic.StoreInt64(&s.livelockSince, now.UnixNano())
 		}
 
 		log.Errorf("Failed health check, rescheduling "+
 			"launch attempt for later: %s", err)
 		return false
 	}
-	atomic.StoreUint32(&s.Stats.IsHealthy, 1)
+	if atomic.LoadUint32(&s.Stats.NoSpaceAlarm) == 0 {
+		atomic.StoreUint32(&s.Stats.IsHealthy, 1)
+	}
 
 	// reset livelock window because we're healthy
 	s.livelockWindow = nil
+	atomic.StoreInt64(&s.livelockSince, 0)
 	return true
 }
 
 // TODO(tyler) split this long function up!
 func (s *EtcdScheduler) launchOne(driver scheduler.SchedulerDriver) {
+	// Give up on launches that never reported back, as they would
+	// otherwise wedge shouldLaunch forever.
+	s.expirePendingLaunches(driver)
+
 	// Always ensure we've pruned any dead / unmanaged nodes before
 	// launching new ones, or we may overconfigure the ensemble such
 	// that it can not make progress if the next launch fails.
@@ -865,22 +2674,34 @@ func (s *EtcdScheduler) launchOne(driver scheduler.SchedulerDriver) {
 	// desirable, even though they may have been when
 	// they were enqueued.
 	validOffer := func(offer *mesos.Offer) bool {
-		runningCopy := s.RunningCopy()
-		for _, etcdConfig := range runningCopy {
-			if etcdConfig.SlaveID == offer.SlaveId.GetValue() {
-				if s.singleInstancePerSlave {
-					log.Info("Skipping offer: already running on this slave.")
-					return false
-				}
-			}
+		s.mut.RLock()
+		defer s.mut.RUnlock()
+		if s.singleInstancePerSlave && s.usingSlave(offer) {
+			log.Info("Skipping offer: already running on this slave.")
+			return false
 		}
 		return true
 	}
 
-	// Issue BlockingPop until we get back an offer we can use.
+	// Prefer an offer carrying a lost member's persistent volume, so that
+	// its replacement can reuse its data, and then one from a slave not
+	// hosting another etcd cluster.  Otherwise issue BlockingPop until we
+	// get back an offer we can use.
 	var offer *mesos.Offer
 	for {
-		offer = s.offerCache.BlockingPop()
+		offer = s.offerCache.PopMatching(s.offersLostVolume)
+		if offer == nil && s.ClusterAttribute != "" {
+			offer = s.offerCache.PopMatching(func(o *mesos.Offer) bool {
+				return !s.hostsOtherCluster(o)
+			})
+		}
+		if offer == nil {
+			// Offers may be a long time coming, which is not a sign of
+			// the launcher being wedged.
+			s.heartbeat(launcherHeartbeat, 0)
+			offer = s.offerCache.BlockingPop()
+			s.heartbeat(launcherHeartbeat, s.HeartbeatTimeout)
+		}
 		if validOffer(offer) {
 			break
 		} else {
@@ -895,9 +2716,28 @@ func (s *EtcdScheduler) launchOne(driver scheduler.SchedulerDriver) {
 		return
 	}
 
+	// The offer may have lost its ports since it was cached, and the layout
+	// below carves every port out of the first range, so check that it is
+	// still big enough rather than indexing into nothing.
+	resources, err := parseOffer(offer)
+	if err != nil {
+		log.Warningf("Offer %s is malformed, declining it: %v",
+			offer.GetId().GetValue(), err)
+		s.decline(driver, offer)
+		s.QueueLaunchAttempt()
+		return
+	}
+	if len(resources.ports) == 0 ||
+		*resources.ports[0].End-*resources.ports[0].Begin+1 < portsPerTask+executorWantsPorts {
+		log.Warningf("Offer %s no longer has the ports needed for a launch, declining it.",
+			offer.GetId().GetValue())
+		s.decline(driver, offer)
+		s.QueueLaunchAttempt()
+		return
+	}
+
 	// TODO(tyler) this is a broken hack; task gets low ports, executor gets high ports
 	var (
-		resources      = parseOffer(offer)
 		lowest         = *resources.ports[0].Begin
 		rpcPort        = lowest
 		clientPort     = lowest + 1
@@ -906,8 +2746,25 @@ func (s *EtcdScheduler) launchOne(driver scheduler.SchedulerDriver) {
 	)
 
 	s.mut.Lock()
+	// Re-check the slave now that we hold the write lock, as a status update
+	// or another launch may have claimed it since validOffer ran.
+	if s.singleInstancePerSlave && s.usingSlave(offer) {
+		log.Info("Skipping offer: slave was claimed while we waited.")
+		s.decline(driver, offer)
+		s.mut.Unlock()
+		return
+	}
+
+	// With an external seed, even the first instance joins the existing
+	// etcd cluster outside of Mesos rather than starting a new one.
 	var clusterType string
-	if len(s.running) == 0 {
+	if len(s.running) == 0 && len(s.ExternalSeed) == 0 {
+		clusterType = "new"
+		if s.DiscoveryURL != "" && !s.discoveryUsed {
+			s.discovering = true
+			s.discoveryUsed = true
+		}
+	} else if s.discovering {
 		clusterType = "new"
 	} else {
 		clusterType = "existing"
@@ -924,11 +2781,48 @@ func (s *EtcdScheduler) launchOne(driver scheduler.SchedulerDriver) {
 		ReseedPort: httpPort,
 		Type:       clusterType,
 		SlaveID:    offer.GetSlaveId().GetValue(),
+
+		QuotaBackendBytes:       s.QuotaBackendBytes,
+		AutoCompactionRetention: s.AutoCompactionRetention,
+		LeaderStableSeconds:     int(s.LeaderStableWindow / time.Second),
+		KillGracePeriodSeconds:  int(s.KillGracePeriod / time.Second),
+		ClusterToken:            s.ClusterToken,
+		Zone:                    textAttribute(offer, s.ZoneAttribute),
+	}
+	if s.DataHostPath != "" {
+		node.DataDir = hostDataContainerPath
+	}
+	if s.discovering {
+		node.Discovery = s.DiscoveryURL
+	}
+	if s.EtcdServerCertFile != "" {
+		node.ClientScheme = "https"
+		node.CertFile = s.EtcdServerCertFile
+		node.KeyFile = s.EtcdServerKeyFile
+		node.TrustedCAFile = s.EtcdServerCAFile
+		node.ClientCertFile = s.EtcdExecutorCertFile
+		node.ClientKeyFile = s.EtcdExecutorKeyFile
+	}
+	if node.ClusterToken == "" {
+		node.ClusterToken = config.ClusterToken(s.FrameworkName)
+	}
+	volume := s.persistentVolume(offer)
+	reclaimed := []string{}
+	if volume != nil {
+		id := volume.GetDisk().GetPersistence().GetId()
+		log.Infof("Launching %s with persistent volume %s.", node.Name, id)
+		node.DataDir = volume.GetDisk().GetVolume().GetContainerPath()
+		s.volumes[node.Name] = []string{id}
+		if _, lost := s.lostVolumes[id]; lost {
+			reclaimed = append(reclaimed, id)
+			delete(s.lostVolumes, id)
+		}
 	}
 	running := []*config.Node{node}
 	for _, r := range s.running {
 		running = append(running, r)
 	}
+	running = append(running, s.ExternalSeed...)
 	serializedNodes, err := json.Marshal(running)
 	log.Infof("Serialized running: %+v", string(serializedNodes))
 	if err != nil {
@@ -943,6 +2837,12 @@ func (s *EtcdScheduler) launchOne(driver scheduler.SchedulerDriver) {
 	configSummary := node.String()
 	taskID := &mesos.TaskID{Value: &configSummary}
 	executor := s.newExecutorInfo(node, s.executorUris, libprocessPort)
+	// etcd's data goes on the persistent volume, if there is one, in place
+	// of unreserved sandbox disk.
+	disk := util.NewScalarResource("disk", s.diskPerTask)
+	if volume != nil {
+		disk = volume
+	}
 	task := &mesos.TaskInfo{
 		Data:     serializedNodes,
 		Name:     proto.String("etcd-server"),
@@ -952,7 +2852,7 @@ func (s *EtcdScheduler) launchOne(driver scheduler.SchedulerDriver) {
 		Resources: []*mesos.Resource{
 			util.NewScalarResource("cpus", s.cpusPerTask),
 			util.NewScalarResource("mem", s.memPerTask),
-			util.NewScalarResource("disk", s.diskPerTask),
+			disk,
 			util.NewRangesResource("ports", []*mesos.Value_Range{
 				util.NewValueRange(uint64(rpcPort), uint64(rpcPort+portsPerTask-1)),
 			}),
@@ -981,40 +2881,294 @@ func (s *EtcdScheduler) launchOne(driver scheduler.SchedulerDriver) {
 		},
 	}
 
-	log.Infof(
-		"Prepared task: %s with offer %s for launch",
-		task.GetName(),
-		offer.Id.GetValue(),
-	)
-	log.Info("Launching etcd node.")
+	log.Infof(
+		"Prepared task: %s with offer %s for launch",
+		task.GetName(),
+		offer.Id.GetValue(),
+	)
+	log.Info("Launching etcd node.")
+
+	tasks := []*mesos.TaskInfo{task}
+
+	// Reserve the slave for this launch until we hear back about the task.
+	s.pending[node.Name] = node.SlaveID
+	s.launchAttempts[node.Name] = launchAttempt{
+		taskID:    taskID,
+		launched:  s.clock.Now(),
+		version:   s.Version,
+		host:      node.Host,
+		reclaimed: reclaimed,
+		node:      node,
+	}
+
+	// Only the first member of a new cluster starts a bootstrap; replacing
+	// a member of an established cluster must not put it on a deadline.
+	if clusterType == "new" && len(s.running) == 0 {
+		s.armBootstrapDeadline()
+	}
+
+	// This Unlock is not deferred because the test implementation of LaunchTasks
+	// calls this scheduler's StatusUpdate method, causing the test to deadlock.
+	s.mut.Unlock()
+
+	status, err := driver.LaunchTasks(
+		[]*mesos.OfferID{offer.Id},
+		tasks,
+		&mesos.Filters{
+			RefuseSeconds: proto.Float64(1),
+		},
+	)
+	if err != nil || status != mesos.Status_DRIVER_RUNNING {
+		s.launchRejected(node.Name, taskID, status, err)
+	}
+}
+
+// launchRejected forgets a launch that the driver failed to send to the
+// master, which would otherwise hold its slave until LaunchTimeout as no
+// status will ever arrive for it, and requests another attempt.  Callers
+// must not hold s.mut.
+func (s *EtcdScheduler) launchRejected(
+	name string,
+	taskID *mesos.TaskID,
+	status mesos.Status,
+	err error,
+) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	attempt, present := s.launchAttempts[name]
+	if !present || attempt.taskID.GetValue() != taskID.GetValue() {
+		// A status already arrived for it after all.
+		return
+	}
+	log.Errorf("Launch of task %s was rejected, driver status %s: %v.  "+
+		"Requesting another launch.", taskID.GetValue(), status, err)
+	delete(s.pending, name)
+	delete(s.launchAttempts, name)
+	// The task never started, so its volume is free for the next launch.
+	delete(s.volumes, name)
+	for _, id := range attempt.reclaimed {
+		s.lostVolumes[id] = struct{}{}
+	}
+	s.QueueLaunchAttempt()
+}
+
+// confirmLaunch counts a launch we made in LaunchedServers the first time
+// its task is reported staging or beyond.  Not thread safe!  Callers must
+// hold s.mut.
+func (s *EtcdScheduler) confirmLaunch(name string) {
+	attempt, present := s.launchAttempts[name]
+	if !present || attempt.confirmed {
+		return
+	}
+	attempt.confirmed = true
+	s.launchAttempts[name] = attempt
+	atomic.AddUint32(&s.Stats.LaunchedServers, 1)
+}
+
+func (s *EtcdScheduler) AdminHTTP(port int, driver scheduler.SchedulerDriver) {
+	listener, err := s.listenAdmin(port)
+	if err == nil {
+		log.Infof("Admin HTTP interface Listening on %s", listener.Addr())
+		err = http.Serve(listener, s.AdminHandler(driver))
+	} else if s.AdminBindFailure == AdminBindContinue {
+		log.Errorf("Running without the admin HTTP interface: %s", err)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+	}
+	if s.shutdown != nil {
+		s.shutdown()
+	}
+}
+
+// AdminHandler returns the admin HTTP interface that AdminHTTP serves, for
+// callers embedding the scheduler in-process that serve it themselves.
+// Responses are gzipped for clients that accept it.
+func (s *EtcdScheduler) AdminHandler(driver scheduler.SchedulerDriver) http.Handler {
+	return gzipHandler(s.adminMux(driver))
+}
+
+// listenAdmin binds the admin port, handling a failure according to
+// AdminBindFailure.
+func (s *EtcdScheduler) listenAdmin(port int) (net.Listener, error) {
+	address := fmt.Sprintf(":%d", port)
+	listener, err := net.Listen("tcp", address)
+	switch s.AdminBindFailure {
+	case AdminBindRetry:
+		backoff := 1
+		for retries := 0; err != nil && retries < rpc.RPC_RETRIES; retries++ {
+			log.Warningf("Could not bind admin port %d, retrying: %s", port, err)
+			s.clock.Sleep(time.Duration(backoff) * time.Second)
+			backoff = int(math.Min(float64(backoff<<1), 8))
+			listener, err = net.Listen("tcp", address)
+		}
+	case AdminBindEphemeral:
+		if err != nil {
+			log.Warningf("Could not bind admin port %d, using an "+
+				"ephemeral port instead: %s", port, err)
+			listener, err = net.Listen("tcp", ":0")
+		}
+	}
+	return listener, err
+}
+
+// MemberContact is when a running member last answered a request from the
+// scheduler, served at /last-contact.  LastContact is nil, and
+// SecondsSince -1, for a member that never has.
+type MemberContact struct {
+	LastContact  *time.Time `json:"last_contact"`
+	SecondsSince int64      `json:"seconds_since"`
+}
+
+// memberContacts returns when each running member was last contacted, so
+// that a member the scheduler can't reach stands out even while the
+// cluster reports healthy.
+func (s *EtcdScheduler) memberContacts() map[string]MemberContact {
+	contacts := map[string]MemberContact{}
+	for name, node := range s.RunningCopy() {
+		at := s.lastContact(node)
+		if at.IsZero() {
+			contacts[name] = MemberContact{SecondsSince: -1}
+			continue
+		}
+		contacts[name] = MemberContact{
+			LastContact:  &at,
+			SecondsSince: int64(s.clock.Since(at) / time.Second),
+		}
+	}
+	return contacts
+}
+
+// DebugState is the diagnostic view of the scheduler's bookkeeping served
+// at /debug/state.
+type DebugState struct {
+	HighestInstanceID int64                   `json:"highest_instance_id"`
+	Pending           map[string]string       `json:"pending"`
+	Running           map[string]*config.Node `json:"running"`
+	Tasks             map[string]string       `json:"tasks"`
+	Alarms            []rpc.Alarm             `json:"alarms"`
+}
+
+// debugState copies the scheduler's naming bookkeeping under a single
+// hold of the lock so that the maps are consistent with each other.
+func (s *EtcdScheduler) debugState() DebugState {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	state := DebugState{
+		HighestInstanceID: s.highestInstanceID,
+		Pending:           map[string]string{},
+		Running:           map[string]*config.Node{},
+		Tasks:             map[string]string{},
+		Alarms:            append([]rpc.Alarm{}, s.alarms...),
+	}
+	for name, slaveID := range s.pending {
+		state.Pending[name] = slaveID
+	}
+	for name, node := range s.running {
+		if node != nil {
+			nodeCopy := *node
+			state.Running[name] = &nodeCopy
+		}
+	}
+	for name, taskID := range s.tasks {
+		state.Tasks[name] = taskID.GetValue()
+	}
+	return state
+}
+
+// FrameworkState identifies the framework registration and the master it
+// is registered with, served at /framework.
+type FrameworkState struct {
+	FrameworkID   string `json:"framework_id"`
+	FrameworkName string `json:"framework_name"`
+	MasterID      string `json:"master_id"`
+	MasterHost    string `json:"master_host"`
+	MasterPort    uint32 `json:"master_port"`
+	ZkPath        string `json:"zk_path,omitempty"`
+}
 
-	tasks := []*mesos.TaskInfo{task}
+func (s *EtcdScheduler) frameworkState() FrameworkState {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	state := FrameworkState{
+		FrameworkID:   s.frameworkID.GetValue(),
+		FrameworkName: s.FrameworkName,
+		MasterID:      s.masterInfo.GetId(),
+		MasterHost:    s.masterInfo.GetHostname(),
+		MasterPort:    s.masterInfo.GetPort(),
+	}
+	if s.ZkConnect != "" {
+		state.ZkPath = rpc.FrameworkIDPath(s.ZkChroot, s.FrameworkName)
+	}
+	return state
+}
 
-	s.pending[node.Name] = struct{}{}
+// adminEndpoint describes an admin HTTP endpoint for the index served at /.
+type adminEndpoint struct {
+	Path        string   `json:"path"`
+	Methods     []string `json:"methods"`
+	Description string   `json:"description"`
+}
 
-	// This Unlock is not deferred because the test implementation of LaunchTasks
-	// calls this scheduler's StatusUpdate method, causing the test to deadlock.
-	s.mut.Unlock()
+// adminNotFound is the body returned for unknown admin paths.
+type adminNotFound struct {
+	Error     string          `json:"error"`
+	Path      string          `json:"path"`
+	Endpoints []adminEndpoint `json:"endpoints"`
+}
 
-	atomic.AddUint32(&s.Stats.LaunchedServers, 1)
-	driver.LaunchTasks(
-		[]*mesos.OfferID{offer.Id},
-		tasks,
-		&mesos.Filters{
-			RefuseSeconds: proto.Float64(1),
-		},
-	)
+var adminEndpoints = []adminEndpoint{
+	{"/", []string{"GET"}, "web interface, or this list with Accept: application/json"},
+	{"/stats", []string{"GET"}, "scheduler statistics"},
+	{"/members", []string{"GET"}, "running etcd members"},
+	{"/endpoints", []string{"GET"}, "client URLs of running members for etcdctl --endpoints, with healthy=true only healthy ones"},
+	{"/healthz", []string{"GET"}, "200 if the cluster is healthy and the scheduler is not wedged"},
+	{"/ready", []string{"GET"}, "200 if the cluster is healthy and has quorum"},
+	{"/reseed", []string{"GET", "POST"}, "reseed the cluster; use extreme caution"},
+	{"/defrag", []string{"POST"}, "defragment members one at a time"},
+	{"/compact", []string{"POST"}, "discard history before rev, or beyond the retention without it"},
+	{"/snapshot", []string{"GET"}, "download a snapshot of a member's backend"},
+	{"/kill", []string{"POST"}, "kill the member named by node without deconfiguring it"},
+	{"/force-remove", []string{"POST"}, "deconfigure the member named by node ignoring quorum"},
+	{"/single-instance-per-slave", []string{"GET", "POST"}, "show or set, with enabled, whether members share slaves"},
+	{"/rolling-upgrade", []string{"POST"}, "replace members not at version one at a time"},
+	{"/operations", []string{"GET"}, "in-flight long-running operations"},
+	{"/operations/{id}", []string{"DELETE"}, "cancel an operation"},
+	{"/framework", []string{"GET"}, "framework ID, current master, and ZK path of the framework ID"},
+	{"/debug/state", []string{"GET"}, "internal naming bookkeeping"},
+	{"/last-contact", []string{"GET"}, "when each running member last answered the scheduler"},
+	{"/unsafe", []string{"POST"}, "leave safe mode, allowing members to be launched and removed"},
 }
 
-func (s *EtcdScheduler) AdminHTTP(port int, driver scheduler.SchedulerDriver) {
+func (s *EtcdScheduler) adminMux(driver scheduler.SchedulerDriver) *http.ServeMux {
 	mux := http.NewServeMux()
 
-	// index.html implicitly served at /
+	// index.html implicitly served at /, unless the client asks for the
+	// JSON list of endpoints.  Anything else unknown gets a JSON 404.
 	index := http.FileServer(http.Dir("static"))
-	mux.Handle("/", index)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		log.V(2).Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		switch {
+		case r.URL.Path == "/" &&
+			strings.Contains(r.Header.Get("Accept"), "application/json"):
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(adminEndpoints)
+		case r.URL.Path == "/" || r.URL.Path == "/index.html":
+			index.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(adminNotFound{
+				Error:     "404 not found",
+				Path:      r.URL.Path,
+				Endpoints: adminEndpoints,
+			})
+		}
+	})
 	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
 		log.V(2).Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
-		serializedStats, err := json.Marshal(s.Stats)
+		serializedStats, err := json.Marshal(s.StatsCopy())
 		if err != nil {
 			log.Errorf("Failed to marshal stats json: %v", err)
 		}
@@ -1022,7 +3176,7 @@ func (s *EtcdScheduler) AdminHTTP(port int, driver scheduler.SchedulerDriver) {
 	})
 	mux.HandleFunc("/reseed", func(w http.ResponseWriter, r *http.Request) {
 		log.Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
-		go s.reseedCluster(driver)
+		go s.manualReseed(driver)
 		fmt.Fprint(w, string("reseeding"))
 	})
 	mux.HandleFunc("/members", func(w http.ResponseWriter, r *http.Request) {
@@ -1037,45 +3191,334 @@ func (s *EtcdScheduler) AdminHTTP(port int, driver scheduler.SchedulerDriver) {
 		}
 		fmt.Fprint(w, string(serializedNodes))
 	})
+	mux.HandleFunc("/endpoints", func(w http.ResponseWriter, r *http.Request) {
+		log.V(2).Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		healthyOnly, _ := strconv.ParseBool(r.FormValue("healthy"))
+		endpoints := s.clientEndpoints(healthyOnly)
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(endpoints)
+			return
+		}
+		fmt.Fprintln(w, strings.Join(endpoints, ","))
+	})
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		log.V(2).Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
-		if atomic.LoadUint32(&s.Stats.IsHealthy) == 1 {
+		if stale := s.staleGoroutines(); len(stale) > 0 {
+			http.Error(w, "500 internal server error: scheduler degraded, "+
+				strings.Join(stale, ", ")+".", http.StatusInternalServerError)
+		} else if atomic.LoadUint32(&s.Stats.IsHealthy) == 1 {
 			fmt.Fprintf(w, "cluster is healthy\n")
 		} else {
 			http.Error(w, "500 internal server error: cluster not healthy.",
 				http.StatusInternalServerError)
 		}
 	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		log.V(2).Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		if running, quorum := s.quorumStatus(); running >= quorum &&
+			atomic.LoadUint32(&s.Stats.IsHealthy) == 1 {
+			fmt.Fprintf(w, "cluster is ready: %d of %d required members running\n",
+				running, quorum)
+		} else {
+			http.Error(w, fmt.Sprintf("503 service unavailable: cluster not ready, "+
+				"%d of %d required members running.", running, quorum),
+				http.StatusServiceUnavailable)
+		}
+	})
+	mux.HandleFunc("/defrag", func(w http.ResponseWriter, r *http.Request) {
+		log.Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		if r.Method != "POST" {
+			http.Error(w, "405 method not allowed: use POST to defragment.",
+				http.StatusMethodNotAllowed)
+			return
+		}
+		go s.defragCluster()
+		fmt.Fprint(w, string("defragmenting"))
+	})
+	mux.HandleFunc("/compact", func(w http.ResponseWriter, r *http.Request) {
+		log.Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		if r.Method != "POST" {
+			http.Error(w, "405 method not allowed: use POST to compact.",
+				http.StatusMethodNotAllowed)
+			return
+		}
+		var rev int64
+		if value := r.FormValue("rev"); value != "" {
+			var err error
+			if rev, err = strconv.ParseInt(value, 10, 64); err != nil || rev <= 0 {
+				http.Error(w, "400 bad request: rev must be a positive revision.",
+					http.StatusBadRequest)
+				return
+			}
+		}
+		rev, err := s.compactCluster(rev)
+		if err != nil {
+			http.Error(w, "500 internal server error: "+err.Error(),
+				http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "compacted to revision %d", rev)
+	})
+	mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		log.Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		name, snapshot, err := s.openSnapshot(s.RunningCopy())
+		if errors.Is(err, rpc.ErrNoRunningMembers) {
+			http.Error(w, "503 service unavailable: "+err.Error(),
+				http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, "500 internal server error: "+err.Error(),
+				http.StatusInternalServerError)
+			return
+		}
+		defer snapshot.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(
+			"attachment; filename=%q", fmt.Sprintf("%s-%s-%d.db",
+				s.FrameworkName, name, s.clock.Now().Unix())))
+		// Headers are already sent, so a failure partway through can
+		// only be logged, leaving the client with a truncated file.
+		if _, err := io.Copy(w, snapshot); err != nil {
+			log.Errorf("Snapshot of %s failed partway through: %v", name, err)
+		}
+	})
+	mux.HandleFunc("/kill", func(w http.ResponseWriter, r *http.Request) {
+		log.Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		if r.Method != "POST" {
+			http.Error(w, "405 method not allowed: use POST to kill.",
+				http.StatusMethodNotAllowed)
+			return
+		}
+		name := r.FormValue("node")
+		switch err := s.killMember(driver, name); err {
+		case nil:
+			fmt.Fprintf(w, "killing %s", name)
+		case errUnknownMember:
+			http.Error(w, "404 not found: "+err.Error(), http.StatusNotFound)
+		case errLastMember:
+			http.Error(w, "409 conflict: "+err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "500 internal server error: "+err.Error(),
+				http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/force-remove", func(w http.ResponseWriter, r *http.Request) {
+		log.Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		if r.Method != "POST" {
+			http.Error(w, "405 method not allowed: use POST to force remove.",
+				http.StatusMethodNotAllowed)
+			return
+		}
+		name := r.FormValue("node")
+		err := s.forceRemoveMember(driver, name, r.FormValue("confirm"))
+		switch {
+		case err == nil:
+			fmt.Fprintf(w, "force removed %s", name)
+		case err == errForceRemoveDisabled, err == errForceRemoveConfirm:
+			http.Error(w, "403 forbidden: "+err.Error(), http.StatusForbidden)
+		case errors.Is(err, rpc.ErrMemberNotFound):
+			http.Error(w, "404 not found: "+err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, "500 internal server error: "+err.Error(),
+				http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/unsafe", func(w http.ResponseWriter, r *http.Request) {
+		log.Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		if r.Method != "POST" {
+			http.Error(w, "405 method not allowed: use POST to leave safe mode.",
+				http.StatusMethodNotAllowed)
+			return
+		}
+		s.LeaveSafeMode()
+		fmt.Fprint(w, "left safe mode")
+	})
+	mux.HandleFunc("/single-instance-per-slave", func(w http.ResponseWriter, r *http.Request) {
+		log.Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		if r.Method == "POST" {
+			single, err := strconv.ParseBool(r.FormValue("enabled"))
+			if err != nil {
+				http.Error(w, "400 bad request: enabled must be true or false.",
+					http.StatusBadRequest)
+				return
+			}
+			s.SetSingleInstancePerSlave(driver, single)
+		}
+		fmt.Fprintf(w, "%t", s.SingleInstancePerSlave())
+	})
+	mux.HandleFunc("/rolling-upgrade", func(w http.ResponseWriter, r *http.Request) {
+		log.Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		if r.Method != "POST" {
+			http.Error(w, "405 method not allowed: use POST to upgrade.",
+				http.StatusMethodNotAllowed)
+			return
+		}
+		version := r.FormValue("version")
+		if version == "" {
+			http.Error(w, "400 bad request: version is required.",
+				http.StatusBadRequest)
+			return
+		}
+		var uris []*mesos.CommandInfo_URI
+		for _, value := range r.Form["uri"] {
+			uri, err := NewExecutorURI(value, s.FetcherCache)
+			if err != nil {
+				http.Error(w, "400 bad request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			uris = append(uris, uri)
+		}
+		// Claimed here rather than in the goroutine, so that a concurrent
+		// request is turned away rather than told it is upgrading.
+		if !s.claimUpgrade() {
+			http.Error(w, "409 conflict: "+errUpgradeUnderway.Error()+".",
+				http.StatusConflict)
+			return
+		}
+		go func() {
+			if err := s.runUpgrade(driver, version, uris); err != nil {
+				log.Errorf("Rolling upgrade to %s failed: %v", version, err)
+			}
+		}()
+		fmt.Fprintf(w, "upgrading to %s", version)
+	})
+	mux.HandleFunc("/operations", func(w http.ResponseWriter, r *http.Request) {
+		log.V(2).Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		serializedOperations, err := json.Marshal(s.operations.list())
+		if err != nil {
+			log.Errorf("Failed to marshal operations json: %v", err)
+		}
+		fmt.Fprint(w, string(serializedOperations))
+	})
+	mux.HandleFunc("/operations/", func(w http.ResponseWriter, r *http.Request) {
+		log.Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		if r.Method != "DELETE" {
+			http.Error(w, "405 method not allowed: use DELETE to cancel an operation.",
+				http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/operations/")
+		if !s.operations.cancel(id) {
+			http.Error(w, "404 not found: no such operation.", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/framework", func(w http.ResponseWriter, r *http.Request) {
+		log.V(2).Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		serializedState, err := json.Marshal(s.frameworkState())
+		if err != nil {
+			log.Errorf("Failed to marshal framework json: %v", err)
+		}
+		fmt.Fprint(w, string(serializedState))
+	})
+	mux.HandleFunc("/last-contact", func(w http.ResponseWriter, r *http.Request) {
+		log.V(2).Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		serializedContacts, err := json.Marshal(s.memberContacts())
+		if err != nil {
+			log.Errorf("Failed to marshal last contact json: %v", err)
+		}
+		fmt.Fprint(w, string(serializedContacts))
+	})
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		log.V(2).Infof("Admin HTTP received %s %s", r.Method, r.URL.Path)
+		serializedState, err := json.Marshal(s.debugState())
+		if err != nil {
+			log.Errorf("Failed to marshal debug state json: %v", err)
+		}
+		fmt.Fprint(w, string(serializedState))
+	})
+	return mux
+}
 
-	log.Infof("Admin HTTP interface Listening on port %d", port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
-	if err != nil {
-		log.Error(err)
+// clientEndpoints returns the client URLs of the running members, ordered by
+// member name, optionally leaving out members failing the health probe.
+func (s *EtcdScheduler) clientEndpoints(healthyOnly bool) []string {
+	running := s.RunningCopy()
+	names := make([]string, 0, len(running))
+	for name := range running {
+		names = append(names, name)
 	}
-	if s.shutdown != nil {
-		s.shutdown()
+	sort.Strings(names)
+	nodes := []*config.Node{}
+	for _, name := range names {
+		if node := running[name]; node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	var healthy []bool
+	if healthyOnly {
+		healthy = s.probeMembers(nodes)
+	}
+	endpoints := []string{}
+	for i, node := range nodes {
+		if healthyOnly && !healthy[i] {
+			continue
+		}
+		endpoints = append(endpoints, node.ClientURL())
 	}
+	return endpoints
+}
+
+// quorumStatus returns the number of running members along with the
+// majority of the desired cluster size needed for quorum.
+func (s *EtcdScheduler) quorumStatus() (running, quorum int) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return len(s.running), s.desiredInstanceCount/2 + 1
 }
 
 func (s *EtcdScheduler) reseedCluster(driver scheduler.SchedulerDriver) {
+	s.reseed(driver, false)
+}
+
+// manualReseed reseeds the cluster at an operator's request.  MaxReseeds is
+// meant to stop automatic reseeds of a flapping cluster, so it does not
+// apply, though the reseed counts toward it.
+func (s *EtcdScheduler) manualReseed(driver scheduler.SchedulerDriver) {
+	s.reseed(driver, true)
+}
+
+func (s *EtcdScheduler) reseed(driver scheduler.SchedulerDriver, manual bool) {
 	// This CAS allows us to:
 	//	1. ensure non-concurrent execution
 	//	2. signal to shouldLaunch that we're already reseeding
 	if !atomic.CompareAndSwapInt32(&s.reseeding, notReseeding, reseedUnderway) {
 		return
 	}
+	if s.inSafeMode() {
+		log.Warningln("In safe mode, refusing to reseed the cluster.")
+		atomic.StoreInt32(&s.reseeding, notReseeding)
+		return
+	}
+	if !s.allowReseed(manual) {
+		atomic.AddUint32(&s.Stats.ReseedsSuppressed, 1)
+		atomic.StoreInt32(&s.reseeding, notReseeding)
+		return
+	}
 	atomic.AddUint32(&s.Stats.ClusterReseeds, 1)
 
+	ctx, op := s.operations.start("reseed")
+	defer s.operations.finish(op.ID)
+
 	s.mut.Lock()
 	s.state = Immutable
 
 	defer func() {
 		s.state = Mutable
+		s.lastReseed = s.clock.Now()
 		atomic.StoreInt32(&s.reseeding, notReseeding)
 		s.mut.Unlock()
+		// Launches are refused during the cooldown, so retry once it ends.
+		go func() {
+			s.clock.Sleep(s.ReseedCooldown)
+			s.QueueLaunchAttempt()
+		}()
 	}()
 
-	candidates := rpc.RankReseedCandidates(s.running)
+	candidates := s.rankReseedCandidates(s.running)
 	if len(candidates) == 0 {
 		log.Error("Failed to retrieve any candidates for reseeding! " +
 			"No recovery possible!")
@@ -1086,6 +3529,10 @@ func (s *EtcdScheduler) reseedCluster(driver scheduler.SchedulerDriver) {
 	newSeed := ""
 	log.Infof("Candidates for reseed: %+v", candidates)
 	for _, node := range candidates {
+		if ctx.Err() != nil {
+			log.Warningf("Reseed operation %s cancelled.", op.ID)
+			return
+		}
 		// 1. restart node with --force-new-cluster
 		// 2. ensure it passes health check
 		// 3. ensure its member list only contains itself
@@ -1096,7 +3543,7 @@ func (s *EtcdScheduler) reseedCluster(driver scheduler.SchedulerDriver) {
 		} else {
 			log.Warningf("Attempting to re-seed cluster with candidate %s "+
 				"with Raft index %d!", node.Node, node.RaftIndex)
-			if s.reseedNode(node.Node, driver) {
+			if s.reseedNode(ctx, node.Node, driver) {
 				newSeed = node.Node
 				continue
 			}
@@ -1107,26 +3554,108 @@ func (s *EtcdScheduler) reseedCluster(driver scheduler.SchedulerDriver) {
 			killable = append(killable, node.Node)
 		}
 	}
+	if ctx.Err() != nil {
+		log.Warningf("Reseed operation %s cancelled.", op.ID)
+		return
+	}
 	if newSeed != "" {
 		log.Warningf("We think we have a new healthy leader: %s", newSeed)
 		log.Warning("Terminating stale members of previous cluster.")
+		killed := map[string]*mesos.TaskID{}
 		for node, taskID := range s.tasks {
 			if node != newSeed {
 				log.Warningf("Killing old node %s", node)
 				driver.KillTask(taskID)
+				killed[node] = taskID
 			}
 		}
+		s.awaitKills(ctx, killed)
+	}
+}
+
+// awaitKills waits up to ReseedKillTimeout for each of the killed tasks to
+// be reported terminal, so that a slow kill does not leave a member of the
+// previous cluster competing with the new seed once launches resume.
+// Callers must hold s.mut, which is released while waiting so that the
+// status updates can be processed.
+func (s *EtcdScheduler) awaitKills(
+	ctx context.Context,
+	killed map[string]*mesos.TaskID,
+) {
+	backoff := 1
+	before := s.clock.Now()
+	for {
+		alive := []string{}
+		for node, taskID := range killed {
+			if current, present := s.tasks[node]; present &&
+				current.GetValue() == taskID.GetValue() {
+				alive = append(alive, node)
+			}
+		}
+		if len(alive) == 0 {
+			return
+		}
+		if s.clock.Since(before) >= s.ReseedKillTimeout {
+			log.Errorf("Gave up waiting %s for the kills of old nodes %v "+
+				"to be confirmed.", s.ReseedKillTimeout, alive)
+			return
+		}
+		log.Warningf("Waiting for the kills of old nodes %v to be confirmed.", alive)
+		s.mut.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-s.clock.After(time.Duration(backoff) * time.Second):
+		}
+		s.mut.Lock()
+		if ctx.Err() != nil {
+			log.Warning("Stopped waiting for kills to be confirmed, as the " +
+				"reseed was cancelled.")
+			return
+		}
+		backoff = int(math.Min(float64(backoff<<1), 8))
+	}
+}
+
+// allowReseed records a reseed attempt unless MaxReseeds have already
+// happened within ReseedWindow, as a cluster that keeps flapping would
+// otherwise lose data to reseed after reseed.  A MaxReseeds of 0 removes
+// the limit, and manual reseeds are always allowed.  Callers must have
+// claimed s.reseeding.
+func (s *EtcdScheduler) allowReseed(manual bool) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	recent := []time.Time{}
+	for _, at := range s.reseedTimes {
+		if s.clock.Since(at) < s.ReseedWindow {
+			recent = append(recent, at)
+		}
+	}
+	s.reseedTimes = recent
+	if !manual && s.MaxReseeds > 0 && len(recent) >= s.MaxReseeds {
+		log.Errorf("Refusing to reseed: already reseeded %d times in the "+
+			"last %s.", len(recent), s.ReseedWindow)
+		return false
 	}
+	s.reseedTimes = append(s.reseedTimes, s.clock.Now())
+	return true
 }
 
-func (s *EtcdScheduler) reseedNode(node string, driver scheduler.SchedulerDriver) bool {
+func (s *EtcdScheduler) reseedNode(
+	ctx context.Context,
+	node string,
+	driver scheduler.SchedulerDriver,
+) bool {
 	// Try to reseed with this node
-	rpc.TriggerReseed(s.running[node])
+	s.triggerReseed(s.running[node])
 	// Wait for it to become healthy, but if it doesn't then kill it
-	backoff := 1
-	before := time.Now()
-	for time.Since(before) < s.reseedTimeout {
-		err := rpc.HealthCheck(map[string]*config.Node{
+	timeout := s.ReseedHealthTimeout
+	if timeout == 0 {
+		timeout = s.reseedTimeout
+	}
+	backoff := time.Second
+	before := s.clock.Now()
+	for s.clock.Since(before) < timeout {
+		err := s.healthCheck(map[string]*config.Node{
 			node: s.running[node],
 		})
 		if err == nil {
@@ -1134,53 +3663,210 @@ func (s *EtcdScheduler) reseedNode(node string, driver scheduler.SchedulerDriver
 			return true
 		}
 		log.Warningf("Reseed candidate %s not yet healthy.", node)
-		time.Sleep(time.Duration(backoff) * time.Second)
-		backoff = int(math.Min(float64(backoff<<1), 8))
+		select {
+		case <-ctx.Done():
+			return false
+		case <-s.clock.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.ReseedHealthBackoffCap {
+			backoff = s.ReseedHealthBackoffCap
+		}
+	}
+	return false
+}
+
+// isExternalSeed returns whether name is a member of the external cluster
+// that this one was seeded from.  Such members are never pruned, and are
+// left for the operator to remove once migration into Mesos is complete.
+func (s *EtcdScheduler) isExternalSeed(name string) bool {
+	for _, node := range s.ExternalSeed {
+		if node.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// persistenceIDs returns the IDs of the persistent volumes in an offer.
+func persistenceIDs(offer *mesos.Offer) []string {
+	ids := []string{}
+	for _, res := range offer.GetResources() {
+		if id := res.GetDisk().GetPersistence().GetId(); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// persistentVolume returns the persistent volume in an offer that a new
+// task should use, preferring one that belonged to a lost member, or nil if
+// the offer carries none.  The volume is mounted at volumeContainerPath
+// unless it names its own path.  Not thread safe!  Callers must hold s.mut.
+func (s *EtcdScheduler) persistentVolume(offer *mesos.Offer) *mesos.Resource {
+	var volume *mesos.Resource
+	for _, res := range offer.GetResources() {
+		id := res.GetDisk().GetPersistence().GetId()
+		if id == "" {
+			continue
+		}
+		if _, lost := s.lostVolumes[id]; lost {
+			volume = res
+			break
+		}
+		if volume == nil {
+			volume = res
+		}
+	}
+	if volume == nil {
+		return nil
+	}
+	volume = proto.Clone(volume).(*mesos.Resource)
+	if volume.Disk.Volume == nil {
+		volume.Disk.Volume = &mesos.Volume{Mode: mesos.Volume_RW.Enum()}
+	}
+	if volume.Disk.Volume.GetContainerPath() == "" {
+		volume.Disk.Volume.ContainerPath = proto.String(volumeContainerPath)
+	}
+	return volume
+}
+
+// offersLostVolume returns whether an offer carries a persistent volume
+// that belonged to a lost member.
+func (s *EtcdScheduler) offersLostVolume(offer *mesos.Offer) bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	for _, id := range persistenceIDs(offer) {
+		if _, lost := s.lostVolumes[id]; lost {
+			return true
+		}
+	}
+	return false
+}
+
+// undersizedAgent returns whether an offer's slave is too small to host
+// etcd comfortably, even if this offer would fit a task.  Mesos does not
+// tell frameworks a slave's total capacity, so this counts every cpus, mem
+// and disk resource in the offer, revocable or not, as the best estimate.
+func (s *EtcdScheduler) undersizedAgent(offer *mesos.Offer) bool {
+	var cpus, mem, disk float64
+	for _, res := range offer.GetResources() {
+		switch res.GetName() {
+		case "cpus":
+			cpus += res.GetScalar().GetValue()
+		case "mem":
+			mem += res.GetScalar().GetValue()
+		case "disk":
+			disk += res.GetScalar().GetValue()
+		}
+	}
+	return cpus < s.MinAgentCpus || mem < s.MinAgentMem || disk < s.MinAgentDisk
+}
+
+// textAttribute returns the text value of the named attribute of an
+// offer's slave, or "" if it has none.
+func textAttribute(offer *mesos.Offer, name string) string {
+	if name == "" {
+		return ""
+	}
+	for _, attr := range offer.GetAttributes() {
+		if attr.GetName() == name {
+			return attr.GetText().GetValue()
+		}
+	}
+	return ""
+}
+
+// hostsOtherCluster returns whether an offer's slave is annotated, via its
+// ClusterAttribute attribute, as hosting members of an etcd cluster other
+// than this one.  The attribute holds a comma-separated list of framework
+// names as text, or a set of them.
+func (s *EtcdScheduler) hostsOtherCluster(offer *mesos.Offer) bool {
+	if s.ClusterAttribute == "" {
+		return false
+	}
+	for _, attr := range offer.GetAttributes() {
+		if attr.GetName() != s.ClusterAttribute {
+			continue
+		}
+		clusters := attr.GetSet().GetItem()
+		if text := attr.GetText().GetValue(); text != "" {
+			clusters = append(clusters, strings.Split(text, ",")...)
+		}
+		for _, cluster := range clusters {
+			if cluster = strings.TrimSpace(cluster); cluster != "" &&
+				cluster != s.FrameworkName {
+				return true
+			}
+		}
 	}
 	return false
 }
 
-func parseOffer(offer *mesos.Offer) OfferResources {
+// parseOffer totals the resources of offer that etcd may use.  An offer
+// that is malformed, as opposed to merely too small, returns an error, so
+// that it is not mistaken for a shortfall of resources.
+func parseOffer(offer *mesos.Offer) (OfferResources, error) {
 	getResources := func(resourceName string) []*mesos.Resource {
 		return util.FilterResources(
 			offer.Resources,
 			func(res *mesos.Resource) bool {
-				return res.GetName() == resourceName
+				// etcd must not run on best-effort resources that may be
+				// revoked out from under it.
+				return res.GetName() == resourceName && res.GetRevocable() == nil
 			},
 		)
 	}
 
-	cpuResources := getResources("cpus")
-	cpus := 0.0
-	for _, res := range cpuResources {
-		cpus += res.GetScalar().GetValue()
+	// Resources must never add up to more than is really there, so
+	// negative scalars and empty or inverted port ranges are ignored.
+	sumScalars := func(name string) (float64, error) {
+		total := 0.0
+		for _, res := range getResources(name) {
+			if res.GetScalar() == nil {
+				return 0, fmt.Errorf("%s resource has no scalar value", name)
+			}
+			if v := res.GetScalar().GetValue(); v > 0 {
+				total += v
+			}
+		}
+		return total, nil
 	}
 
-	memResources := getResources("mem")
-	mems := 0.0
-	for _, res := range memResources {
-		mems += res.GetScalar().GetValue()
+	var (
+		resources OfferResources
+		err       error
+	)
+	if resources.cpus, err = sumScalars("cpus"); err != nil {
+		return OfferResources{}, err
 	}
-
-	portResources := getResources("ports")
-	ports := make([]*mesos.Value_Range, 0, 10)
-	for _, res := range portResources {
-		ranges := res.GetRanges()
-		ports = append(ports, ranges.GetRange()...)
+	if resources.mems, err = sumScalars("mem"); err != nil {
+		return OfferResources{}, err
 	}
-
-	diskResources := getResources("disk")
-	disk := 0.0
-	for _, res := range diskResources {
-		disk += res.GetScalar().GetValue()
+	if resources.disk, err = sumScalars("disk"); err != nil {
+		return OfferResources{}, err
 	}
 
-	return OfferResources{
-		cpus:  cpus,
-		mems:  mems,
-		disk:  disk,
-		ports: ports,
+	portResources := getResources("ports")
+	if len(portResources) == 0 {
+		return OfferResources{}, errors.New("offer has no ports resource")
+	}
+	resources.ports = make([]*mesos.Value_Range, 0, 10)
+	for _, res := range portResources {
+		if res.GetRanges() == nil {
+			return OfferResources{}, errors.New("ports resource has no ranges")
+		}
+		for _, pr := range res.GetRanges().GetRange() {
+			if pr.Begin == nil || pr.End == nil {
+				return OfferResources{}, errors.New("port range is missing a bound")
+			}
+			if *pr.End < *pr.Begin {
+				continue
+			}
+			resources.ports = append(resources.ports, pr)
+		}
 	}
+	return resources, nil
 }
 
 func ServeExecutorArtifact(path, address string, artifactPort int) (*string, error) {
@@ -1194,21 +3880,46 @@ func ServeExecutorArtifact(path, address string, artifactPort int) (*string, err
 	}
 
 	// Create base path (http://foobar:5000/<base>)
-	pathSplit := strings.Split(path, "/")
-	var base string
-	if len(pathSplit) > 0 {
-		base = pathSplit[len(pathSplit)-1]
-	} else {
-		base = path
+	base := filepath.Base(path)
+	if base == "/" || base == "." || base == ".." {
+		return nil, fmt.Errorf("can not serve artifact %q: it has no file name", path)
 	}
 	serveFile("/"+base, path)
 
-	hostURI := fmt.Sprintf("http://%s:%d/%s", address, artifactPort, base)
+	hostURI := config.JoinURL("http", address, uint64(artifactPort)) + "/" + url.PathEscape(base)
 	log.V(2).Infof("Hosting artifact '%s' at '%s'", path, hostURI)
 
 	return &hostURI, nil
 }
 
+// NewExecutorURI returns a URI for the Mesos fetcher to download into the
+// executor sandbox as an executable.  With cache, the fetcher cache is used
+// so that large binaries are not downloaded again for every launch, and a
+// changed artifact must then be served at a new URI.
+func NewExecutorURI(uri string, cache bool) (*mesos.CommandInfo_URI, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid executor URI %q: %v", uri, err)
+	}
+	if u.Scheme == "" && !filepath.IsAbs(u.Path) {
+		return nil, fmt.Errorf("executor URI %q must have a scheme or be an absolute path", uri)
+	}
+	if strings.HasSuffix(u.Path, "/") {
+		return nil, fmt.Errorf("executor URI %q does not name a file", uri)
+	}
+	return &mesos.CommandInfo_URI{
+		Value:      proto.String(uri),
+		Executable: proto.Bool(true),
+		Cache:      proto.Bool(cache),
+	}, nil
+}
+
+// dataHostPath returns the directory under DataHostPath that keeps the
+// named member's data on its slave.
+func (s *EtcdScheduler) dataHostPath(name string) string {
+	return filepath.Join(s.DataHostPath, s.FrameworkName, name)
+}
+
 func (s *EtcdScheduler) newExecutorInfo(
 	node *config.Node,
 	executorURIs []*mesos.CommandInfo_URI,
@@ -1225,13 +3936,41 @@ func (s *EtcdScheduler) newExecutorInfo(
 		}
 	)
 	ci.Arguments = append(ci.Arguments, execmd)
-	ci.Arguments = append(ci.Arguments, "-log_dir=./")
+	// The command is not run through a shell, so each argument reaches the
+	// executor intact and log directories containing spaces need no quoting.
+	ci.Arguments = append(ci.Arguments, "-log_dir="+s.ExecutorLogDir)
+	if s.ExecutorLogLevel > 0 {
+		ci.Arguments = append(ci.Arguments, "-v="+strconv.Itoa(s.ExecutorLogLevel))
+	}
 	ci.Arguments = append(ci.Arguments, "-driver-port="+strconv.Itoa(int(libprocessPort)))
+	if s.User != "" {
+		// etcd's data directory is created and owned by this user.
+		ci.User = proto.String(s.User)
+	}
+	source := s.ExecutorSource
+	if source == "" {
+		source = s.FrameworkName
+	}
+	var container *mesos.ContainerInfo
+	if s.DataHostPath != "" {
+		// The mount outlives the sandbox.  Each member gets a directory of
+		// its own, as a replacement must never start from the data of a
+		// removed member, nor one framework's member from another's.
+		container = &mesos.ContainerInfo{
+			Type: mesos.ContainerInfo_MESOS.Enum(),
+			Volumes: []*mesos.Volume{{
+				ContainerPath: proto.String(hostDataContainerPath),
+				HostPath:      proto.String(s.dataHostPath(node.Name)),
+				Mode:          mesos.Volume_RW.Enum(),
+			}},
+		}
+	}
 	return &mesos.ExecutorInfo{
 		ExecutorId: util.NewExecutorID(node.Name),
 		Name:       proto.String("etcd"),
-		Source:     proto.String(s.FrameworkName),
+		Source:     proto.String(source),
 		Command:    ci,
+		Container:  container,
 		Resources: []*mesos.Resource{
 			util.NewScalarResource("cpus", executorWantsCpus),
 			util.NewScalarResource("mem", executorWantsMem),
@@ -19,7 +19,25 @@
 package scheduler
 
 import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	gotesting "testing"
 	"time"
 
@@ -27,9 +45,17 @@ import (
 	"github.com/gogo/protobuf/proto"
 	mesos "github.com/mesos/mesos-go/mesosproto"
 	util "github.com/mesos/mesos-go/mesosutil"
+	"github.com/mesos/mesos-go/scheduler"
+	"github.com/samuel/go-zookeeper/zk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
 
+	"github.com/mesosphere/etcd-mesos/clock"
 	"github.com/mesosphere/etcd-mesos/config"
+	etcderrors "github.com/mesosphere/etcd-mesos/errors"
+	"github.com/mesosphere/etcd-mesos/offercache"
+	"github.com/mesosphere/etcd-mesos/rpc"
 	emtesting "github.com/mesosphere/etcd-mesos/testing"
 )
 
@@ -105,6 +131,111 @@ func TestStartup(t *gotesting.T) {
 	mockdriver.AssertExpectations(t)
 }
 
+// countingClock records the sleeps of a retry loop without sleeping.
+type countingClock struct {
+	clock.Clock
+	sync.Mutex
+	sleeps []time.Duration
+}
+
+func (c *countingClock) Sleep(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.sleeps = append(c.sleeps, d)
+}
+
+func TestReconcileAttemptsBoundReconcileLoop(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	sleeps := &countingClock{Clock: clock.NewFake(time.Now())}
+	testScheduler.clock = sleeps
+	testScheduler.ReconcileAttempts = 3
+	testScheduler.ReconcileBackoffCap = 3 * time.Second
+	testScheduler.SyncWaitAttempts = 7
+	calls := 0
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		calls++
+		return nil, errors.New("zk unavailable")
+	}
+	shutdown := false
+	testScheduler.shutdown = func() { shutdown = true }
+
+	testScheduler.attemptMasterSync(&MockSchedulerDriver{})
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, sleeps.sleeps)
+	assert.True(t, shutdown)
+}
+
+func TestSyncWaitAttemptsBoundSyncLoop(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	sleeps := &countingClock{Clock: clock.NewFake(time.Now())}
+	testScheduler.clock = sleeps
+	testScheduler.ReconcileAttempts = 2
+	testScheduler.SyncWaitAttempts = 3
+	testScheduler.SyncWaitBackoffCap = time.Second
+	masterInfo := util.NewMasterInfo("master-1", 0, 0)
+	masterInfo.Hostname = proto.String("test-host")
+	testScheduler.masterInfo = masterInfo
+	// The persisted task is never heard from, so the sync never completes.
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{"etcd-1 localhost 1 2 3": "slave-1"}, nil
+	}
+	testScheduler.runningSnapshot = func([]string, string, string) (map[string]*config.Node, error) {
+		return map[string]*config.Node{}, nil
+	}
+	shutdown := false
+	testScheduler.shutdown = func() { shutdown = true }
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("ReconcileTasks", 0).Return(mesos.Status_DRIVER_RUNNING, nil)
+	mockdriver.On("ReconcileTasks", 1).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.attemptMasterSync(mockdriver)
+	mockdriver.AssertNumberOfCalls(t, "ReconcileTasks", 4)
+	// Each reconcile attempt sleeps between its three sync checks, and
+	// once more before the next attempt.
+	assert.Equal(t, 8, len(sleeps.sleeps))
+	assert.Equal(t, time.Second, sleeps.sleeps[2])
+	assert.True(t, shutdown)
+}
+
+func TestPeriodicReconcileCorrectsMissedStatus(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(2, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	fakeClock := clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.clock = fakeClock
+	for _, name := range []string{"etcd-1", "etcd-2"} {
+		testScheduler.running[name] = &config.Node{Name: name, SlaveID: "slave-1"}
+		testScheduler.tasks[name] = util.NewTaskID(name + " localhost 0 0 0")
+	}
+	mockdriver := &MockSchedulerDriver{
+		scheduler:       testScheduler,
+		runningStatuses: make(chan *mesos.TaskStatus, 1),
+	}
+	mockdriver.On("ReconcileTasks", 0).Return(mesos.Status_DRIVER_RUNNING, nil)
+	mockdriver.On("ReconcileTasks", 2).Return(mesos.Status_DRIVER_RUNNING, nil)
+	mockdriver.On("ReconcileTasks", 1).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	go testScheduler.PeriodicReconciler(mockdriver)
+	for i := 0; i < 100 && fakeClock.Waiters() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mockdriver.AssertCalled(t, "ReconcileTasks", 2)
+	assert.Equal(t, 2, len(testScheduler.RunningCopy()))
+
+	// etcd-2 died while its status update was lost, so the master answers
+	// the next reconciliation with TASK_LOST.
+	mockdriver.runningStatuses <- util.NewTaskStatus(
+		util.NewTaskID("etcd-2 localhost 0 0 0"),
+		mesos.TaskState_TASK_LOST,
+	)
+	fakeClock.Advance(testScheduler.ReconcileInterval)
+	for i := 0; i < 100 && len(testScheduler.RunningCopy()) == 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	running := testScheduler.RunningCopy()
+	assert.Equal(t, 1, len(running))
+	assert.NotNil(t, running["etcd-1"])
+}
+
 func TestReconciliationOnStartup(t *gotesting.T) {
 	testScheduler := NewEtcdScheduler(3, 0, 0, true, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
 	mockdriver := &MockSchedulerDriver{
@@ -341,3 +472,3332 @@ func TestScheduler(t *gotesting.T) {
 	//assert that mock was invoked
 	mockdriver.AssertExpectations(t)
 }
+
+func TestTotalLossPolicies(t *gotesting.T) {
+	for _, tc := range []struct {
+		policy         TotalLossPolicy
+		restoreCommand string
+		wantsState     State
+		wantsRestore   bool
+	}{
+		{TotalLossLock, "", Immutable, false},
+		{TotalLossSeedNew, "", Mutable, false},
+		{TotalLossRestoreBackup, "restore", Mutable, true},
+		// Without a backup to restore, the scheduler locks.
+		{TotalLossRestoreBackup, "", Immutable, false},
+	} {
+		testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+		testScheduler.state = Mutable
+		testScheduler.TotalLoss = tc.policy
+		testScheduler.RestoreCommand = tc.restoreCommand
+		testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+			return nil
+		}
+		restored := make(chan string, 1)
+		testScheduler.restoreBackup = func(node *config.Node) error {
+			restored <- node.Name
+			return nil
+		}
+		mockdriver := &MockSchedulerDriver{}
+		testScheduler.StatusUpdate(mockdriver, util.NewTaskStatus(
+			util.NewTaskID("etcd-1 localhost 1 1 1"),
+			mesos.TaskState_TASK_RUNNING,
+		))
+
+		testScheduler.StatusUpdate(mockdriver, util.NewTaskStatus(
+			util.NewTaskID("etcd-1 localhost 1 1 1"),
+			mesos.TaskState_TASK_FAILED,
+		))
+		desc := fmt.Sprintf("%s %q", tc.policy, tc.restoreCommand)
+		assert.Equal(t, tc.wantsState, testScheduler.state, desc)
+		assert.Equal(t, 1, len(testScheduler.launchChan),
+			"A replacement launch should be queued: %s", desc)
+		assert.Equal(t, tc.wantsRestore, testScheduler.restorePending, desc)
+
+		// The first member of the new cluster has the backup restored
+		// into it.
+		testScheduler.StatusUpdate(mockdriver, util.NewTaskStatus(
+			util.NewTaskID("etcd-2 localhost 1 1 1"),
+			mesos.TaskState_TASK_RUNNING,
+		))
+		if tc.wantsRestore {
+			select {
+			case name := <-restored:
+				assert.Equal(t, "etcd-2", name)
+			case <-time.After(time.Second):
+				t.Errorf("No backup was restored: %s", desc)
+			}
+		} else {
+			assert.Equal(t, 0, len(restored), desc)
+		}
+		assert.False(t, testScheduler.restorePending, desc)
+	}
+}
+
+func TestTasksBeyondCapNotAdopted(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.TaskCapMultiple = 2
+	persisted := map[string]string{}
+	testScheduler.updateReconciliationInfoFunc = func(info map[string]string, _ []string, _ string, _ string) error {
+		persisted = info
+		return nil
+	}
+	excess := util.NewTaskID("etcd-3 localhost 1 1 1")
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("KillTask", excess).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	for _, name := range []string{"etcd-1", "etcd-2", "etcd-3"} {
+		testScheduler.StatusUpdate(mockdriver, util.NewTaskStatus(
+			util.NewTaskID(name+" localhost 1 1 1"),
+			mesos.TaskState_TASK_RUNNING,
+		))
+	}
+	running := testScheduler.RunningCopy()
+	assert.Equal(t, 2, len(running))
+	_, adopted := running["etcd-3"]
+	assert.False(t, adopted)
+	assert.Equal(t, 2, len(persisted))
+	mockdriver.AssertNumberOfCalls(t, "KillTask", 1)
+	mockdriver.AssertCalled(t, "KillTask", excess)
+
+	// Tasks already tracked are still updated.
+	testScheduler.StatusUpdate(mockdriver, util.NewTaskStatus(
+		util.NewTaskID("etcd-2 localhost 1 1 1"),
+		mesos.TaskState_TASK_RUNNING,
+	))
+	mockdriver.AssertNumberOfCalls(t, "KillTask", 1)
+}
+
+func TestStalledLauncherDegradesHealthz(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	fakeClock := clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.clock = fakeClock
+	atomic.StoreUint32(&testScheduler.Stats.IsHealthy, 1)
+	server := httptest.NewServer(testScheduler.adminMux(&MockSchedulerDriver{}))
+	defer server.Close()
+
+	healthz := func() (int, string) {
+		resp, err := http.Get(server.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	// The launcher starts a launch, which then never returns.
+	testScheduler.heartbeat(launcherHeartbeat, testScheduler.HeartbeatTimeout)
+	code, _ := healthz()
+	assert.Equal(t, http.StatusOK, code)
+
+	fakeClock.Advance(testScheduler.HeartbeatTimeout + time.Second)
+	code, body := healthz()
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Contains(t, body, "launcher is 1s overdue")
+
+	// Waiting for work is not being wedged.
+	testScheduler.heartbeat(launcherHeartbeat, 0)
+	fakeClock.Advance(time.Hour)
+	code, _ = healthz()
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestLauncherWaitingForOffersIsNotStale(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	fakeClock := clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.clock = fakeClock
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	atomic.StoreUint32(&testScheduler.Stats.IsHealthy, 1)
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+
+	// SerialLauncher beats before each launch, which then waits for an
+	// offer for longer than the heartbeat timeout.
+	testScheduler.heartbeat(launcherHeartbeat, testScheduler.HeartbeatTimeout)
+	done := make(chan struct{})
+	go func() {
+		testScheduler.launchOne(mockdriver)
+		close(done)
+	}()
+	for i := 0; i < 1000 && atomic.LoadInt64(testScheduler.heartbeats[launcherHeartbeat]) != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	fakeClock.Advance(testScheduler.HeartbeatTimeout + time.Minute)
+
+	resp, err := http.Get(server.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	testScheduler.offerCache.Push(NewOffer("1"))
+	<-done
+	assert.Equal(t, 1, len(mockdriver.launched))
+}
+
+func TestMaxInstanceCountCapsClusterSize(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.ReseedCooldown = 0
+	testScheduler.MaxInstanceCount = 2
+	testScheduler.memberList = func(running map[string]*config.Node) (map[string]string, error) {
+		members := map[string]string{}
+		for name := range running {
+			members[name] = name
+		}
+		return members, nil
+	}
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	mockdriver := &MockSchedulerDriver{}
+
+	assert.NoError(t, testScheduler.CheckInstanceCount(2))
+	assert.Error(t, testScheduler.CheckInstanceCount(3))
+
+	// Growing to the maximum is allowed, but not past it, even though
+	// the cluster is asked to be larger.
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	assert.True(t, testScheduler.shouldLaunch(mockdriver))
+	testScheduler.running["etcd-2"] = &config.Node{Name: "etcd-2"}
+	assert.False(t, testScheduler.shouldLaunch(mockdriver))
+
+	// A rolling upgrade's replacement may go one past the cap.
+	testScheduler.surge = 1
+	assert.True(t, testScheduler.shouldLaunch(mockdriver))
+	testScheduler.surge = 0
+
+	testScheduler.MaxInstanceCount = 0
+	assert.True(t, testScheduler.shouldLaunch(mockdriver))
+}
+
+func TestUnreachableHealthCheckDoesNotAdvanceLivelock(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.ReseedCooldown = 0
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{"etcd-1": "1"}, nil
+	}
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	var healthErr error
+	testScheduler.healthCheck = func(map[string]*config.Node) error {
+		return healthErr
+	}
+	mockdriver := &MockSchedulerDriver{}
+
+	healthErr = etcderrors.Unreachable(etcderrors.ErrEtcdConnection)
+	assert.False(t, testScheduler.shouldLaunch(mockdriver))
+	assert.Nil(t, testScheduler.livelockWindow)
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().ClusterLivelocks)
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().IsHealthy)
+
+	healthErr = etcderrors.Unhealthy(etcderrors.ErrEtcdRaftStall)
+	assert.False(t, testScheduler.shouldLaunch(mockdriver))
+	assert.NotNil(t, testScheduler.livelockWindow)
+	window := *testScheduler.livelockWindow
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().ClusterLivelocks)
+
+	// Losing contact with the members leaves the livelock window as it is.
+	healthErr = etcderrors.Unreachable(etcderrors.ErrNoLeader)
+	assert.False(t, testScheduler.shouldLaunch(mockdriver))
+	assert.Equal(t, window, *testScheduler.livelockWindow)
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().ClusterLivelocks)
+
+	healthErr = nil
+	assert.True(t, testScheduler.shouldLaunch(mockdriver))
+	assert.Nil(t, testScheduler.livelockWindow)
+}
+
+func TestStatsReportLivelockWindow(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 60, true, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.ReseedCooldown = 0
+	fakeClock := clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.clock = fakeClock
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{"etcd-1": "1"}, nil
+	}
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	var healthErr error = etcderrors.Unhealthy(etcderrors.ErrEtcdRaftStall)
+	testScheduler.healthCheck = func(map[string]*config.Node) error {
+		return healthErr
+	}
+	mockdriver := &MockSchedulerDriver{}
+
+	stats := testScheduler.StatsCopy()
+	assert.Equal(t, uint32(1), stats.AutoReseed)
+	assert.Equal(t, uint32(0), stats.LivelockOpen)
+
+	assert.False(t, testScheduler.shouldLaunch(mockdriver))
+	fakeClock.Advance(20 * time.Second)
+	stats = testScheduler.StatsCopy()
+	assert.Equal(t, uint32(1), stats.LivelockOpen)
+	assert.Equal(t, uint32(40), stats.SecsUntilReseed)
+
+	healthErr = nil
+	assert.True(t, testScheduler.shouldLaunch(mockdriver))
+	stats = testScheduler.StatsCopy()
+	assert.Equal(t, uint32(0), stats.LivelockOpen)
+	assert.Equal(t, uint32(0), stats.SecsUntilReseed)
+}
+
+func TestChillGrowsWithRunningMembers(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(5, 10, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	assert.Equal(t, 10*time.Second, testScheduler.chill())
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	assert.Equal(t, 10*time.Second, testScheduler.chill(),
+		"The wait should be flat by default.")
+
+	testScheduler.ChillPerMember = 2 * time.Second
+	assert.Equal(t, 12*time.Second, testScheduler.chill())
+	testScheduler.running["etcd-2"] = &config.Node{Name: "etcd-2"}
+	testScheduler.running["etcd-3"] = &config.Node{Name: "etcd-3"}
+	assert.Equal(t, 16*time.Second, testScheduler.chill())
+}
+
+func TestPendingLaunchReservesSlave(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On(
+		"DeclineOffer",
+		mock.Anything,
+		mock.Anything,
+	).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	// A launch onto slave-1 is in flight, so a new offer from that slave
+	// must not be cached even though nothing is running there yet.
+	testScheduler.pending["etcd-1"] = "slave-1"
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("1")})
+	assert.Equal(t, 0, testScheduler.offerCache.Len(),
+		"Offers from a slave with a pending launch should be declined.")
+
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("2")})
+	assert.Equal(t, 1, testScheduler.offerCache.Len(),
+		"Offers from an unused slave should be cached.")
+	mockdriver.AssertNumberOfCalls(t, "DeclineOffer", 1)
+}
+
+func TestPendingLaunchTimesOut(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.LaunchTimeout = time.Minute
+	mockdriver := &MockSchedulerDriver{}
+	stuck := util.NewTaskID("etcd-1 localhost 0 0 0")
+	mockdriver.On("KillTask", stuck).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	// etcd-1 was launched long ago and never reported RUNNING, while
+	// etcd-2 was only just launched.
+	testScheduler.pending["etcd-1"] = "slave-1"
+	testScheduler.launchAttempts["etcd-1"] = launchAttempt{
+		taskID:   stuck,
+		launched: time.Now().Add(-2 * time.Minute),
+	}
+	testScheduler.pending["etcd-2"] = "slave-2"
+	testScheduler.launchAttempts["etcd-2"] = launchAttempt{
+		taskID:   util.NewTaskID("etcd-2 localhost 0 0 0"),
+		launched: time.Now(),
+	}
+
+	testScheduler.expirePendingLaunches(mockdriver)
+
+	mockdriver.AssertNumberOfCalls(t, "KillTask", 1)
+	mockdriver.AssertCalled(t, "KillTask", stuck)
+	assert.Equal(t, []string{"etcd-2"}, testScheduler.Snapshot().Pending)
+	assert.Equal(t, 1, len(testScheduler.launchChan),
+		"A replacement launch should have been queued.")
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().FailedServers)
+
+	// Hearing back from a task clears its launch deadline.
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	status := util.NewTaskStatus(
+		util.NewTaskID("etcd-2 localhost 0 0 0"),
+		mesos.TaskState_TASK_RUNNING,
+	)
+	status.SlaveId = util.NewSlaveID("slave-2")
+	testScheduler.StatusUpdate(mockdriver, status)
+	assert.Equal(t, 0, len(testScheduler.launchAttempts))
+}
+
+// captureLog returns what f logs to stderr.
+func captureLog(t *gotesting.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	flag.Set("logtostderr", "true")
+	defer func() {
+		flag.Set("logtostderr", "false")
+		os.Stderr = stderr
+	}()
+	f()
+	w.Close()
+	logged, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(logged)
+}
+
+func TestPendingAgeReported(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	fakeClock := clock.NewFake(time.Now())
+	testScheduler.clock = fakeClock
+	testScheduler.PendingAgeWarning = 2 * time.Minute
+	for _, name := range []string{"etcd-1", "etcd-2"} {
+		testScheduler.pending[name] = "slave-1"
+		testScheduler.launchAttempts[name] = launchAttempt{
+			taskID:   util.NewTaskID(name + " localhost 0 0 0"),
+			launched: fakeClock.Now(),
+		}
+		fakeClock.Advance(30 * time.Second)
+	}
+
+	logged := captureLog(t, testScheduler.checkPendingAge)
+	assert.Equal(t, uint32(60), testScheduler.StatsCopy().OldestPendingSecs)
+	assert.NotContains(t, logged, "has been pending")
+
+	fakeClock.Advance(2 * time.Minute)
+	logged = captureLog(t, testScheduler.checkPendingAge)
+	assert.Equal(t, uint32(180), testScheduler.StatsCopy().OldestPendingSecs)
+	assert.Contains(t, logged, "etcd-1 has been pending for 3m0s")
+
+	delete(testScheduler.pending, "etcd-1")
+	delete(testScheduler.pending, "etcd-2")
+	testScheduler.checkPendingAge()
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().OldestPendingSecs)
+}
+
+func TestExecutorSourceAndUser(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.FrameworkName = "etcd-prod"
+	node := &config.Node{Name: "etcd-1"}
+
+	info := testScheduler.newExecutorInfo(node, nil, 31000)
+	assert.Equal(t, "etcd-prod", info.GetSource())
+	assert.Nil(t, info.GetCommand().User, "The framework user should apply by default.")
+
+	testScheduler.ExecutorSource = "team-storage"
+	testScheduler.User = "etcd"
+	info = testScheduler.newExecutorInfo(node, nil, 31000)
+	assert.Equal(t, "team-storage", info.GetSource())
+	assert.Equal(t, "etcd", info.GetCommand().GetUser())
+}
+
+func TestExecutorLogConfig(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.ExecutorPath = "/opt/bin/etcd-mesos-executor"
+	node := &config.Node{Name: "etcd-1"}
+
+	ci := testScheduler.newExecutorInfo(node, nil, 31000).GetCommand()
+	assert.Equal(t, "./etcd-mesos-executor", ci.GetValue())
+	assert.False(t, ci.GetShell())
+	assert.Equal(t, []string{
+		"./etcd-mesos-executor",
+		"-log_dir=./",
+		"-driver-port=31000",
+	}, ci.GetArguments())
+
+	testScheduler.ExecutorLogDir = "/mnt/etcd logs"
+	testScheduler.ExecutorLogLevel = 2
+	ci = testScheduler.newExecutorInfo(node, nil, 31000).GetCommand()
+	assert.Equal(t, []string{
+		"./etcd-mesos-executor",
+		"-log_dir=/mnt/etcd logs",
+		"-v=2",
+		"-driver-port=31000",
+	}, ci.GetArguments())
+}
+
+func TestMaintenanceMigratesInstance(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+	mockdriver.On("KillTask", mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	for _, id := range []string{"1", "2", "3"} {
+		status := util.NewTaskStatus(
+			util.NewTaskID("etcd-"+id+" localhost 0 0 0"),
+			mesos.TaskState_TASK_RUNNING,
+		)
+		status.SlaveId = util.NewSlaveID("slave-" + id)
+		testScheduler.StatusUpdate(mockdriver, status)
+	}
+
+	offer := NewOffer("1")
+	offer.Unavailability = &mesos.Unavailability{
+		Start: &mesos.TimeInfo{Nanoseconds: proto.Int64(time.Now().Add(time.Hour).UnixNano())},
+	}
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{offer})
+	assert.Equal(t, 0, testScheduler.offerCache.Len(),
+		"Offers from slaves scheduled for maintenance should be declined.")
+	mockdriver.AssertCalled(t, "KillTask", util.NewTaskID("etcd-1 localhost 0 0 0"))
+
+	// Further offers from the same slave do not kill the instance again.
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{offer})
+	mockdriver.AssertNumberOfCalls(t, "KillTask", 1)
+	mockdriver.AssertNumberOfCalls(t, "DeclineOffer", 2)
+
+	// Once the kill lands, the migration is complete.
+	killed := util.NewTaskStatus(
+		util.NewTaskID("etcd-1 localhost 0 0 0"),
+		mesos.TaskState_TASK_KILLED,
+	)
+	killed.SlaveId = util.NewSlaveID("slave-1")
+	testScheduler.StatusUpdate(mockdriver, killed)
+	assert.Equal(t, 0, len(testScheduler.migrating))
+	assert.Equal(t, 2, len(testScheduler.RunningCopy()))
+}
+
+func TestMigrationKeepsQuorum(t *gotesting.T) {
+	for _, size := range []int{1, 2} {
+		testScheduler := NewEtcdScheduler(size, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 0.5, 128, 1)
+		testScheduler.state = Mutable
+		for i := 1; i <= size; i++ {
+			name := "etcd-" + strconv.Itoa(i)
+			testScheduler.running[name] = &config.Node{Name: name, SlaveID: "slave-1"}
+			testScheduler.tasks[name] = util.NewTaskID(name + " localhost 0 0 0")
+		}
+		testScheduler.offeredSlaves["slave-2"] = time.Now()
+		mockdriver := &MockSchedulerDriver{}
+		mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+		mockdriver.On("KillTask", mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+		offer := NewOffer("1")
+		offer.Unavailability = &mesos.Unavailability{
+			Start: &mesos.TimeInfo{Nanoseconds: proto.Int64(time.Now().Add(time.Hour).UnixNano())},
+		}
+		testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{offer})
+		testScheduler.rebalance(mockdriver)
+
+		mockdriver.AssertNotCalled(t, "KillTask", mock.Anything)
+		assert.Equal(t, 0, len(testScheduler.migrating),
+			"a cluster of %d should not lose a member to migration", size)
+	}
+}
+
+func TestResourceOffersRacesStatusUpdates(t *gotesting.T) {
+	const n = 50
+	testScheduler := NewEtcdScheduler(n, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On(
+		"DeclineOffer",
+		mock.Anything,
+		mock.Anything,
+	).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			id := strconv.Itoa(i)
+			status := util.NewTaskStatus(
+				util.NewTaskID("etcd-"+id+" localhost 0 0 0"),
+				mesos.TaskState_TASK_RUNNING,
+			)
+			status.SlaveId = util.NewSlaveID("slave-" + id)
+			testScheduler.StatusUpdate(mockdriver, status)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer(strconv.Itoa(i))})
+		}
+	}()
+	wg.Wait()
+
+	assert.Equal(t, n, len(testScheduler.RunningCopy()))
+
+	// Now that every slave is in use, all further offers must be declined.
+	for i := 0; i < n; i++ {
+		testScheduler.offerCache.Rescind(util.NewOfferID(strconv.Itoa(i)))
+	}
+	for i := 0; i < n; i++ {
+		testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer(strconv.Itoa(i))})
+	}
+	assert.Equal(t, 0, testScheduler.offerCache.Len(),
+		"Offers from slaves already running etcd should never be cached.")
+}
+
+func TestSnapshot(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.running["etcd-1"] = &config.Node{
+		Name:    "etcd-1",
+		Host:    "localhost",
+		SlaveID: "slave-1",
+	}
+	testScheduler.pending["etcd-2"] = "slave-2"
+	testScheduler.Stats.LaunchedServers = 2
+
+	snapshot := testScheduler.Snapshot()
+	assert.Equal(t, Mutable, snapshot.State)
+	assert.Equal(t, "slave-1", snapshot.Running["etcd-1"].SlaveID)
+	assert.Equal(t, []string{"etcd-2"}, snapshot.Pending)
+	assert.Equal(t, uint32(2), snapshot.Stats.LaunchedServers)
+
+	// Mutating the snapshot must not leak back into the scheduler.
+	node := snapshot.Running["etcd-1"]
+	node.SlaveID = "slave-9"
+	snapshot.Running["etcd-1"] = node
+	delete(snapshot.Running, "etcd-1")
+	snapshot.Pending[0] = "etcd-9"
+	assert.Equal(t, "slave-1", testScheduler.running["etcd-1"].SlaveID)
+	_, present := testScheduler.pending["etcd-2"]
+	assert.True(t, present)
+}
+
+func TestReregisterOnCompleted(t *gotesting.T) {
+	for _, reregisterOnCompleted := range []bool{false, true} {
+		testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+		testScheduler.ReregisterOnCompleted = reregisterOnCompleted
+		cleared, shutdown, reregistered := false, false, false
+		testScheduler.clearZKStateFunc = func([]string, string, string) error {
+			cleared = true
+			return nil
+		}
+		testScheduler.shutdown = func() { shutdown = true }
+		testScheduler.reregister = func() error {
+			reregistered = true
+			return nil
+		}
+
+		testScheduler.Error(&MockSchedulerDriver{}, "Completed framework attempted to re-register")
+
+		assert.True(t, cleared, "ZK state should always be cleared")
+		assert.Equal(t, reregisterOnCompleted, reregistered)
+		assert.Equal(t, !reregisterOnCompleted, shutdown)
+	}
+}
+
+// blockingDriver is a MockSchedulerDriver whose Run blocks until Abort.
+type blockingDriver struct {
+	*MockSchedulerDriver
+	aborted chan struct{}
+}
+
+func (d *blockingDriver) Run() (mesos.Status, error) {
+	<-d.aborted
+	return mesos.Status_DRIVER_ABORTED, nil
+}
+
+func (d *blockingDriver) Abort() (mesos.Status, error) {
+	close(d.aborted)
+	return mesos.Status_DRIVER_ABORTED, nil
+}
+
+func TestReregisterReplacesDriverInProcess(t *gotesting.T) {
+	built := make(chan *blockingDriver, 2)
+	driver, err := NewReplaceableDriver(func() (scheduler.SchedulerDriver, error) {
+		d := &blockingDriver{&MockSchedulerDriver{}, make(chan struct{})}
+		d.On("ReviveOffers").Return(mesos.Status_DRIVER_RUNNING, nil)
+		built <- d
+		return d, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := <-built
+	stopped := make(chan mesos.Status)
+	go func() {
+		status, _ := driver.Run()
+		stopped <- status
+	}()
+
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.ReregisterOnCompleted = true
+	testScheduler.clearZKStateFunc = func([]string, string, string) error { return nil }
+	shutdown := false
+	testScheduler.shutdown = func() { shutdown = true }
+	testScheduler.SetReregister(driver.Replace)
+	testScheduler.Error(first, "Completed framework attempted to re-register")
+	assert.False(t, shutdown)
+
+	second := <-built
+	select {
+	case <-first.aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the completed framework's driver was not aborted")
+	}
+
+	// Calls made through the driver the scheduler's goroutines hold now
+	// reach the replacement, which Run carries on running.
+	driver.ReviveOffers()
+	second.AssertCalled(t, "ReviveOffers")
+	first.AssertNotCalled(t, "ReviveOffers")
+	select {
+	case <-stopped:
+		t.Fatal("Run returned although the driver was replaced")
+	case <-time.After(100 * time.Millisecond):
+	}
+	second.Abort()
+	assert.Equal(t, mesos.Status_DRIVER_ABORTED, <-stopped)
+}
+
+func TestDebugStateEndpoint(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+
+	status := util.NewTaskStatus(
+		util.NewTaskID("etcd-7 localhost 1 2 3"),
+		mesos.TaskState_TASK_RUNNING,
+	)
+	status.SlaveId = util.NewSlaveID("slave-7")
+	testScheduler.StatusUpdate(mockdriver, status)
+
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/debug/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var state DebugState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testScheduler.highestInstanceID, state.HighestInstanceID)
+	assert.Equal(t, "etcd-7 localhost 1 2 3", state.Tasks["etcd-7"])
+	assert.Equal(t, "slave-7", state.Running["etcd-7"].SlaveID)
+	assert.Equal(t, 0, len(state.Pending))
+}
+
+func TestReadyEndpoint(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+
+	ready := func() int {
+		resp, err := http.Get(server.URL + "/ready")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	run := func(id string) {
+		status := util.NewTaskStatus(
+			util.NewTaskID("etcd-"+id+" localhost 0 0 0"),
+			mesos.TaskState_TASK_RUNNING,
+		)
+		status.SlaveId = util.NewSlaveID("slave-" + id)
+		testScheduler.StatusUpdate(mockdriver, status)
+	}
+
+	run("1")
+	assert.Equal(t, http.StatusServiceUnavailable, ready(),
+		"1 of 3 members is below quorum")
+
+	run("2")
+	assert.Equal(t, http.StatusOK, ready(), "2 of 3 members is a quorum")
+
+	atomic.StoreUint32(&testScheduler.Stats.IsHealthy, 0)
+	assert.Equal(t, http.StatusServiceUnavailable, ready(),
+		"an unhealthy cluster is never ready")
+}
+
+func TestIsInSyncComparesTaskIdentities(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(2, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	persisted := map[string]string{
+		"etcd-1 localhost 0 0 0": "slave-1",
+		"etcd-2 localhost 0 0 0": "slave-2",
+	}
+	testScheduler.reconciliationInfo = map[string]string{}
+	for taskID, slaveID := range persisted {
+		testScheduler.reconciliationInfo[taskID] = slaveID
+	}
+
+	for taskID, slaveID := range persisted {
+		status := util.NewTaskStatus(util.NewTaskID(taskID), mesos.TaskState_TASK_RUNNING)
+		status.SlaveId = util.NewSlaveID(slaveID)
+		testScheduler.StatusUpdate(mockdriver, status)
+	}
+	assert.True(t, testScheduler.isInSync())
+
+	// Swap etcd-2 for an unexpected etcd-3, so that the counts still match.
+	testScheduler.mut.Lock()
+	delete(testScheduler.running, "etcd-2")
+	delete(testScheduler.tasks, "etcd-2")
+	testScheduler.running["etcd-3"] = &config.Node{Name: "etcd-3", SlaveID: "slave-3"}
+	testScheduler.tasks["etcd-3"] = util.NewTaskID("etcd-3 localhost 0 0 0")
+	testScheduler.mut.Unlock()
+
+	assert.Equal(t, 2, len(testScheduler.RunningCopy()))
+	assert.False(t, testScheduler.isInSync(),
+		"Running tasks differing from the persisted ones must not be in sync.")
+}
+
+func TestParseOfferSkipsRevocable(t *gotesting.T) {
+	offer := NewOffer("1")
+	for _, name := range []string{"cpus", "mem"} {
+		revocable := util.NewScalarResource(name, 100)
+		revocable.Revocable = &mesos.Resource_RevocableInfo{}
+		offer.Resources = append(offer.Resources, revocable)
+	}
+
+	resources, err := parseOffer(offer)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, resources.cpus)
+	assert.Equal(t, 256.0, resources.mems)
+	assert.Equal(t, 4096.0, resources.disk)
+}
+
+func TestParseOfferRejectsMalformedPorts(t *gotesting.T) {
+	// NewOffer's last resource is its ports.
+	offer := NewOffer("1")
+	offer.Resources = offer.Resources[:3]
+	_, err := parseOffer(offer)
+	assert.Error(t, err, "An offer without ports should be malformed.")
+
+	offer.Resources = append(offer.Resources, &mesos.Resource{
+		Name: proto.String("ports"),
+		Type: mesos.Value_RANGES.Enum(),
+	})
+	_, err = parseOffer(offer)
+	assert.Error(t, err, "A ports resource without ranges should be malformed.")
+
+	// Malformed offers are declined without counting as a shortfall.
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.NoMatchTimeout = time.Minute
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{offer})
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("1"), mock.Anything)
+	assert.Equal(t, 0, testScheduler.shortfall.count)
+	assert.Equal(t, 0, testScheduler.offerCache.Len())
+}
+
+func TestDeclineRefuseSecondsByShortfall(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	// NewOffer's last resource is its ports, and its second its mem.
+	fewPorts := NewOffer("1")
+	fewPorts.Resources[3] = util.NewRangesResource("ports", []*mesos.Value_Range{
+		util.NewValueRange(uint64(31000), uint64(31000)),
+	})
+	littleMem := NewOffer("2")
+	littleMem.Resources[1] = util.NewScalarResource("mem", 16)
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{fewPorts, littleMem})
+
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("1"),
+		&mesos.Filters{RefuseSeconds: proto.Float64(testScheduler.TransientRefuseSeconds)})
+	// A fragment of a bigger slave is not shut out for long.
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("2"),
+		&mesos.Filters{RefuseSeconds: proto.Float64(1)})
+
+	// A slave that is too small in total is.
+	testScheduler.MinAgentMem = 4096
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("3")})
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("3"),
+		&mesos.Filters{RefuseSeconds: proto.Float64(testScheduler.UndersizedRefuseSeconds)})
+	assert.True(t, testScheduler.TransientRefuseSeconds < testScheduler.UndersizedRefuseSeconds)
+}
+
+func TestCancelReseedOperation(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 60, true, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.tasks["etcd-1"] = util.NewTaskID("etcd-1 localhost 0 0 0")
+	testScheduler.rankReseedCandidates = func(map[string]*config.Node) []rpc.NodeIndex {
+		return []rpc.NodeIndex{{RaftIndex: 1, Node: "etcd-1"}}
+	}
+	testScheduler.triggerReseed = func(*config.Node) error { return nil }
+	testScheduler.healthCheck = func(map[string]*config.Node) error {
+		return errors.New("still unhealthy")
+	}
+	mockdriver := &MockSchedulerDriver{}
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		testScheduler.reseedCluster(mockdriver)
+		close(done)
+	}()
+
+	var ops []Operation
+	for i := 0; i < 100 && len(ops) == 0; i++ {
+		ops = testScheduler.operations.list()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(ops) != 1 {
+		t.Fatalf("expected one in-flight operation, got %+v", ops)
+	}
+	assert.Equal(t, "reseed", ops[0].Kind)
+
+	resp, err := http.Get(server.URL + "/operations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []Operation
+	err = json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(listed))
+
+	req, _ := http.NewRequest("DELETE", server.URL+"/operations/"+ops[0].ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled reseed did not stop")
+	}
+	assert.Equal(t, 0, len(testScheduler.operations.list()))
+	assert.Equal(t, Mutable, testScheduler.Snapshot().State)
+	mockdriver.AssertNotCalled(t, "KillTask", mock.Anything)
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRecoveryTimeRecorded(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	update := func(taskID string, state mesos.TaskState) {
+		status := util.NewTaskStatus(util.NewTaskID(taskID), state)
+		status.SlaveId = util.NewSlaveID("slave-1")
+		testScheduler.StatusUpdate(mockdriver, status)
+	}
+
+	update("etcd-1 localhost 0 0 0", mesos.TaskState_TASK_RUNNING)
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().Recoveries,
+		"The first member is not a recovery.")
+
+	update("etcd-1 localhost 0 0 0", mesos.TaskState_TASK_LOST)
+	time.Sleep(20 * time.Millisecond)
+	update("etcd-2 localhost 0 0 0", mesos.TaskState_TASK_RUNNING)
+
+	stats := testScheduler.StatsCopy()
+	assert.Equal(t, uint32(1), stats.Recoveries)
+	assert.True(t, stats.LastRecoveryMs >= 20,
+		"recovery took at least 20ms, got %dms", stats.LastRecoveryMs)
+	assert.Equal(t, 0, len(testScheduler.lostAt))
+}
+
+func TestPartitionAwareTaskStates(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	update := func(taskID string, state mesos.TaskState) {
+		status := util.NewTaskStatus(util.NewTaskID(taskID), state)
+		status.SlaveId = util.NewSlaveID("slave-1")
+		testScheduler.StatusUpdate(mockdriver, status)
+	}
+	update("etcd-1 localhost 0 0 0", mesos.TaskState_TASK_RUNNING)
+
+	// A partitioned member may come back, so it is kept.
+	update("etcd-2 localhost 0 0 0", mesos.TaskState_TASK_RUNNING)
+	update("etcd-2 localhost 0 0 0", taskUnreachable)
+	_, present := testScheduler.RunningCopy()["etcd-2"]
+	assert.True(t, present, "An unreachable member should be kept.")
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().FailedServers)
+
+	for i, state := range []mesos.TaskState{taskGone, taskGoneByOperator, taskDropped} {
+		update("etcd-2 localhost 0 0 0", mesos.TaskState_TASK_RUNNING)
+		update("etcd-2 localhost 0 0 0", state)
+		_, present = testScheduler.RunningCopy()["etcd-2"]
+		assert.False(t, present, "A member in state %d should be removed.", state)
+		assert.Equal(t, uint32(i+1), testScheduler.StatsCopy().FailedServers)
+	}
+	_, present = testScheduler.RunningCopy()["etcd-1"]
+	assert.True(t, present)
+}
+
+func TestLostGracePeriod(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.LostGracePeriod = time.Minute
+	fakeClock := clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.clock = fakeClock
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	update := func(taskID string, state mesos.TaskState) {
+		status := util.NewTaskStatus(util.NewTaskID(taskID), state)
+		status.SlaveId = util.NewSlaveID("slave-1")
+		testScheduler.StatusUpdate(mockdriver, status)
+	}
+	waitForSleepers := func(n int) {
+		for fakeClock.Waiters() != n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	isRunning := func(name string) bool {
+		_, present := testScheduler.RunningCopy()[name]
+		return present
+	}
+	update("etcd-1 localhost 0 0 0", mesos.TaskState_TASK_RUNNING)
+	update("etcd-2 localhost 0 0 0", mesos.TaskState_TASK_RUNNING)
+
+	// A member that comes back within the grace period is kept.
+	update("etcd-1 localhost 0 0 0", mesos.TaskState_TASK_LOST)
+	waitForSleepers(1)
+	assert.True(t, isRunning("etcd-1"), "A lost member should be suspected, not removed.")
+	update("etcd-1 localhost 0 0 0", mesos.TaskState_TASK_RUNNING)
+	fakeClock.Advance(time.Minute)
+	waitForSleepers(0)
+	assert.True(t, isRunning("etcd-1"), "A recovered member should not be removed.")
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().FailedServers)
+
+	// One that does not is removed once the grace period has passed.
+	update("etcd-2 localhost 0 0 0", mesos.TaskState_TASK_LOST)
+	waitForSleepers(1)
+	fakeClock.Advance(time.Minute)
+	for isRunning("etcd-2") {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().FailedServers)
+	assert.True(t, isRunning("etcd-1"))
+}
+
+func TestFailureThreshold(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	fakeClock := clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.clock = fakeClock
+	testScheduler.FailureThreshold = 2
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.tasks["etcd-1"] = util.NewTaskID("etcd-1 localhost 0 0 0")
+	mockdriver := &MockSchedulerDriver{}
+	failed := util.NewTaskStatus(
+		util.NewTaskID("etcd-1 localhost 0 0 0"),
+		mesos.TaskState_TASK_FAILED,
+	)
+
+	testScheduler.StatusUpdate(mockdriver, failed)
+	assert.Equal(t, 1, len(testScheduler.RunningCopy()),
+		"A single failure should be tolerated.")
+
+	// Failures that have aged out of the window no longer count.
+	fakeClock.Advance(testScheduler.FailureWindow)
+	testScheduler.StatusUpdate(mockdriver, failed)
+	assert.Equal(t, 1, len(testScheduler.RunningCopy()))
+
+	fakeClock.Advance(time.Minute)
+	testScheduler.StatusUpdate(mockdriver, failed)
+	assert.Equal(t, 0, len(testScheduler.RunningCopy()),
+		"The member should be replaced once it fails twice within the window.")
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().FailedServers)
+}
+
+func TestRunningSnapshotPrimesRestartedScheduler(t *gotesting.T) {
+	stored := map[string]*config.Node{}
+	before := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	before.state = Mutable
+	before.running["etcd-1"] = &config.Node{
+		Name: "etcd-1", Host: "localhost", RPCPort: 1, ClientPort: 2, ReseedPort: 3,
+		SlaveID: "slave-1", Version: "v2",
+	}
+	// A member that was lost after the snapshot was taken.
+	before.running["etcd-9"] = &config.Node{Name: "etcd-9", Host: "localhost"}
+	before.updateRunningSnapshot = func(running map[string]*config.Node, _ []string, _, _ string) error {
+		stored = running
+		return nil
+	}
+	before.snapshotRunning()
+	assert.Equal(t, 2, len(stored))
+
+	after := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	after.runningSnapshot = func([]string, string, string) (map[string]*config.Node, error) {
+		return stored, nil
+	}
+	reconciliation := map[string]string{"etcd-1 localhost 1 2 3": "slave-1"}
+	after.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return reconciliation, nil
+	}
+	after.updateReconciliationInfoFunc = func(info map[string]string, _ []string, _ string, _ string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{
+		runningStatuses: make(chan *mesos.TaskStatus, 10),
+		scheduler:       after,
+	}
+	running := util.NewTaskStatus(util.NewTaskID("etcd-1 localhost 1 2 3"), mesos.TaskState_TASK_RUNNING)
+	running.SlaveId = util.NewSlaveID("slave-1")
+	mockdriver.runningStatuses <- running
+	mockdriver.On("ReconcileTasks", mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	masterInfo := util.NewMasterInfo("master-1", 0, 0)
+	masterInfo.Hostname = proto.String("test-host")
+	after.Registered(mockdriver, util.NewFrameworkID("framework-1"), masterInfo)
+	time.Sleep(50 * time.Millisecond)
+
+	after.mut.RLock()
+	defer after.mut.RUnlock()
+	assert.Equal(t, Mutable, after.state)
+	assert.Equal(t, 1, len(after.running),
+		"Only snapshotted members in the reconciliation info should be primed.")
+	if node := after.running["etcd-1"]; assert.NotNil(t, node) {
+		assert.Equal(t, "v2", node.Version,
+			"The snapshot should carry what reconciliation can not recover.")
+	}
+}
+
+func TestFrameworkInfoIsPartitionAware(t *gotesting.T) {
+	fwinfo := NewFrameworkInfo("root", "etcd", 60, "http://localhost:8080/stats")
+	assert.Equal(t, "etcd", fwinfo.GetName())
+	assert.True(t, fwinfo.GetCheckpoint())
+	capabilities := fwinfo.GetCapabilities()
+	assert.Equal(t, 1, len(capabilities))
+	assert.Equal(t, partitionAware, capabilities[0].GetType(),
+		"Without PARTITION_AWARE masters only send TASK_LOST.")
+}
+
+func TestRelaunchPrefersLostVolume(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(2, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 1024, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	// etcd-1 was launched with volume vol-1 and has since been lost.
+	testScheduler.volumes["etcd-1"] = []string{"vol-1"}
+	lost := util.NewTaskStatus(util.NewTaskID("etcd-1 localhost 0 0 0"), mesos.TaskState_TASK_LOST)
+	lost.SlaveId = util.NewSlaveID("slave-2")
+	testScheduler.StatusUpdate(mockdriver, lost)
+	// Losing the only member locks the scheduler; unlock it to relaunch.
+	testScheduler.state = Mutable
+
+	volume := util.NewScalarResource("disk", 1024)
+	volume.Role = proto.String("etcd")
+	volume.Disk = &mesos.Resource_DiskInfo{
+		Persistence: &mesos.Resource_DiskInfo_Persistence{Id: proto.String("vol-1")},
+	}
+	withVolume := NewOffer("2")
+	withVolume.Resources = append(withVolume.Resources, volume)
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.offerCache.Push(withVolume)
+
+	testScheduler.launchOne(mockdriver)
+
+	mockdriver.AssertCalled(t, "LaunchTasks",
+		[]*mesos.OfferID{util.NewOfferID("2")}, mock.Anything, mock.Anything)
+	assert.Equal(t, 0, len(testScheduler.lostVolumes))
+	for name, ids := range testScheduler.volumes {
+		assert.Equal(t, []string{"vol-1"}, ids, "volume should now belong to %s", name)
+	}
+
+	// The task asks for the volume itself, and keeps etcd's data on it.
+	if len(mockdriver.launched) != 1 {
+		t.Fatalf("expected 1 launched task, got %d", len(mockdriver.launched))
+	}
+	var disk *mesos.Resource
+	for _, res := range mockdriver.launched[0].Resources {
+		if res.GetName() == "disk" {
+			disk = res
+		}
+	}
+	if assert.NotNil(t, disk) {
+		assert.Equal(t, "vol-1", disk.GetDisk().GetPersistence().GetId())
+		assert.Equal(t, "etcd", disk.GetRole())
+		assert.Equal(t, volumeContainerPath, disk.GetDisk().GetVolume().GetContainerPath())
+	}
+	payload := []*config.Node{}
+	if err := json.Unmarshal(mockdriver.launched[0].Data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, volumeContainerPath, payload[0].DataDir)
+}
+
+func TestOfferLogFields(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	entries := []offerLog{}
+	testScheduler.logOffer = func(o offerLog) { entries = append(entries, o) }
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("1")})
+	testScheduler.pending["etcd-2"] = "slave-2"
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("2")})
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 offer log entries, got %+v", entries)
+	}
+	assert.Equal(t, "offer=1 slave=slave-1 cpus=1 mem=256 ports=65536 disk=4096 decision=cached",
+		entries[0].String())
+	assert.Equal(t, "offer=2 slave=slave-2 cpus=1 mem=256 ports=65536 disk=4096 "+
+		`decision=declined reason="slave already in use"`, entries[1].String())
+}
+
+func TestEtcdTuningInTaskPayload(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 1024, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.QuotaBackendBytes = 4 << 30
+	testScheduler.AutoCompactionRetention = "1"
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.launchOne(mockdriver)
+
+	if len(mockdriver.launched) != 1 {
+		t.Fatalf("expected one launched task, got %d", len(mockdriver.launched))
+	}
+	var nodes []config.Node
+	err := json.Unmarshal(mockdriver.launched[0].GetData(), &nodes)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4<<30), nodes[0].QuotaBackendBytes)
+	assert.Equal(t, "1", nodes[0].AutoCompactionRetention)
+}
+
+func TestNoSpaceAlarmMarksUnhealthy(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	alarms := []rpc.Alarm{{MemberID: "42", Alarm: rpc.AlarmNoSpace}}
+	testScheduler.alarmList = func(map[string]*config.Node) ([]rpc.Alarm, error) {
+		return alarms, nil
+	}
+
+	testScheduler.checkClusterHealth()
+	stats := testScheduler.StatsCopy()
+	assert.Equal(t, uint32(0), stats.IsHealthy, "NOSPACE must mark the cluster unhealthy")
+	assert.Equal(t, uint32(1), stats.ActiveAlarms)
+	assert.Equal(t, uint32(1), stats.NoSpaceAlarm)
+	assert.Equal(t, alarms, testScheduler.debugState().Alarms)
+
+	alarms = []rpc.Alarm{}
+	testScheduler.checkClusterHealth()
+	stats = testScheduler.StatsCopy()
+	assert.Equal(t, uint32(1), stats.IsHealthy)
+	assert.Equal(t, uint32(0), stats.ActiveAlarms)
+	assert.Equal(t, uint32(0), stats.NoSpaceAlarm)
+}
+
+func TestDefragVisitsMembersOneAtATime(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	for _, name := range []string{"etcd-1", "etcd-2", "etcd-3"} {
+		testScheduler.running[name] = &config.Node{Name: name}
+	}
+	testScheduler.defragOrder = func(running map[string]*config.Node) []*config.Node {
+		// etcd-1 is the leader, so it goes last.
+		return []*config.Node{running["etcd-2"], running["etcd-3"], running["etcd-1"]}
+	}
+	var (
+		mut       sync.Mutex
+		inFlight  int
+		defragged []string
+		healthy   = true
+	)
+	testScheduler.healthCheck = func(map[string]*config.Node) error {
+		if !healthy {
+			return errors.New("unhealthy")
+		}
+		return nil
+	}
+	testScheduler.defragment = func(node *config.Node) error {
+		mut.Lock()
+		inFlight++
+		assert.Equal(t, 1, inFlight, "only one member may defragment at a time")
+		defragged = append(defragged, node.Name)
+		mut.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mut.Lock()
+		inFlight--
+		mut.Unlock()
+		return nil
+	}
+
+	testScheduler.defragCluster()
+	assert.Equal(t, []string{"etcd-2", "etcd-3", "etcd-1"}, defragged)
+
+	// An unhealthy cluster is left alone.
+	healthy = false
+	defragged = nil
+	testScheduler.defragCluster()
+	assert.Empty(t, defragged)
+
+	// The admin endpoint only triggers a defrag on POST.
+	healthy = true
+	server := httptest.NewServer(testScheduler.adminMux(&MockSchedulerDriver{}))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/defrag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/defrag", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	for i := 0; i < 100; i++ {
+		mut.Lock()
+		n := len(defragged)
+		mut.Unlock()
+		if n == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mut.Lock()
+	assert.Equal(t, []string{"etcd-2", "etcd-3", "etcd-1"}, defragged)
+	mut.Unlock()
+}
+
+// killingDriver reports kills back to the scheduler, as the vendored mock
+// can not run callbacks.
+type killingDriver struct {
+	*MockSchedulerDriver
+}
+
+func (d killingDriver) KillTask(tid *mesos.TaskID) (mesos.Status, error) {
+	status, err := d.MockSchedulerDriver.KillTask(tid)
+	go d.scheduler.StatusUpdate(d, util.NewTaskStatus(tid, mesos.TaskState_TASK_KILLED))
+	return status, err
+}
+
+func TestRollingUpgradeKeepsQuorum(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.upgradePollInterval = time.Millisecond
+	testScheduler.LaunchTimeout = 10 * time.Second
+	// Capping the cluster at its size must not stop the upgrade from
+	// adding each replacement before removing the member it replaces.
+	testScheduler.MaxInstanceCount = 3
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	var (
+		mut        sync.Mutex
+		minRunning = 3
+		removed    []string
+	)
+	testScheduler.healthCheck = func(running map[string]*config.Node) error {
+		mut.Lock()
+		defer mut.Unlock()
+		if len(running) < minRunning {
+			minRunning = len(running)
+		}
+		return nil
+	}
+	testScheduler.memberList = func(running map[string]*config.Node) (map[string]string, error) {
+		members := map[string]string{}
+		for name := range running {
+			members[name] = name
+		}
+		return members, nil
+	}
+	testScheduler.removeInstance = func(running map[string]*config.Node, task string, _ int, _ bool) error {
+		mut.Lock()
+		defer mut.Unlock()
+		assert.Equal(t, 4, len(running),
+			"The replacement should be running before %s is removed.", task)
+		removed = append(removed, task)
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{scheduler: testScheduler}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+	mockdriver.On("KillTask", mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+	driver := killingDriver{mockdriver}
+
+	for _, id := range []string{"1", "2", "3"} {
+		status := util.NewTaskStatus(
+			util.NewTaskID("etcd-"+id+" localhost 0 0 0"),
+			mesos.TaskState_TASK_RUNNING,
+		)
+		status.SlaveId = util.NewSlaveID("slave-" + id)
+		testScheduler.StatusUpdate(driver, status)
+	}
+	for _, id := range []string{"4", "5", "6"} {
+		testScheduler.offerCache.Push(NewOffer(id))
+	}
+
+	go testScheduler.SerialLauncher(driver)
+	defer close(testScheduler.launchChan)
+
+	err := testScheduler.rollingUpgrade(driver, "v2", nil)
+	assert.NoError(t, err)
+
+	mut.Lock()
+	defer mut.Unlock()
+	assert.Equal(t, 3, minRunning, "The cluster should never shrink below its desired size.")
+	assert.Equal(t, []string{"etcd-1", "etcd-2", "etcd-3"}, removed)
+	running := testScheduler.RunningCopy()
+	assert.Equal(t, 3, len(running))
+	for name, node := range running {
+		assert.Equal(t, "v2", node.Version, "%s should be running the new version", name)
+	}
+}
+
+func TestConcurrentRollingUpgradeConflicts(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	server := httptest.NewServer(testScheduler.adminMux(&MockSchedulerDriver{}))
+	defer server.Close()
+
+	// An upgrade is already underway.
+	assert.True(t, testScheduler.claimUpgrade())
+	resp, err := http.PostForm(server.URL+"/rolling-upgrade", url.Values{"version": {"v2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Contains(t, string(body), "already underway")
+
+	// Once it finishes, a new one may start.
+	assert.NoError(t, testScheduler.runUpgrade(&MockSchedulerDriver{}, "v2", nil))
+	resp, err = http.PostForm(server.URL+"/rolling-upgrade", url.Values{"version": {"v3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMemberVersionsTracked(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(2, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.running["etcd-2"] = &config.Node{Name: "etcd-2"}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.alarmList = func(map[string]*config.Node) ([]rpc.Alarm, error) {
+		return []rpc.Alarm{}, nil
+	}
+	versions := map[string]string{"etcd-1": "2.3.7", "etcd-2": "2.3.7"}
+	testScheduler.memberVersions = func(map[string]*config.Node) map[string]string {
+		return versions
+	}
+
+	testScheduler.checkClusterHealth()
+	assert.Equal(t, "2.3.7", testScheduler.RunningCopy()["etcd-1"].EtcdVersion)
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().MixedVersions)
+
+	versions = map[string]string{"etcd-1": "2.3.7", "etcd-2": "3.0.0"}
+	testScheduler.checkClusterHealth()
+	assert.Equal(t, "3.0.0", testScheduler.RunningCopy()["etcd-2"].EtcdVersion)
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().MixedVersions,
+		"Members reporting different versions should be flagged.")
+}
+
+func TestOffersDeclinedAtDesiredSize(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+	mockdriver.On("ReviveOffers").Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	running := util.NewTaskStatus(util.NewTaskID("etcd-1 localhost 0 0 0"), mesos.TaskState_TASK_RUNNING)
+	running.SlaveId = util.NewSlaveID("slave-1")
+	testScheduler.StatusUpdate(mockdriver, running)
+
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("2")})
+	assert.Equal(t, 0, testScheduler.offerCache.Len(),
+		"Offers should not be cached while the cluster is at its desired size.")
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("2"),
+		&mesos.Filters{RefuseSeconds: proto.Float64(testScheduler.FullRefuseSeconds)})
+
+	// Losing a member revives offers and lets them be cached again.
+	lost := util.NewTaskStatus(util.NewTaskID("etcd-1 localhost 0 0 0"), mesos.TaskState_TASK_LOST)
+	lost.SlaveId = util.NewSlaveID("slave-1")
+	testScheduler.StatusUpdate(mockdriver, lost)
+	mockdriver.AssertNumberOfCalls(t, "ReviveOffers", 1)
+	testScheduler.state = Mutable
+
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("3")})
+	assert.Equal(t, 1, testScheduler.offerCache.Len())
+}
+
+func TestLaunchesSuppressedAfterReseed(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.ReseedCooldown = time.Minute
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	mockdriver := &MockSchedulerDriver{}
+
+	testScheduler.lastReseed = time.Now()
+	assert.False(t, testScheduler.shouldLaunch(mockdriver),
+		"Launches should be suppressed during the cooldown after a reseed.")
+
+	testScheduler.lastReseed = time.Now().Add(-2 * time.Minute)
+	assert.True(t, testScheduler.shouldLaunch(mockdriver))
+}
+
+func TestOtherClusterSlavesDeprioritized(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(2, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.FrameworkName = "etcd-a"
+	testScheduler.ClusterAttribute = "etcd-clusters"
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	withClusters := func(id, clusters string) *mesos.Offer {
+		offer := NewOffer(id)
+		offer.Attributes = []*mesos.Attribute{{
+			Name: proto.String("etcd-clusters"),
+			Type: mesos.Value_TEXT.Enum(),
+			Text: &mesos.Value_Text{Value: proto.String(clusters)},
+		}}
+		return offer
+	}
+	shared := withClusters("1", "etcd-a,etcd-b")
+	own := withClusters("2", "etcd-a")
+	assert.True(t, testScheduler.hostsOtherCluster(shared))
+	assert.False(t, testScheduler.hostsOtherCluster(own))
+
+	testScheduler.offerCache.Push(shared)
+	testScheduler.offerCache.Push(own)
+	testScheduler.launchOne(mockdriver)
+	mockdriver.AssertCalled(t, "LaunchTasks",
+		[]*mesos.OfferID{util.NewOfferID("2")}, mock.Anything, mock.Anything)
+
+	// With avoidance enabled, such slaves are not used at all.
+	testScheduler.AvoidOtherClusters = true
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{withClusters("3", "etcd-b")})
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("3"), mock.Anything)
+}
+
+func TestLaunchDeclinesOfferWithoutPorts(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	// The ports were accepted into the cache but are gone by launch time.
+	offer := NewOffer("1")
+	testScheduler.offerCache.Push(offer)
+	offer.Resources = offer.Resources[:3]
+
+	assert.NotPanics(t, func() { testScheduler.launchOne(mockdriver) })
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("1"), mock.Anything)
+	mockdriver.AssertNotCalled(t, "LaunchTasks", mock.Anything, mock.Anything, mock.Anything)
+	assert.Equal(t, 1, len(testScheduler.launchChan),
+		"A declined launch should be queued again.")
+
+	// Empty and inverted ranges contribute no ports at all.
+	offer.Resources = append(offer.Resources, util.NewRangesResource("ports", []*mesos.Value_Range{
+		util.NewValueRange(uint64(10), uint64(5)),
+	}))
+	resources, err := parseOffer(offer)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(resources.ports))
+}
+
+func TestUndersizedAgentsDeclined(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.MinAgentCpus = 2
+	testScheduler.MinAgentMem = 1024
+	// Hold off the rescind of cached offers until the test is done.
+	testScheduler.clock = clock.NewFake(time.Now())
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	// NewOffer fits a task, but its slave has only 1 cpu and 256 MB.
+	small := NewOffer("1")
+	large := NewOffer("2")
+	large.Resources = append(large.Resources,
+		util.NewScalarResource("cpus", 3),
+		util.NewScalarResource("mem", 2048),
+	)
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{small, large})
+
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("1"), mock.Anything)
+	mockdriver.AssertNotCalled(t, "DeclineOffer", util.NewOfferID("2"), mock.Anything)
+	assert.Equal(t, 1, testScheduler.offerCache.Len())
+}
+
+func TestOffersBeyondNeededLaunchesDeclined(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 0, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.clock = clock.NewFake(time.Now())
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1", SlaveID: "slave-x"}
+	testScheduler.pending["etcd-2"] = "slave-y"
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	// Only one more launch is needed, so only one offer is kept.
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("1"), NewOffer("2"), NewOffer("3")})
+
+	assert.Equal(t, 1, testScheduler.offerCache.Len())
+	mockdriver.AssertNotCalled(t, "DeclineOffer", util.NewOfferID("1"), mock.Anything)
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("2"), mock.Anything)
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("3"), mock.Anything)
+}
+
+func TestCachedOffersCounted(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 10, 0, false, []*mesos.CommandInfo_URI{}, false, 0, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	fakeClock := clock.NewFake(time.Now())
+	testScheduler.clock = fakeClock
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("1"), NewOffer("2")})
+	assert.Equal(t, uint32(2), testScheduler.StatsCopy().CachedOffers)
+
+	// One offer is used before it expires, the other is not.
+	popped := testScheduler.offerCache.BlockingPop()
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().CachedOffers)
+	testScheduler.reapOffers(mockdriver)
+	mockdriver.AssertNotCalled(t, "DeclineOffer", mock.Anything, mock.Anything)
+
+	fakeClock.Advance(5*time.Second + time.Millisecond)
+	testScheduler.reapOffers(mockdriver)
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().CachedOffers)
+	mockdriver.AssertNotCalled(t, "DeclineOffer", popped.Id, mock.Anything)
+	mockdriver.AssertNumberOfCalls(t, "DeclineOffer", 1)
+}
+
+func TestOffersSpawnNoGoroutines(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1000, 10, 0, false, []*mesos.CommandInfo_URI{}, false, 0, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.clock = clock.NewFake(time.Now())
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 500; i++ {
+		testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer(strconv.Itoa(i))})
+	}
+	assert.Equal(t, 500, testScheduler.offerCache.Len())
+	assert.True(t, runtime.NumGoroutine() < before+10,
+		"caching offers should not start a goroutine per offer")
+}
+
+func TestNoMatchTimeoutTrips(t *gotesting.T) {
+	// Each task wants more memory than NewOffer provides.
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 0, 0.5, 4096, 1)
+	testScheduler.state = Mutable
+	testScheduler.NoMatchTimeout = time.Minute
+	testScheduler.NoMatchShutdown = true
+	fakeClock := clock.NewFake(time.Now())
+	testScheduler.clock = fakeClock
+	shutdowns := 0
+	testScheduler.shutdown = func() { shutdowns++ }
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	for i := 0; i < 5; i++ {
+		testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer(strconv.Itoa(i))})
+	}
+	assert.Equal(t, 0, shutdowns, "declines within the window must not trip it")
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().NoMatchingOffers)
+
+	fakeClock.Advance(time.Minute)
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("5")})
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("6")})
+	assert.Equal(t, 1, shutdowns)
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().NoMatchingOffers)
+
+	// An offer that fits resets the watchdog.
+	testScheduler.memPerTask = 128
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("7")})
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().NoMatchingOffers)
+}
+
+func TestBootstrapDeadlineShutsDownWithoutQuorum(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.BootstrapDeadline = time.Minute
+	testScheduler.BootstrapShutdown = true
+	fakeClock := clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.clock = fakeClock
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	shutdown := make(chan struct{})
+	testScheduler.shutdown = func() { close(shutdown) }
+
+	testScheduler.mut.Lock()
+	testScheduler.armBootstrapDeadline()
+	testScheduler.armBootstrapDeadline()
+	testScheduler.mut.Unlock()
+
+	for elapsed := time.Duration(0); elapsed < time.Minute; {
+		select {
+		case <-shutdown:
+			t.Fatalf("shut down after only %s", elapsed)
+		default:
+		}
+		if fakeClock.Waiters() > 0 {
+			fakeClock.Advance(testScheduler.bootstrapPollInterval)
+			elapsed += testScheduler.bootstrapPollInterval
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	select {
+	case <-shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no shutdown once the bootstrap deadline passed")
+	}
+}
+
+func TestBootstrapDeadlineArmedOnlyForNewCluster(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.BootstrapDeadline = time.Minute
+	testScheduler.clock = clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	// A replacement for a lost member of an established cluster.
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1", SlaveID: "slave-1"}
+	testScheduler.running["etcd-2"] = &config.Node{Name: "etcd-2", SlaveID: "slave-2"}
+	testScheduler.offerCache.Push(NewOffer("3"))
+	testScheduler.launchOne(mockdriver)
+	if len(mockdriver.launched) != 1 {
+		t.Fatalf("expected 1 launched task, got %d", len(mockdriver.launched))
+	}
+	assert.False(t, testScheduler.bootstrapArmed,
+		"a replacement launch should not arm the bootstrap deadline")
+
+	// The first launch of a new cluster does.
+	testScheduler = NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.BootstrapDeadline = time.Minute
+	testScheduler.clock = clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.launchOne(mockdriver)
+	if len(mockdriver.launched) != 2 {
+		t.Fatalf("expected 2 launched tasks, got %d", len(mockdriver.launched))
+	}
+	testScheduler.mut.RLock()
+	defer testScheduler.mut.RUnlock()
+	assert.True(t, testScheduler.bootstrapArmed)
+}
+
+func TestHostnameDedupRejectsSharedHost(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.clock = clock.NewFake(time.Now())
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	testScheduler.SetDedupKey(offercache.ByHostname)
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	running := util.NewTaskStatus(util.NewTaskID("etcd-1 localhost 0 0 0"), mesos.TaskState_TASK_RUNNING)
+	running.SlaveId = util.NewSlaveID("slave-1")
+	testScheduler.StatusUpdate(mockdriver, running)
+
+	// A different slave on the same host as etcd-1.
+	sameHost := NewOffer("2")
+	otherHost := NewOffer("3")
+	otherHost.Hostname = proto.String("otherhost")
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{sameHost, otherHost})
+
+	mockdriver.AssertCalled(t, "DeclineOffer", util.NewOfferID("2"), mock.Anything)
+	mockdriver.AssertNotCalled(t, "DeclineOffer", util.NewOfferID("3"), mock.Anything)
+	assert.Equal(t, 1, testScheduler.offerCache.Len())
+}
+
+func TestKillEndpoint(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(2, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("KillTask", mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+
+	for _, id := range []string{"1", "2"} {
+		status := util.NewTaskStatus(
+			util.NewTaskID("etcd-"+id+" localhost 0 0 0"),
+			mesos.TaskState_TASK_RUNNING,
+		)
+		status.SlaveId = util.NewSlaveID("slave-" + id)
+		testScheduler.StatusUpdate(mockdriver, status)
+	}
+
+	kill := func(name string) int {
+		resp, err := http.PostForm(server.URL+"/kill", url.Values{"node": {name}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusNotFound, kill("etcd-3"))
+	assert.Equal(t, http.StatusOK, kill("etcd-2"))
+	mockdriver.AssertCalled(t, "KillTask", util.NewTaskID("etcd-2 localhost 0 0 0"))
+
+	delete(testScheduler.running, "etcd-2")
+	assert.Equal(t, http.StatusConflict, kill("etcd-1"),
+		"The last member should not be killed.")
+	mockdriver.AssertNumberOfCalls(t, "KillTask", 1)
+}
+
+func TestForceRemoveIgnoresQuorumGuard(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	for _, name := range []string{"etcd-1", "etcd-2", "etcd-3"} {
+		testScheduler.running[name] = &config.Node{Name: name}
+		testScheduler.tasks[name] = util.NewTaskID(name + " localhost 0 0 0")
+	}
+	removed := []string{}
+	testScheduler.removeInstance = func(running map[string]*config.Node, name string, retries int, force bool) error {
+		if !force {
+			return rpc.ErrRemovalBreaksQuorum
+		}
+		removed = append(removed, name)
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("KillTask", util.NewTaskID("etcd-2 localhost 0 0 0")).
+		Return(mesos.Status_DRIVER_RUNNING, nil).Once()
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+
+	forceRemove := func(confirm string) int {
+		resp, err := http.PostForm(server.URL+"/force-remove",
+			url.Values{"node": {"etcd-2"}, "confirm": {confirm}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusForbidden, forceRemove(""),
+		"Force removal should be disabled without a token.")
+	testScheduler.ForceRemoveToken = "break-glass"
+	assert.Equal(t, http.StatusForbidden, forceRemove("wrong"))
+	assert.Empty(t, removed)
+	mockdriver.AssertNotCalled(t, "KillTask", mock.Anything)
+
+	assert.Equal(t, http.StatusOK, forceRemove("break-glass"))
+	assert.Equal(t, []string{"etcd-2"}, removed)
+	mockdriver.AssertNumberOfCalls(t, "KillTask", 1)
+}
+func TestSafeModeBlocksMutationsUntilReleased(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.ReseedCooldown = 0
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.running["etcd-2"] = &config.Node{Name: "etcd-2"}
+	members := map[string]string{"etcd-1": "1", "etcd-2": "2", "etcd-3": "3"}
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		configured := map[string]string{}
+		for name, id := range members {
+			configured[name] = id
+		}
+		return configured, nil
+	}
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberHealthy = func(*config.Node) bool { return true }
+	removed := []string{}
+	testScheduler.removeInstance = func(_ map[string]*config.Node, task string, _ int, _ bool) error {
+		removed = append(removed, task)
+		delete(members, task)
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+
+	testScheduler.EnterSafeMode()
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().SafeMode)
+	assert.False(t, testScheduler.shouldLaunch(mockdriver))
+	assert.NoError(t, testScheduler.Prune())
+	assert.Empty(t, removed)
+
+	resp, err := http.Get(server.URL + "/unsafe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.False(t, testScheduler.shouldLaunch(mockdriver))
+
+	resp, err = http.Post(server.URL+"/unsafe", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().SafeMode)
+	assert.NoError(t, testScheduler.Prune())
+	assert.Equal(t, []string{"etcd-3"}, removed)
+	assert.True(t, testScheduler.shouldLaunch(mockdriver))
+}
+
+func TestStatsdReport(t *gotesting.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.StatsdAddress = listener.LocalAddr().String()
+	testScheduler.StatsdPrefix = "etcd.prod"
+	atomic.StoreUint32(&testScheduler.Stats.RunningServers, 3)
+	atomic.StoreUint32(&testScheduler.Stats.FailedServers, 2)
+	assert.NoError(t, testScheduler.reportStatsd())
+
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	packet := make([]byte, 4096)
+	n, _, err := listener.ReadFrom(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(packet[:n]), "\n")
+	assert.Contains(t, lines, "etcd.prod.running_servers:3|g")
+	assert.Contains(t, lines, "etcd.prod.failed_servers:2|g")
+	assert.Contains(t, lines, "etcd.prod.healthy:1|g")
+	assert.Equal(t, reflect.TypeOf(Stats{}).NumField(), len(lines),
+		"Every stat should be sent.")
+}
+
+func TestAdminBindFailureContinues(t *gotesting.T) {
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	for _, tc := range []struct {
+		policy        AdminBindPolicy
+		wantsShutdown bool
+	}{
+		{AdminBindExit, true},
+		{AdminBindContinue, false},
+	} {
+		testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+		testScheduler.AdminBindFailure = tc.policy
+		shutdown := false
+		testScheduler.shutdown = func() { shutdown = true }
+
+		// AdminHTTP returns rather than serving, as the port is taken.
+		testScheduler.AdminHTTP(port, &MockSchedulerDriver{})
+		assert.Equal(t, tc.wantsShutdown, shutdown, string(tc.policy))
+	}
+}
+
+func TestAdminResponsesGzipped(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1", Host: "host-1"}
+	server := httptest.NewServer(testScheduler.AdminHandler(&MockSchedulerDriver{}))
+	defer server.Close()
+
+	// Setting Accept-Encoding ourselves stops the transport from
+	// transparently decompressing the response.
+	req, _ := http.NewRequest("GET", server.URL+"/members", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var members []config.Node
+	assert.NoError(t, json.NewDecoder(gz).Decode(&members))
+	assert.Equal(t, []config.Node{{Name: "etcd-1", Host: "host-1"}}, members)
+
+	// Clients that don't ask for gzip get a plain response.
+	req.Header.Set("Accept-Encoding", "identity")
+	plain, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Body.Close()
+	assert.Equal(t, "", plain.Header.Get("Content-Encoding"))
+	members = nil
+	assert.NoError(t, json.NewDecoder(plain.Body).Decode(&members))
+	assert.Equal(t, 1, len(members))
+}
+
+func TestAdminIndexAndNotFound(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	mockdriver := &MockSchedulerDriver{}
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints := []adminEndpoint{}
+	err = json.NewDecoder(resp.Body).Decode(&endpoints)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, adminEndpoints, endpoints)
+
+	resp, err = http.Get(server.URL + "/no-such-endpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notFound := adminNotFound{}
+	err = json.NewDecoder(resp.Body).Decode(&notFound)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "/no-such-endpoint", notFound.Path)
+	assert.Equal(t, adminEndpoints, notFound.Endpoints)
+}
+
+func TestToggleSingleInstancePerSlave(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+
+	status := util.NewTaskStatus(util.NewTaskID("etcd-1 localhost 0 0 0"), mesos.TaskState_TASK_RUNNING)
+	status.SlaveId = util.NewSlaveID("slave-1")
+	testScheduler.StatusUpdate(mockdriver, status)
+
+	set := func(enabled string) {
+		resp, err := http.PostForm(server.URL+"/single-instance-per-slave",
+			url.Values{"enabled": {enabled}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("1")})
+	assert.Equal(t, 0, testScheduler.offerCache.Len(),
+		"An offer from an occupied slave should be declined.")
+
+	set("false")
+	assert.False(t, testScheduler.SingleInstancePerSlave())
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("1")})
+	assert.Equal(t, 1, testScheduler.offerCache.Len(),
+		"An offer from an occupied slave should be accepted once relaxed.")
+
+	set("true")
+	assert.Equal(t, 0, testScheduler.offerCache.Len(),
+		"Cached offers from occupied slaves should be dropped once tightened.")
+	mockdriver.AssertNumberOfCalls(t, "DeclineOffer", 2)
+}
+
+func TestPersistFrameworkIDRetriesTransientErrors(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	testScheduler.ZkConnect = "zk://127.0.0.1:2181/etcd"
+	testScheduler.persistBackoff = time.Millisecond
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	attempts := 0
+	testScheduler.persistFrameworkID = func(*mesos.FrameworkID, []string, string, string) error {
+		attempts++
+		if attempts == 1 {
+			return zk.ErrConnectionClosed
+		}
+		return nil
+	}
+	shutdown := false
+	testScheduler.shutdown = func() { shutdown = true }
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("ReconcileTasks", mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	masterInfo := util.NewMasterInfo("master-1", 0, 0)
+	masterInfo.Hostname = proto.String("test-host")
+	testScheduler.Registered(mockdriver, util.NewFrameworkID("framework-1"), masterInfo)
+
+	assert.Equal(t, 2, attempts)
+	assert.False(t, shutdown, "A transient ZK error should not shut the scheduler down.")
+}
+
+func TestPersistFrameworkIDAlwaysAttempted(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	testScheduler.persistBackoff = time.Millisecond
+	attempts := 0
+	testScheduler.persistFrameworkID = func(*mesos.FrameworkID, []string, string, string) error {
+		attempts++
+		return zk.ErrConnectionClosed
+	}
+	for _, retries := range []int{0, -1} {
+		attempts = 0
+		testScheduler.PersistRetries = retries
+		err := testScheduler.persistFrameworkIDWithRetries(util.NewFrameworkID("framework-1"))
+		assert.Equal(t, zk.ErrConnectionClosed, err,
+			"-persist-retries=%d should not report success unattempted", retries)
+		assert.Equal(t, 1, attempts)
+	}
+}
+
+func TestFrameworkEndpoint(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	testScheduler.FrameworkName = "etcd"
+	testScheduler.ZkConnect = "zk://127.0.0.1:2181/mesos/etcd"
+	testScheduler.ZkChroot = "/mesos/etcd"
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.persistFrameworkID = func(*mesos.FrameworkID, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("ReconcileTasks", mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+
+	masterInfo := util.NewMasterInfo("master-1", 0, 5050)
+	masterInfo.Hostname = proto.String("master.example.com")
+	testScheduler.Registered(mockdriver, util.NewFrameworkID("framework-1"), masterInfo)
+
+	resp, err := http.Get(server.URL + "/framework")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := FrameworkState{}
+	err = json.NewDecoder(resp.Body).Decode(&state)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, FrameworkState{
+		FrameworkID:   "framework-1",
+		FrameworkName: "etcd",
+		MasterID:      "master-1",
+		MasterHost:    "master.example.com",
+		MasterPort:    5050,
+		ZkPath:        "/mesos/etcd/etcd_framework_id",
+	}, state)
+}
+
+func TestCompactEndpoint(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	testScheduler.CompactRetention = 100
+	testScheduler.currentRevision = func(map[string]*config.Node) (int64, error) {
+		return 1000, nil
+	}
+	compacted := []int64{}
+	testScheduler.compact = func(_ map[string]*config.Node, rev int64) error {
+		compacted = append(compacted, rev)
+		return nil
+	}
+	server := httptest.NewServer(testScheduler.adminMux(&MockSchedulerDriver{}))
+	defer server.Close()
+
+	resp, err := http.PostForm(server.URL+"/compact", url.Values{"rev": {"42"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(42), testScheduler.StatsCopy().LastCompactRev)
+
+	// Busy clusters pass 2^32 revisions, which must not wrap.
+	resp, err = http.PostForm(server.URL+"/compact", url.Values{"rev": {"5000000000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, int64(5000000000), testScheduler.StatsCopy().LastCompactRev)
+	lines, err := testScheduler.statsdLines()
+	assert.NoError(t, err)
+	assert.Contains(t, strings.Join(lines, "\n"), "last_compact_revision:5000000000|g")
+
+	// Without a revision, the retention decides.
+	resp, err = http.PostForm(server.URL+"/compact", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, []int64{42, 5000000000, 900}, compacted)
+
+	resp, err = http.PostForm(server.URL+"/compact", url.Values{"rev": {"soon"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestHealthProbesBoundedByConcurrency(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(6, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	testScheduler.HealthCheckConcurrency = 2
+	for i := 1; i <= 6; i++ {
+		name := fmt.Sprintf("etcd-%d", i)
+		testScheduler.running[name] = &config.Node{Name: name, Host: "10.0.0.1", ClientPort: uint64(i)}
+	}
+	var (
+		mut               sync.Mutex
+		inFlight, maxSeen int
+	)
+	testScheduler.memberHealthy = func(node *config.Node) bool {
+		mut.Lock()
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		mut.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mut.Lock()
+		inFlight--
+		mut.Unlock()
+		return node.Name != "etcd-3"
+	}
+
+	endpoints := testScheduler.clientEndpoints(true)
+	assert.Equal(t, 5, len(endpoints))
+	assert.Equal(t, 2, maxSeen, "At most 2 members should be probed at once.")
+}
+
+func TestSnapshotEndpointStreamsSnapshot(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	testScheduler.FrameworkName = "etcd"
+	testScheduler.clock = clock.NewFake(time.Unix(1000000, 0))
+	server := httptest.NewServer(testScheduler.adminMux(&MockSchedulerDriver{}))
+	defer server.Close()
+
+	testScheduler.openSnapshot = func(map[string]*config.Node) (string, io.ReadCloser, error) {
+		return "", nil, rpc.ErrNoRunningMembers
+	}
+	resp, err := http.Get(server.URL + "/snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.openSnapshot = func(running map[string]*config.Node) (string, io.ReadCloser, error) {
+		assert.NotNil(t, running["etcd-1"])
+		return "etcd-1", ioutil.NopCloser(strings.NewReader("bolt db contents")), nil
+	}
+	resp, err = http.Get(server.URL + "/snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="etcd-etcd-1-1000000.db"`,
+		resp.Header.Get("Content-Disposition"))
+	payload, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "bolt db contents", string(payload))
+}
+
+func TestEndpointsEndpoint(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, false, 4096, 1, 256, 1)
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1", Host: "10.0.0.1", ClientPort: 2379}
+	testScheduler.running["etcd-2"] = &config.Node{Name: "etcd-2", Host: "10.0.0.2", ClientPort: 2379}
+	testScheduler.memberHealthy = func(node *config.Node) bool { return node.Name == "etcd-2" }
+	server := httptest.NewServer(testScheduler.adminMux(&MockSchedulerDriver{}))
+	defer server.Close()
+
+	get := func(path, accept string) string {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+	assert.Equal(t, "http://10.0.0.1:2379,http://10.0.0.2:2379\n", get("/endpoints", ""))
+	assert.Equal(t, `["http://10.0.0.1:2379","http://10.0.0.2:2379"]`+"\n",
+		get("/endpoints", "application/json"))
+	assert.Equal(t, "http://10.0.0.2:2379\n", get("/endpoints?healthy=true", ""))
+}
+
+func TestExternalSeedJoinsExistingCluster(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	external := &config.Node{Name: "legacy-1", Host: "10.0.0.1", RPCPort: 2380, ClientPort: 2379, Type: "existing"}
+	testScheduler.ExternalSeed = []*config.Node{external}
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{"legacy-1": "1"}, nil
+	}
+	testScheduler.removeInstance = func(_ map[string]*config.Node, task string, _ int, _ bool) error {
+		t.Errorf("External seed member %s should not be pruned.", task)
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.launchOne(mockdriver)
+
+	if len(mockdriver.launched) != 1 {
+		t.Fatalf("expected 1 launched task, got %d", len(mockdriver.launched))
+	}
+	payload := []*config.Node{}
+	if err := json.Unmarshal(mockdriver.launched[0].Data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) != 2 {
+		t.Fatalf("expected the new node and the external seed, got %+v", payload)
+	}
+	assert.Equal(t, "existing", payload[0].Type,
+		"The first node should join the external cluster.")
+	assert.Equal(t, *external, *payload[1])
+}
+
+func TestClusterTokenDerivedFromFrameworkName(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.FrameworkName = "etcd-prod"
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.launchOne(mockdriver)
+
+	if len(mockdriver.launched) != 1 {
+		t.Fatalf("expected 1 launched task, got %d", len(mockdriver.launched))
+	}
+	payload := []*config.Node{}
+	if err := json.Unmarshal(mockdriver.launched[0].Data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "etcd-mesos-etcd-prod", payload[0].ClusterToken)
+}
+
+func TestKillGracePeriodInTaskPayload(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.KillGracePeriod = 45 * time.Second
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.launchOne(mockdriver)
+
+	if len(mockdriver.launched) != 1 {
+		t.Fatalf("expected 1 launched task, got %d", len(mockdriver.launched))
+	}
+	payload := []*config.Node{}
+	if err := json.Unmarshal(mockdriver.launched[0].Data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 45, payload[0].KillGracePeriodSeconds)
+}
+
+func TestDataHostPathMounted(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.DataHostPath = "/var/lib/etcd-mesos"
+	testScheduler.FrameworkName = "etcd-a"
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.launchOne(mockdriver)
+
+	if len(mockdriver.launched) != 1 {
+		t.Fatalf("expected 1 launched task, got %d", len(mockdriver.launched))
+	}
+	volumes := mockdriver.launched[0].GetExecutor().GetContainer().GetVolumes()
+	if assert.Len(t, volumes, 1) {
+		name := mockdriver.launched[0].GetExecutor().GetExecutorId().GetValue()
+		assert.Equal(t, "/var/lib/etcd-mesos/etcd-a/"+name, volumes[0].GetHostPath())
+		assert.Equal(t, hostDataContainerPath, volumes[0].GetContainerPath())
+		assert.Equal(t, mesos.Volume_RW, volumes[0].GetMode())
+	}
+	payload := []*config.Node{}
+	if err := json.Unmarshal(mockdriver.launched[0].Data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, hostDataContainerPath, payload[0].DataDir,
+		"etcd should keep its data in the mount")
+}
+
+func TestDataHostPathNotReusedByReplacement(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.DataHostPath = "/var/lib/etcd-mesos"
+	testScheduler.FrameworkName = "etcd-a"
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.launchOne(mockdriver)
+	if len(mockdriver.launched) != 1 {
+		t.Fatalf("expected 1 launched task, got %d", len(mockdriver.launched))
+	}
+	// The member is lost, and its replacement lands on the same slave,
+	// where the lost member's data is still on disk.
+	lost := util.NewTaskStatus(mockdriver.launched[0].TaskId, mesos.TaskState_TASK_LOST)
+	lost.SlaveId = mockdriver.launched[0].SlaveId
+	testScheduler.StatusUpdate(mockdriver, lost)
+	testScheduler.state = Mutable
+	again := NewOffer("2")
+	again.SlaveId = mockdriver.launched[0].SlaveId
+	testScheduler.offerCache.Push(again)
+	testScheduler.launchOne(mockdriver)
+	if len(mockdriver.launched) != 2 {
+		t.Fatalf("expected 2 launched tasks, got %d", len(mockdriver.launched))
+	}
+	assert.Equal(t, mockdriver.launched[0].SlaveId.GetValue(), mockdriver.launched[1].SlaveId.GetValue())
+
+	hostPath := func(task *mesos.TaskInfo) string {
+		volumes := task.GetExecutor().GetContainer().GetVolumes()
+		if len(volumes) != 1 {
+			t.Fatalf("expected 1 volume, got %d", len(volumes))
+		}
+		return volumes[0].GetHostPath()
+	}
+	replacement := mockdriver.launched[1].GetExecutor().GetExecutorId().GetValue()
+	assert.Equal(t, "/var/lib/etcd-mesos/etcd-a/"+replacement, hostPath(mockdriver.launched[1]))
+	assert.NotEqual(t, hostPath(mockdriver.launched[0]), hostPath(mockdriver.launched[1]),
+		"a replacement must not start from the removed member's data")
+
+	// Another framework sharing the path keeps to its own directory.
+	testScheduler.FrameworkName = "etcd-b"
+	assert.Equal(t, "/var/lib/etcd-mesos/etcd-b/"+replacement, testScheduler.dataHostPath(replacement))
+}
+
+func TestDiscoveryURLInNewMemberPayload(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(2, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.DiscoveryURL = "https://discovery.example.com/abc"
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	// etcd serves nothing until every discovering member has registered.
+	testScheduler.healthCheck = func(running map[string]*config.Node) error {
+		if len(running) == 0 {
+			return nil
+		}
+		return errors.New("cluster forming")
+	}
+	testScheduler.memberList = func(running map[string]*config.Node) (map[string]string, error) {
+		if len(running) == 0 {
+			return map[string]string{}, nil
+		}
+		return nil, errors.New("cluster forming")
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	for i, id := range []string{"1", "2"} {
+		testScheduler.offerCache.Push(NewOffer(id))
+		testScheduler.launchOne(mockdriver)
+		if len(mockdriver.launched) != i+1 {
+			t.Fatalf("expected %d launched tasks, got %d", i+1, len(mockdriver.launched))
+		}
+		payload := []*config.Node{}
+		if err := json.Unmarshal(mockdriver.launched[i].Data, &payload); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "new", payload[0].Type)
+		assert.Equal(t, testScheduler.DiscoveryURL, payload[0].Discovery)
+		testScheduler.StatusUpdate(mockdriver, util.NewTaskStatus(
+			mockdriver.launched[i].TaskId, mesos.TaskState_TASK_RUNNING))
+	}
+	assert.False(t, testScheduler.discovering,
+		"membership should be managed once the cluster has formed")
+}
+
+func TestDiscoveryURLUsedOnlyOnce(t *gotesting.T) {
+	newScheduler := func() *EtcdScheduler {
+		testScheduler := NewEtcdScheduler(2, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+		testScheduler.state = Mutable
+		testScheduler.DiscoveryURL = "https://discovery.example.com/abc"
+		testScheduler.TotalLoss = TotalLossSeedNew
+		testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+			return map[string]string{}, nil
+		}
+		testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+			return nil
+		}
+		testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+		testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+			return map[string]string{}, nil
+		}
+		return testScheduler
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+	launch := func(testScheduler *EtcdScheduler, offerID string) *config.Node {
+		launched := len(mockdriver.launched)
+		testScheduler.offerCache.Push(NewOffer(offerID))
+		testScheduler.launchOne(mockdriver)
+		if len(mockdriver.launched) != launched+1 {
+			t.Fatalf("expected %d launched tasks, got %d", launched+1, len(mockdriver.launched))
+		}
+		payload := []*config.Node{}
+		if err := json.Unmarshal(mockdriver.launched[launched].Data, &payload); err != nil {
+			t.Fatal(err)
+		}
+		return payload[0]
+	}
+	update := func(testScheduler *EtcdScheduler, node *config.Node, state mesos.TaskState) {
+		status := util.NewTaskStatus(util.NewTaskID(node.String()), state)
+		status.SlaveId = util.NewSlaveID(node.SlaveID)
+		testScheduler.StatusUpdate(mockdriver, status)
+	}
+
+	// A member failing while the cluster forms leaves its replacement
+	// to join without the discovery token.
+	testScheduler := newScheduler()
+	first := launch(testScheduler, "1")
+	assert.Equal(t, testScheduler.DiscoveryURL, first.Discovery)
+	update(testScheduler, first, mesos.TaskState_TASK_FAILED)
+	assert.False(t, testScheduler.discovering)
+	replacement := launch(testScheduler, "2")
+	assert.Equal(t, "new", replacement.Type)
+	assert.Equal(t, "", replacement.Discovery,
+		"the discovery token is spent once its first member has registered")
+
+	// Seeding a new cluster after a total loss does not reuse the token.
+	testScheduler = newScheduler()
+	members := []*config.Node{}
+	for _, id := range []string{"3", "4"} {
+		member := launch(testScheduler, id)
+		assert.Equal(t, testScheduler.DiscoveryURL, member.Discovery)
+		update(testScheduler, member, mesos.TaskState_TASK_RUNNING)
+		members = append(members, member)
+	}
+	assert.False(t, testScheduler.discovering)
+	for _, member := range members {
+		update(testScheduler, member, mesos.TaskState_TASK_LOST)
+	}
+	assert.Equal(t, Mutable, testScheduler.state, "seed-new should keep launching")
+	seed := launch(testScheduler, "5")
+	assert.Equal(t, "new", seed.Type)
+	assert.Equal(t, "", seed.Discovery)
+
+	// Neither does a scheduler taking over a cluster already launched.
+	testScheduler = newScheduler()
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{"etcd-1 localhost 0 0 0": "slave-1"}, nil
+	}
+	testScheduler.runningSnapshot = func([]string, string, string) (map[string]*config.Node, error) {
+		return map[string]*config.Node{}, nil
+	}
+	testScheduler.clock = &countingClock{Clock: clock.NewFake(time.Now())}
+	testScheduler.masterInfo = util.NewMasterInfo("master-1", 0, 0)
+	testScheduler.ReconcileAttempts = 1
+	testScheduler.SyncWaitAttempts = 1
+	testScheduler.shutdown = func() {}
+	syncdriver := &MockSchedulerDriver{}
+	syncdriver.On("ReconcileTasks", 0).Return(mesos.Status_DRIVER_RUNNING, nil)
+	syncdriver.On("ReconcileTasks", 1).Return(mesos.Status_DRIVER_RUNNING, nil)
+	testScheduler.attemptMasterSync(syncdriver)
+	testScheduler.state = Mutable
+	testScheduler.running = map[string]*config.Node{}
+	assert.Equal(t, "", launch(testScheduler, "6").Discovery)
+}
+
+func TestRejectedLaunchRequeued(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_ABORTED, errors.New("offer rescinded")).Once()
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.launchOne(mockdriver)
+
+	assert.Len(t, mockdriver.launched, 1)
+	assert.Empty(t, testScheduler.pending, "the slave should not stay reserved")
+	assert.Empty(t, testScheduler.launchAttempts)
+	assert.Equal(t, 1, len(testScheduler.launchChan),
+		"another launch should have been requested")
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().LaunchedServers)
+
+	<-testScheduler.launchChan
+	testScheduler.offerCache.Push(NewOffer("2"))
+	testScheduler.launchOne(mockdriver)
+	assert.Len(t, mockdriver.launched, 2)
+	assert.Len(t, testScheduler.pending, 1)
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().LaunchedServers,
+		"a launch should only count once its task is reported")
+
+	taskID := mockdriver.launched[1].TaskId
+	testScheduler.StatusUpdate(mockdriver, util.NewTaskStatus(taskID, mesos.TaskState_TASK_STAGING))
+	testScheduler.StatusUpdate(mockdriver, util.NewTaskStatus(taskID, mesos.TaskState_TASK_RUNNING))
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().LaunchedServers)
+	assert.Empty(t, testScheduler.pending)
+}
+
+func TestRejectedLaunchReturnsVolume(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 1024, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_ABORTED, errors.New("offer rescinded"))
+
+	withVolume := func(offerID, volumeID string) *mesos.Offer {
+		volume := util.NewScalarResource("disk", 1024)
+		volume.Role = proto.String("etcd")
+		volume.Disk = &mesos.Resource_DiskInfo{
+			Persistence: &mesos.Resource_DiskInfo_Persistence{Id: proto.String(volumeID)},
+		}
+		offer := NewOffer(offerID)
+		offer.Resources = append(offer.Resources, volume)
+		return offer
+	}
+
+	// A lost member's volume is lost again when its relaunch is rejected.
+	testScheduler.lostVolumes["vol-1"] = struct{}{}
+	testScheduler.offerCache.Push(withVolume("1", "vol-1"))
+	testScheduler.launchOne(mockdriver)
+	assert.Len(t, mockdriver.launched, 1)
+	assert.Empty(t, testScheduler.volumes)
+	_, lost := testScheduler.lostVolumes["vol-1"]
+	assert.True(t, lost, "the volume should be preferred by the next launch")
+
+	// A volume no member had used is simply released.
+	<-testScheduler.launchChan
+	testScheduler.offerCache.Push(withVolume("2", "vol-2"))
+	testScheduler.launchOne(mockdriver)
+	assert.Len(t, mockdriver.launched, 2)
+	assert.Empty(t, testScheduler.volumes)
+	assert.Equal(t, map[string]struct{}{"vol-1": {}}, testScheduler.lostVolumes)
+}
+
+func TestServerTLSAdvertisesHTTPSEndpoints(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.EtcdServerCertFile = "server.crt"
+	testScheduler.EtcdServerKeyFile = "server.key"
+	testScheduler.EtcdExecutorCertFile = "executor.crt"
+	testScheduler.EtcdExecutorKeyFile = "executor.key"
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.launchOne(mockdriver)
+
+	if len(mockdriver.launched) != 1 {
+		t.Fatalf("expected 1 launched task, got %d", len(mockdriver.launched))
+	}
+	payload := []*config.Node{}
+	if err := json.Unmarshal(mockdriver.launched[0].Data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	node := payload[0]
+	assert.Equal(t, "https", node.ClientScheme)
+	assert.Equal(t, "server.crt", node.CertFile)
+	assert.Equal(t, "server.key", node.KeyFile)
+	assert.Equal(t, "executor.crt", node.ClientCertFile,
+		"the executor needs a client certificate to change members over https")
+	assert.Equal(t, "executor.key", node.ClientKeyFile)
+	assert.Equal(t, "http", node.PeerURL()[:4], "peers should stay on http")
+
+	// The scheme is not part of the task ID, so the member must keep it
+	// from its launch once it reports in.
+	status := util.NewTaskStatus(mockdriver.launched[0].TaskId, mesos.TaskState_TASK_RUNNING)
+	status.SlaveId = util.NewSlaveID("slave-1")
+	testScheduler.StatusUpdate(mockdriver, status)
+	if len(testScheduler.RunningCopy()) != 1 {
+		t.Fatal("expected the launched member to be running")
+	}
+	server := httptest.NewServer(testScheduler.adminMux(mockdriver))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/endpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, config.JoinURL("https", node.Host, node.ClientPort)+"\n", string(body))
+}
+
+func TestRunningMemberKeepsItsSchemesAcrossFailover(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.EtcdServerCertFile = "server.crt"
+	testScheduler.EtcdServerKeyFile = "server.key"
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	stored := map[string]*config.Node{}
+	testScheduler.updateRunningSnapshot = func(running map[string]*config.Node, _ []string, _, _ string) error {
+		stored = running
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	testScheduler.offerCache.Push(NewOffer("1"))
+	testScheduler.launchOne(mockdriver)
+	if len(mockdriver.launched) != 1 {
+		t.Fatalf("expected 1 launched task, got %d", len(mockdriver.launched))
+	}
+	status := util.NewTaskStatus(mockdriver.launched[0].TaskId, mesos.TaskState_TASK_RUNNING)
+	status.SlaveId = util.NewSlaveID("slave-1")
+	testScheduler.StatusUpdate(mockdriver, status)
+
+	checkSchemes := func(running map[string]*config.Node) {
+		if len(running) != 1 {
+			t.Fatalf("expected 1 running member, got %d", len(running))
+		}
+		for _, node := range running {
+			assert.Equal(t, "https", node.ClientURL()[:5])
+			assert.Equal(t, "http:", node.PeerURL()[:5])
+		}
+	}
+	checkSchemes(testScheduler.RunningCopy())
+
+	testScheduler.snapshotRunning()
+	checkSchemes(stored)
+
+	// A scheduler taking over adopts the member, schemes and all, from the
+	// snapshot rather than from its task ID.
+	after := NewEtcdScheduler(1, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	after.reconciliationInfo[mockdriver.launched[0].TaskId.GetValue()] = "slave-1"
+	after.mut.Lock()
+	after.primeRunning(stored)
+	after.mut.Unlock()
+	checkSchemes(after.RunningCopy())
+}
+
+func TestPruneSkippedWhenQuorumAtRisk(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.running["etcd-2"] = &config.Node{Name: "etcd-2"}
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{"etcd-1": "1", "etcd-2": "2", "etcd-3": "3"}, nil
+	}
+	healthy := map[string]bool{"etcd-1": true}
+	testScheduler.memberHealthy = func(node *config.Node) bool { return healthy[node.Name] }
+	removed := []string{}
+	testScheduler.removeInstance = func(_ map[string]*config.Node, task string, _ int, _ bool) error {
+		removed = append(removed, task)
+		return nil
+	}
+
+	// Only one of the three configured members is known to be healthy.
+	assert.NoError(t, testScheduler.Prune())
+	assert.Empty(t, removed)
+
+	// A reconciled task has yet to report, so it may be etcd-3.
+	healthy["etcd-2"] = true
+	testScheduler.reconciliationInfo["etcd-3 localhost 0 0 0"] = "slave-3"
+	assert.NoError(t, testScheduler.Prune())
+	assert.Empty(t, removed)
+
+	testScheduler.heardFrom["etcd-3 localhost 0 0 0"] = struct{}{}
+	assert.NoError(t, testScheduler.Prune())
+	assert.Equal(t, []string{"etcd-3"}, removed)
+}
+
+func TestReseedsLimitedPerWindow(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 60, true, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.ReseedCooldown = 0
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.tasks["etcd-1"] = util.NewTaskID("etcd-1 localhost 0 0 0")
+	reseeds := 0
+	testScheduler.rankReseedCandidates = func(map[string]*config.Node) []rpc.NodeIndex {
+		return []rpc.NodeIndex{{RaftIndex: 1, Node: "etcd-1"}}
+	}
+	testScheduler.triggerReseed = func(*config.Node) error {
+		reseeds++
+		return nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	mockdriver := &MockSchedulerDriver{}
+
+	testScheduler.reseedCluster(mockdriver)
+	testScheduler.reseedCluster(mockdriver)
+	assert.Equal(t, 1, reseeds, "A second reseed within the window should be suppressed.")
+	stats := testScheduler.StatsCopy()
+	assert.Equal(t, uint32(1), stats.ClusterReseeds)
+	assert.Equal(t, uint32(1), stats.ReseedsSuppressed)
+
+	// An operator may still reseed by hand.
+	testScheduler.manualReseed(mockdriver)
+	assert.Equal(t, 2, reseeds, "A manual reseed should not be suppressed.")
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().ReseedsSuppressed)
+
+	// Once the window has passed, reseeding is allowed again.
+	for i := range testScheduler.reseedTimes {
+		testScheduler.reseedTimes[i] = time.Now().Add(-testScheduler.ReseedWindow)
+	}
+	testScheduler.reseedCluster(mockdriver)
+	assert.Equal(t, 3, reseeds)
+}
+
+func TestReseedWaitsForKillConfirmation(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(2, 0, 60, true, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	fakeClock := clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.clock = fakeClock
+	for _, name := range []string{"etcd-1", "etcd-2"} {
+		testScheduler.running[name] = &config.Node{Name: name}
+		testScheduler.tasks[name] = util.NewTaskID(name + " localhost 0 0 0")
+	}
+	testScheduler.rankReseedCandidates = func(map[string]*config.Node) []rpc.NodeIndex {
+		return []rpc.NodeIndex{
+			{RaftIndex: 2, Node: "etcd-1"},
+			{RaftIndex: 1, Node: "etcd-2"},
+		}
+	}
+	testScheduler.triggerReseed = func(*config.Node) error { return nil }
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("KillTask", util.NewTaskID("etcd-2 localhost 0 0 0")).
+		Return(mesos.Status_DRIVER_RUNNING, nil).Once()
+
+	done := make(chan struct{})
+	go func() {
+		testScheduler.reseedCluster(mockdriver)
+		close(done)
+	}()
+	awaitWaiter := func() {
+		for i := 0; i < 100 && fakeClock.Waiters() == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	awaitWaiter()
+	fakeClock.Advance(time.Second)
+	awaitWaiter()
+	select {
+	case <-done:
+		t.Fatal("reseed finished before the kill was confirmed")
+	default:
+	}
+	assert.Equal(t, Immutable, testScheduler.Snapshot().State)
+	mockdriver.AssertNumberOfCalls(t, "KillTask", 1)
+
+	testScheduler.StatusUpdate(mockdriver, util.NewTaskStatus(
+		util.NewTaskID("etcd-2 localhost 0 0 0"),
+		mesos.TaskState_TASK_KILLED,
+	))
+	fakeClock.Advance(2 * time.Second)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reseed did not finish once the kill was confirmed")
+	}
+	assert.Equal(t, Mutable, testScheduler.Snapshot().State)
+}
+func TestReseedHealthWaitHonorsItsOwnTimeout(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(1, 0, 10, true, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	fakeClock := clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.clock = fakeClock
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.triggerReseed = func(*config.Node) error { return nil }
+	var healthyAt time.Time
+	testScheduler.healthCheck = func(map[string]*config.Node) error {
+		if fakeClock.Now().Before(healthyAt) {
+			return etcderrors.ErrEtcdRaftStall
+		}
+		return nil
+	}
+	testScheduler.ReseedHealthBackoffCap = 2 * time.Second
+
+	reseed := func() bool {
+		result := make(chan bool)
+		go func() {
+			result <- testScheduler.reseedNode(context.Background(), "etcd-1", &MockSchedulerDriver{})
+		}()
+		for {
+			select {
+			case healthy := <-result:
+				return healthy
+			default:
+			}
+			if fakeClock.Waiters() > 0 {
+				fakeClock.Advance(2 * time.Second)
+			} else {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	// Recovery takes longer than the 10 second reseed timeout.
+	healthyAt = fakeClock.Now().Add(30 * time.Second)
+	assert.False(t, reseed())
+
+	testScheduler.ReseedHealthTimeout = time.Minute
+	healthyAt = fakeClock.Now().Add(30 * time.Second)
+	assert.True(t, reseed())
+}
+
+func TestLivelockTriggersReseedAfterTimeout(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 60, true, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	fakeClock := clock.NewFake(time.Unix(1000000, 0))
+	testScheduler.clock = fakeClock
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1"}
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{"etcd-1": "1"}, nil
+	}
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error {
+		return errors.New("livelocked")
+	}
+	reseeding := make(chan struct{}, 1)
+	testScheduler.rankReseedCandidates = func(map[string]*config.Node) []rpc.NodeIndex {
+		reseeding <- struct{}{}
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("Abort").Return(mesos.Status_DRIVER_ABORTED, nil)
+
+	reseedStarted := func() bool {
+		select {
+		case <-reseeding:
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}
+
+	// The first failed health check opens the livelock window.
+	assert.False(t, testScheduler.shouldLaunch(mockdriver))
+	fakeClock.Advance(60 * time.Second)
+	assert.False(t, testScheduler.shouldLaunch(mockdriver))
+	assert.False(t, reseedStarted(), "No reseed until the livelock outlasts the timeout.")
+
+	fakeClock.Advance(time.Nanosecond)
+	assert.False(t, testScheduler.shouldLaunch(mockdriver))
+	assert.True(t, reseedStarted(), "A reseed should start once the timeout has passed.")
+}
+
+func TestSlaveExhaustionReported(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("DeclineOffer", mock.Anything, mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	// Only two slaves exist, and both already run a member.
+	for _, id := range []string{"1", "2"} {
+		status := util.NewTaskStatus(
+			util.NewTaskID("etcd-"+id+" localhost 0 0 0"),
+			mesos.TaskState_TASK_RUNNING,
+		)
+		status.SlaveId = util.NewSlaveID("slave-" + id)
+		testScheduler.StatusUpdate(mockdriver, status)
+	}
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("1"), NewOffer("2")})
+	assert.Equal(t, uint32(1), testScheduler.StatsCopy().SlavesExhausted,
+		"Too few slaves for the desired instance count should be reported.")
+
+	// A new slave clears the condition.
+	testScheduler.ResourceOffers(mockdriver, []*mesos.Offer{NewOffer("3")})
+	assert.Equal(t, 1, testScheduler.offerCache.Len())
+	assert.Equal(t, uint32(0), testScheduler.StatsCopy().SlavesExhausted)
+}
+
+func TestServeExecutorArtifactBase(t *gotesting.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"etcd-mesos-executor", "etcd executor"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.DefaultServeMux)
+	defer server.Close()
+
+	for path, want := range map[string]string{
+		filepath.Join(dir, "etcd-mesos-executor"): "http://localhost:1234/etcd-mesos-executor",
+		filepath.Join(dir, "etcd executor"):       "http://localhost:1234/etcd%20executor",
+		filepath.Join(dir, "bin") + "/":           "http://localhost:1234/bin",
+	} {
+		uri, err := ServeExecutorArtifact(path, "localhost", 1234)
+		if assert.NoError(t, err, path) {
+			assert.Equal(t, want, *uri)
+		}
+	}
+
+	// The escaped URI is served.
+	resp, err := http.Get(server.URL + "/etcd%20executor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "etcd executor", string(body))
+
+	_, err = ServeExecutorArtifact("/", "localhost", 1234)
+	assert.Error(t, err, "A path without a file name should be rejected.")
+}
+
+func TestExecutorURIFlags(t *gotesting.T) {
+	uris := []*mesos.CommandInfo_URI{}
+	for _, value := range []string{"http://localhost:1234/etcd-mesos-executor", "/opt/etcd"} {
+		uri, err := NewExecutorURI(value, true)
+		if !assert.NoError(t, err, value) {
+			return
+		}
+		uris = append(uris, uri)
+	}
+	for _, value := range []string{"relative/etcd", "http://localhost:1234/", "http://%zz"} {
+		_, err := NewExecutorURI(value, true)
+		assert.Error(t, err, value)
+	}
+
+	testScheduler := NewEtcdScheduler(1, 0, 0, false, uris, true, 4096, 1, 256, 1)
+	info := testScheduler.newExecutorInfo(&config.Node{Name: "etcd-1"}, uris, 1000)
+	assert.Equal(t, 2, len(info.GetCommand().GetUris()))
+	for _, uri := range info.GetCommand().GetUris() {
+		assert.True(t, uri.GetExecutable(), uri.GetValue())
+		assert.True(t, uri.GetCache(), uri.GetValue())
+	}
+
+	uri, err := NewExecutorURI("/opt/etcd", false)
+	assert.NoError(t, err)
+	assert.False(t, uri.GetCache())
+}
+
+func TestLeaderMovedToPreferredZone(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	testScheduler.PreferredZone = "us-east-1a"
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1", Zone: "us-east-1b"}
+	testScheduler.running["etcd-2"] = &config.Node{Name: "etcd-2", Zone: "us-east-1c"}
+	testScheduler.running["etcd-3"] = &config.Node{Name: "etcd-3", Zone: "us-east-1a"}
+	leader := "etcd-1"
+	testScheduler.leaderMember = func(running map[string]*config.Node) (*config.Node, error) {
+		return running[leader], nil
+	}
+	moves := []string{}
+	testScheduler.moveLeader = func(_ map[string]*config.Node, target *config.Node) error {
+		moves = append(moves, target.Name)
+		leader = target.Name
+		return nil
+	}
+
+	// Safe mode holds off leadership changes along with everything else.
+	testScheduler.EnterSafeMode()
+	testScheduler.placeLeader()
+	assert.Equal(t, []string{}, moves)
+	testScheduler.LeaveSafeMode()
+
+	testScheduler.placeLeader()
+	assert.Equal(t, []string{"etcd-3"}, moves)
+
+	// Once the leader is in the preferred zone it is left there.
+	testScheduler.placeLeader()
+	assert.Equal(t, []string{"etcd-3"}, moves)
+
+	// An election elsewhere is undone on the next check, but not while the
+	// cluster is short of a member.
+	leader = "etcd-2"
+	delete(testScheduler.running, "etcd-1")
+	testScheduler.placeLeader()
+	assert.Equal(t, []string{"etcd-3"}, moves)
+	testScheduler.running["etcd-1"] = &config.Node{Name: "etcd-1", Zone: "us-east-1b"}
+	testScheduler.placeLeader()
+	assert.Equal(t, []string{"etcd-3", "etcd-3"}, moves)
+}
+
+func TestLeaderMovedToPreferredZoneOfLaunchedMember(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 0.5, 128, 1)
+	testScheduler.state = Mutable
+	testScheduler.ZoneAttribute = "zone"
+	testScheduler.PreferredZone = "us-east-1a"
+	testScheduler.reconciliationInfoFunc = func([]string, string, string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	testScheduler.updateReconciliationInfoFunc = func(map[string]string, []string, string, string) error {
+		return nil
+	}
+	testScheduler.healthCheck = func(map[string]*config.Node) error { return nil }
+	testScheduler.memberList = func(map[string]*config.Node) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("LaunchTasks", mock.Anything, mock.Anything, mock.Anything).
+		Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	for i, zone := range []string{"us-east-1b", "us-east-1c", "us-east-1a"} {
+		id := strconv.Itoa(i + 1)
+		offer := NewOffer(id)
+		offer.Attributes = []*mesos.Attribute{{
+			Name: proto.String("zone"),
+			Type: mesos.Value_TEXT.Enum(),
+			Text: &mesos.Value_Text{Value: proto.String(zone)},
+		}}
+		testScheduler.offerCache.Push(offer)
+		testScheduler.launchOne(mockdriver)
+		if len(mockdriver.launched) != i+1 {
+			t.Fatalf("expected %d launched tasks, got %d", i+1, len(mockdriver.launched))
+		}
+		status := util.NewTaskStatus(mockdriver.launched[i].TaskId, mesos.TaskState_TASK_RUNNING)
+		status.SlaveId = util.NewSlaveID("slave-" + id)
+		testScheduler.StatusUpdate(mockdriver, status)
+	}
+
+	// The zones are only known from the offers the members were launched
+	// on, not from their task IDs.
+	running := testScheduler.RunningCopy()
+	if len(running) != 3 {
+		t.Fatalf("expected 3 running members, got %d", len(running))
+	}
+	var leader, preferred string
+	for name, node := range running {
+		switch node.Zone {
+		case "us-east-1b":
+			leader = name
+		case "us-east-1a":
+			preferred = name
+		}
+	}
+	if leader == "" || preferred == "" {
+		t.Fatal("running members lost the zones they were launched in")
+	}
+	testScheduler.leaderMember = func(running map[string]*config.Node) (*config.Node, error) {
+		return running[leader], nil
+	}
+	moves := []string{}
+	testScheduler.moveLeader = func(_ map[string]*config.Node, target *config.Node) error {
+		moves = append(moves, target.Name)
+		leader = target.Name
+		return nil
+	}
+
+	testScheduler.placeLeader()
+	assert.Equal(t, []string{preferred}, moves)
+}
+
+func TestRebalanceMigratesOneCrowdedMember(t *gotesting.T) {
+	testScheduler := NewEtcdScheduler(3, 0, 0, false, []*mesos.CommandInfo_URI{}, true, 4096, 1, 256, 1)
+	testScheduler.state = Mutable
+	for name, slaveID := range map[string]string{
+		"etcd-1": "slave-1",
+		"etcd-2": "slave-1",
+		"etcd-3": "slave-2",
+	} {
+		testScheduler.running[name] = &config.Node{Name: name, SlaveID: slaveID}
+		testScheduler.tasks[name] = util.NewTaskID(name + " localhost 0 0 0")
+	}
+	mockdriver := &MockSchedulerDriver{}
+	mockdriver.On("KillTask", mock.Anything).Return(mesos.Status_DRIVER_RUNNING, nil)
+
+	// Without a free slave to move to, members stay where they are.
+	testScheduler.rebalance(mockdriver)
+	mockdriver.AssertNotCalled(t, "KillTask", mock.Anything)
+
+	testScheduler.offeredSlaves["slave-3"] = time.Now()
+	testScheduler.rebalance(mockdriver)
+	testScheduler.rebalance(mockdriver)
+	mockdriver.AssertNumberOfCalls(t, "KillTask", 1)
+	mockdriver.AssertCalled(t, "KillTask", util.NewTaskID("etcd-2 localhost 0 0 0"))
+	_, migrating := testScheduler.migrating["etcd-2"]
+	assert.True(t, migrating)
+}
@@ -0,0 +1,78 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	log "github.com/golang/glog"
+)
+
+// PeriodicStatsdReporter sends the scheduler's stats to the StatsD server
+// at StatsdAddress every StatsdInterval.
+func (s *EtcdScheduler) PeriodicStatsdReporter() {
+	for {
+		s.clock.Sleep(s.StatsdInterval)
+		if err := s.reportStatsd(); err != nil {
+			log.Errorf("Failed to send stats to %s: %v", s.StatsdAddress, err)
+		}
+	}
+}
+
+// reportStatsd sends every stat to StatsD as a gauge, in a single packet.
+func (s *EtcdScheduler) reportStatsd() error {
+	lines, err := s.statsdLines()
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("udp", s.StatsdAddress)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(strings.Join(lines, "\n")))
+	return err
+}
+
+// statsdLines returns a StatsD gauge line for each stat, named as in
+// /stats under StatsdPrefix.
+func (s *EtcdScheduler) statsdLines() ([]string, error) {
+	serializedStats, err := json.Marshal(s.StatsCopy())
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]int64{}
+	if err := json.Unmarshal(serializedStats, &stats); err != nil {
+		return nil, err
+	}
+	prefix := s.StatsdPrefix
+	if prefix != "" {
+		prefix += "."
+	}
+	lines := make([]string, 0, len(stats))
+	for name, value := range stats {
+		lines = append(lines, fmt.Sprintf("%s%s:%d|g", prefix, name, value))
+	}
+	sort.Strings(lines)
+	return lines, nil
+}
@@ -0,0 +1,178 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+
+	log "github.com/golang/glog"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/mesos/mesos-go/scheduler"
+	"golang.org/x/net/context"
+
+	"github.com/mesosphere/etcd-mesos/config"
+)
+
+var errUpgradeUnderway = errors.New("a rolling upgrade is already underway")
+
+// rollingUpgrade replaces every member not running the given version, one
+// at a time.  Each replacement is added before the member it replaces is
+// removed, so the cluster never has fewer members than it started with.
+// New members are launched with uris, if given, in place of the current
+// executor URIs.
+func (s *EtcdScheduler) rollingUpgrade(
+	driver scheduler.SchedulerDriver,
+	version string,
+	uris []*mesos.CommandInfo_URI,
+) error {
+	if !s.claimUpgrade() {
+		return errUpgradeUnderway
+	}
+	return s.runUpgrade(driver, version, uris)
+}
+
+// claimUpgrade marks a rolling upgrade as underway, returning false if one
+// already is.  A successful claim must be followed by runUpgrade, which
+// releases it.
+func (s *EtcdScheduler) claimUpgrade() bool {
+	return atomic.CompareAndSwapInt32(&s.upgrading, 0, 1)
+}
+
+// runUpgrade performs a rolling upgrade claimed with claimUpgrade.
+func (s *EtcdScheduler) runUpgrade(
+	driver scheduler.SchedulerDriver,
+	version string,
+	uris []*mesos.CommandInfo_URI,
+) error {
+	defer atomic.StoreInt32(&s.upgrading, 0)
+
+	ctx, op := s.operations.start("rolling-upgrade")
+	defer s.operations.finish(op.ID)
+
+	s.mut.Lock()
+	s.Version = version
+	if len(uris) != 0 {
+		s.executorUris = uris
+	}
+	s.mut.Unlock()
+
+	for {
+		old := s.outdatedMember(version)
+		if old == "" {
+			log.Infof("All members are running version %s.", version)
+			return nil
+		}
+		if err := s.replaceMember(ctx, driver, old, version); err != nil {
+			return err
+		}
+	}
+}
+
+// outdatedMember returns the name of a running member not at version, or
+// "" if there are none.
+func (s *EtcdScheduler) outdatedMember(version string) string {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	outdated := []string{}
+	for name, node := range s.running {
+		if node.Version != version {
+			outdated = append(outdated, name)
+		}
+	}
+	if len(outdated) == 0 {
+		return ""
+	}
+	sort.Strings(outdated)
+	return outdated[0]
+}
+
+// replaceMember launches a member at version, waits for it to be running
+// and for the cluster to be healthy, and then deconfigures and kills old.
+func (s *EtcdScheduler) replaceMember(
+	ctx context.Context,
+	driver scheduler.SchedulerDriver,
+	old string,
+	version string,
+) error {
+	atVersion := func() int {
+		count := 0
+		for _, node := range s.RunningCopy() {
+			if node.Version == version {
+				count++
+			}
+		}
+		return count
+	}
+	before := atVersion()
+
+	log.Infof("Rolling upgrade: launching a version %s member to replace %s.",
+		version, old)
+	s.mut.Lock()
+	s.desiredInstanceCount++
+	s.surge++
+	s.reviveOffers(driver)
+	s.mut.Unlock()
+	s.QueueLaunchAttempt()
+
+	err := s.waitFor(ctx, func() bool {
+		return atVersion() > before && s.healthCheck(s.RunningCopy()) == nil
+	})
+	s.mut.Lock()
+	s.desiredInstanceCount--
+	s.surge--
+	running := map[string]*config.Node{}
+	for name, node := range s.running {
+		running[name] = node
+	}
+	taskID := s.tasks[old]
+	s.mut.Unlock()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Rolling upgrade: removing %s.", old)
+	err = s.removeInstance(running, old, s.RemoveRetries, !s.RemoveQuorumGuard)
+	if err != nil {
+		return err
+	}
+	driver.KillTask(taskID)
+	return s.waitFor(ctx, func() bool {
+		_, present := s.RunningCopy()[old]
+		return !present
+	})
+}
+
+// waitFor polls done until it returns true, giving up after LaunchTimeout
+// or once ctx is cancelled.
+func (s *EtcdScheduler) waitFor(ctx context.Context, done func() bool) error {
+	deadline := s.clock.Now().Add(s.LaunchTimeout)
+	for !done() {
+		if s.clock.Now().After(deadline) {
+			return errors.New("timed out waiting for the rolling upgrade to progress")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.clock.After(s.upgradePollInterval):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Names of the long-lived goroutines that report heartbeats.  If one of
+// them wedges, for instance on a network call that never returns, the
+// cluster silently stops making progress.
+const (
+	launcherHeartbeat  = "launcher"
+	requestorHeartbeat = "launch-requestor"
+)
+
+func newHeartbeats() map[string]*int64 {
+	return map[string]*int64{
+		launcherHeartbeat:  new(int64),
+		requestorHeartbeat: new(int64),
+	}
+}
+
+// heartbeat records that the named goroutine is making progress and will
+// beat again within the given duration, or, if it is 0, that it is idle
+// waiting for work and may not beat for a while.
+func (s *EtcdScheduler) heartbeat(name string, within time.Duration) {
+	deadline := int64(0)
+	if within > 0 {
+		deadline = s.clock.Now().Add(within).UnixNano()
+	}
+	atomic.StoreInt64(s.heartbeats[name], deadline)
+}
+
+// staleGoroutines describes the goroutines that have missed a heartbeat,
+// sorted by name.
+func (s *EtcdScheduler) staleGoroutines() []string {
+	now := s.clock.Now().UnixNano()
+	stale := []string{}
+	for name, deadline := range s.heartbeats {
+		if d := atomic.LoadInt64(deadline); d != 0 && now > d {
+			stale = append(stale, fmt.Sprintf("%s is %s overdue", name,
+				time.Duration(now-d)))
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}